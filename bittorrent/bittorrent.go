@@ -4,6 +4,7 @@
 package bittorrent
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -102,25 +103,59 @@ type AnnounceRequest struct {
 	Downloaded      uint64
 	Uploaded        uint64
 
+	// TrackerID is the tracker id sent by the client, as received in an
+	// earlier announce response.
+	TrackerID string
+
+	// Key is the key (BEP 3) of the announcing client, which identifies it
+	// across changes of its IP address. It is not logged.
+	Key string
+
+	// SourceIP is the IP address the request was observed from, nil if it is
+	// unknown. It differs from the IP of Peer if the client provided its IP
+	// address, for example a private one.
+	SourceIP net.IP
+
+	// AdditionalPeers are further endpoints of the announcing client, such as
+	// the address of the other family of a dual-stack client (BEP 7).
+	// They share the ID of Peer, and at most one exists per address family
+	// other than the one of Peer.
+	AdditionalPeers []Peer
+
+	// AdditionalPeersVerified is set if the frontend vouches for the
+	// AdditionalPeers, for example because it allows IP spoofing. Otherwise
+	// they are only announced if the client announced from their address
+	// with the same key before.
+	AdditionalPeersVerified bool
+
 	Peer
 	Params
 }
 
+// Peers returns Peer followed by the AdditionalPeers of the request.
+func (r AnnounceRequest) Peers() []Peer {
+	return append([]Peer{r.Peer}, r.AdditionalPeers...)
+}
+
 // LogFields renders the current response as a set of log fields.
 func (r AnnounceRequest) LogFields() log.Fields {
 	return log.Fields{
-		"event":           r.Event,
-		"infoHash":        r.InfoHash,
-		"compact":         r.Compact,
-		"eventProvided":   r.EventProvided,
-		"numWantProvided": r.NumWantProvided,
-		"ipProvided":      r.IPProvided,
-		"numWant":         r.NumWant,
-		"left":            r.Left,
-		"downloaded":      r.Downloaded,
-		"uploaded":        r.Uploaded,
-		"peer":            r.Peer,
-		"params":          r.Params,
+		"event":                   r.Event,
+		"infoHash":                r.InfoHash,
+		"compact":                 r.Compact,
+		"eventProvided":           r.EventProvided,
+		"numWantProvided":         r.NumWantProvided,
+		"ipProvided":              r.IPProvided,
+		"numWant":                 r.NumWant,
+		"left":                    r.Left,
+		"downloaded":              r.Downloaded,
+		"uploaded":                r.Uploaded,
+		"trackerID":               r.TrackerID,
+		"sourceIP":                r.SourceIP,
+		"additionalPeers":         r.AdditionalPeers,
+		"additionalPeersVerified": r.AdditionalPeersVerified,
+		"peer":                    r.Peer,
+		"params":                  r.Params,
 	}
 }
 
@@ -134,17 +169,31 @@ type AnnounceResponse struct {
 	MinInterval time.Duration
 	IPv4Peers   []Peer
 	IPv6Peers   []Peer
+
+	// WarningMessage is shown to the user by the client, while the announce
+	// is processed normally.
+	WarningMessage string
+
+	// TrackerID is sent back by the client in subsequent announces.
+	TrackerID string
+
+	// ExternalIP is the IP address the request was observed from, sent to
+	// the client as BEP 24 external ip if not nil.
+	ExternalIP net.IP
 }
 
 // LogFields renders the current response as a set of log fields.
 func (r AnnounceResponse) LogFields() log.Fields {
 	return log.Fields{
-		"compact":     r.Compact,
-		"complete":    r.Complete,
-		"interval":    r.Interval,
-		"minInterval": r.MinInterval,
-		"ipv4Peers":   r.IPv4Peers,
-		"ipv6Peers":   r.IPv6Peers,
+		"compact":        r.Compact,
+		"complete":       r.Complete,
+		"interval":       r.Interval,
+		"minInterval":    r.MinInterval,
+		"ipv4Peers":      r.IPv4Peers,
+		"ipv6Peers":      r.IPv6Peers,
+		"warningMessage": r.WarningMessage,
+		"trackerID":      r.TrackerID,
+		"externalIP":     r.ExternalIP,
 	}
 }
 
@@ -180,11 +229,15 @@ func (sr ScrapeResponse) LogFields() log.Fields {
 }
 
 // Scrape represents the state of a swarm that is returned in a scrape response.
+//
+// Incomplete counts leechers and partial seeds, Downloaders only the leechers
+// (BEP 21).
 type Scrape struct {
-	InfoHash   InfoHash
-	Snatches   uint32
-	Complete   uint32
-	Incomplete uint32
+	InfoHash    InfoHash
+	Snatches    uint32
+	Complete    uint32
+	Incomplete  uint32
+	Downloaders uint32
 }
 
 // AddressFamily is the address family of an IP address.
@@ -217,6 +270,29 @@ func (ip IP) String() string {
 	return ip.IP.String()
 }
 
+// IsPrivate reports whether ip is a private (RFC 1918, RFC 4193), loopback,
+// link-local or shared (CGNAT, RFC 6598) address, which peers on the public
+// internet cannot connect to.
+func (ip IP) IsPrivate() bool {
+	if ip4 := ip.IP.To4(); ip4 != nil {
+		switch ip4[0] {
+		case 10, 127:
+			return true
+		case 100:
+			return ip4[1]&0xc0 == 64
+		case 169:
+			return ip4[1] == 254
+		case 172:
+			return ip4[1]&0xf0 == 16
+		case 192:
+			return ip4[1] == 168
+		}
+		return false
+	}
+	return len(ip.IP) == net.IPv6len &&
+		(ip.IP.Equal(net.IPv6loopback) || ip.IP[0]&0xfe == 0xfc || ip.IP[0] == 0xfe && ip.IP[1]&0xc0 == 0x80)
+}
+
 // Peer represents the connection details of a peer that is returned in an
 // announce response.
 type Peer struct {
@@ -253,3 +329,60 @@ type ClientError string
 
 // Error implements the error interface for ClientError.
 func (c ClientError) Error() string { return string(c) }
+
+// WithCode returns the error with a machine-readable code, such as
+// "banned_passkey".
+func (c ClientError) WithCode(code string) DetailedClientError {
+	return DetailedClientError{ClientError: c, Code: code}
+}
+
+// WithRetryIn returns the error with the time clients should wait before
+// retrying, see DetailedClientError.RetryIn.
+func (c ClientError) WithRetryIn(d time.Duration) DetailedClientError {
+	return DetailedClientError{ClientError: c, RetryIn: d}
+}
+
+// RetryNever is the RetryIn of errors clients should not retry after.
+const RetryNever time.Duration = -1
+
+// DetailedClientError is a ClientError along with details for clients that
+// understand them.
+//
+// It unwraps to its ClientError, so it is handled like any other ClientError
+// by code that is unaware of the details.
+type DetailedClientError struct {
+	ClientError
+
+	// Code is a machine-readable code of the error, empty if unspecified.
+	Code string
+
+	// RetryIn is the time clients should wait before retrying, zero if
+	// unspecified or RetryNever.
+	RetryIn time.Duration
+}
+
+// Unwrap returns the underlying ClientError.
+func (e DetailedClientError) Unwrap() error { return e.ClientError }
+
+// WithCode returns the error with a machine-readable code.
+func (e DetailedClientError) WithCode(code string) DetailedClientError {
+	e.Code = code
+	return e
+}
+
+// WithRetryIn returns the error with the time clients should wait before
+// retrying.
+func (e DetailedClientError) WithRetryIn(d time.Duration) DetailedClientError {
+	e.RetryIn = d
+	return e
+}
+
+// ClientErrorDetails returns the code and retry time of err, if it is or wraps
+// a DetailedClientError.
+func ClientErrorDetails(err error) (code string, retryIn time.Duration) {
+	var detailed DetailedClientError
+	if errors.As(err, &detailed) {
+		return detailed.Code, detailed.RetryIn
+	}
+	return "", 0
+}
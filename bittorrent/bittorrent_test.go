@@ -1,9 +1,11 @@
 package bittorrent
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -51,3 +53,63 @@ func TestPeer_String(t *testing.T) {
 		require.Equal(t, c.expected, got)
 	}
 }
+
+func TestIP_IsPrivate(t *testing.T) {
+	table := []struct {
+		ip       string
+		expected bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.32.0.1", false},
+		{"192.168.1.10", true},
+		{"100.64.0.1", true},
+		{"100.128.0.1", false},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"203.0.113.7", false},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"2001:db8::1", false},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.ip, func(t *testing.T) {
+			require.Equal(t, tt.expected, IP{IP: net.ParseIP(tt.ip)}.IsPrivate())
+		})
+	}
+}
+
+func TestDetailedClientError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", ClientError("banned").WithCode("banned_passkey").WithRetryIn(time.Hour))
+
+	// Detailed errors are still client errors.
+	var clientErr ClientError
+	require.True(t, errors.As(err, &clientErr))
+	require.Equal(t, ClientError("banned"), clientErr)
+
+	code, retryIn := ClientErrorDetails(err)
+	require.Equal(t, "banned_passkey", code)
+	require.Equal(t, time.Hour, retryIn)
+
+	code, retryIn = ClientErrorDetails(ClientError("banned"))
+	require.Equal(t, "", code)
+	require.Equal(t, time.Duration(0), retryIn)
+}
+
+func TestSanitizeAnnounceAdditionalPeers(t *testing.T) {
+	id := PeerIDFromString("-XX1000-abcdefghijkl")
+	r := &AnnounceRequest{
+		Peer: Peer{ID: id, IP: IP{IP: net.ParseIP("192.0.2.1")}, Port: 6881},
+		AdditionalPeers: []Peer{
+			{IP: IP{IP: net.ParseIP("198.51.100.7")}},
+			{IP: IP{IP: net.ParseIP("2001:db8::1")}},
+			{IP: IP{IP: net.ParseIP("2001:db8::2")}, Port: 6882},
+			{IP: IP{IP: net.IP{1, 2}}},
+		},
+	}
+	require.Nil(t, SanitizeAnnounce(r, 50, 50))
+	require.Equal(t, []Peer{{ID: id, IP: IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: IPv6}, Port: 6881}}, r.AdditionalPeers)
+	require.Len(t, r.Peers(), 2)
+}
@@ -25,6 +25,11 @@ const (
 	// Completed is the event sent by a BitTorrent client when it finishes
 	// downloading all of the required chunks.
 	Completed
+
+	// Paused is the event sent by a BitTorrent client that is a partial seed
+	// (BEP 21): it does not download any more, but may upload the pieces it
+	// has.
+	Paused
 )
 
 var (
@@ -37,6 +42,7 @@ func init() {
 	eventToString[Started] = "started"
 	eventToString[Stopped] = "stopped"
 	eventToString[Completed] = "completed"
+	eventToString[Paused] = "paused"
 
 	stringToEvent[""] = None
 
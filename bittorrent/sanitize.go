@@ -14,6 +14,9 @@ var ErrInvalidPort = ClientError("invalid port")
 
 // SanitizeAnnounce enforces a max and default NumWant and coerces the peer's
 // IP address into the proper format.
+//
+// AdditionalPeers with an invalid IP address or an address family that is
+// already announced are dropped, those without a port use the one of Peer.
 func SanitizeAnnounce(r *AnnounceRequest, maxNumWant, defaultNumWant uint32) error {
 	if r.Port == 0 {
 		return ErrInvalidPort
@@ -25,15 +28,26 @@ func SanitizeAnnounce(r *AnnounceRequest, maxNumWant, defaultNumWant uint32) err
 		r.NumWant = maxNumWant
 	}
 
-	if ip := r.Peer.IP.To4(); ip != nil {
-		r.Peer.IP.IP = ip
-		r.Peer.IP.AddressFamily = IPv4
-	} else if len(r.Peer.IP.IP) == net.IPv6len { // implies r.Peer.IP.To4() == nil
-		r.Peer.IP.AddressFamily = IPv6
-	} else {
+	if !sanitizeIP(&r.Peer.IP) {
 		return ErrInvalidIP
 	}
 
+	families := map[AddressFamily]bool{r.Peer.IP.AddressFamily: true}
+	additional := r.AdditionalPeers[:0]
+	for _, p := range r.AdditionalPeers {
+		if !sanitizeIP(&p.IP) || families[p.IP.AddressFamily] {
+			continue
+		}
+		families[p.IP.AddressFamily] = true
+
+		p.ID = r.Peer.ID
+		if p.Port == 0 {
+			p.Port = r.Peer.Port
+		}
+		additional = append(additional, p)
+	}
+	r.AdditionalPeers = additional
+
 	log.Debug("sanitized announce", r, log.Fields{
 		"maxNumWant":     maxNumWant,
 		"defaultNumWant": defaultNumWant,
@@ -41,6 +55,20 @@ func SanitizeAnnounce(r *AnnounceRequest, maxNumWant, defaultNumWant uint32) err
 	return nil
 }
 
+// sanitizeIP coerces an IP address into the proper format and sets its
+// address family. It reports whether the address is valid.
+func sanitizeIP(ip *IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip.IP = ip4
+		ip.AddressFamily = IPv4
+	} else if len(ip.IP) == net.IPv6len { // implies ip.To4() == nil
+		ip.AddressFamily = IPv6
+	} else {
+		return false
+	}
+	return true
+}
+
 // SanitizeScrape enforces a max number of infohashes for a single scrape
 // request.
 func SanitizeScrape(r *ScrapeRequest, maxScrapeInfoHashes uint32) error {
@@ -4,17 +4,24 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/chihaya/chihaya/frontend/grpc"
 	"github.com/chihaya/chihaya/frontend/http"
 	"github.com/chihaya/chihaya/frontend/udp"
 	"github.com/chihaya/chihaya/middleware"
 
 	// Imports to register middleware drivers.
 	_ "github.com/chihaya/chihaya/middleware/clientapproval"
+	_ "github.com/chihaya/chihaya/middleware/connectability"
+	_ "github.com/chihaya/chihaya/middleware/external"
+	_ "github.com/chihaya/chihaya/middleware/infohashalias"
 	_ "github.com/chihaya/chihaya/middleware/jwt"
 	_ "github.com/chihaya/chihaya/middleware/jwtoptional"
+	_ "github.com/chihaya/chihaya/middleware/lua"
 	_ "github.com/chihaya/chihaya/middleware/passkeyapproval"
 	_ "github.com/chihaya/chihaya/middleware/peerlimit"
 	_ "github.com/chihaya/chihaya/middleware/torrentapproval"
@@ -31,19 +38,58 @@ type storageConfig struct {
 	Config interface{} `yaml:"config"`
 }
 
+// defaultTrackerName is the name of the tracker instance configured by the
+// top-level fields of Config.
+const defaultTrackerName = "default"
+
 // Config represents the configuration used for executing Chihaya.
 type Config struct {
 	middleware.ResponseConfig `yaml:",inline"`
 	MetricsAddr               string                  `yaml:"metrics_addr"`
 	HTTPConfig                http.Config             `yaml:"http"`
 	UDPConfig                 udp.Config              `yaml:"udp"`
+	GRPCConfig                grpc.Config             `yaml:"grpc"`
+	Storage                   storageConfig           `yaml:"storage"`
+	PreHooks                  []middleware.HookConfig `yaml:"prehooks"`
+	PostHooks                 []middleware.HookConfig `yaml:"posthooks"`
+	Trackers                  []TrackerConfig         `yaml:"trackers"`
+
+	// GracefulUpgrade enables binary upgrades on UpgradeSignals: a new
+	// process is started from the executable with all listening sockets of
+	// this one, which shuts down once the new process is ready or carries on
+	// if it fails to start within UpgradeTimeout.
+	GracefulUpgrade bool          `yaml:"graceful_upgrade"`
+	UpgradeTimeout  time.Duration `yaml:"upgrade_timeout"`
+}
+
+// defaultUpgradeTimeout is the default time a new process has to become ready
+// during a graceful upgrade.
+const defaultUpgradeTimeout = 30 * time.Second
+
+// TrackerConfig represents the configuration of a single named tracker
+// instance. Every instance has its own hooks and storage, but shares the
+// HTTP frontend, the metrics server and the process lifecycle with all other
+// instances.
+//
+// HTTP requests are routed to the first instance whose Hosts contain the Host
+// header of the request and whose RoutePrefix is a prefix of the request
+// path. Empty Hosts or an empty RoutePrefix match any request. A RoutePrefix
+// must start with a slash, trailing slashes are ignored.
+// UDP and gRPC requests are routed by the address they were received on.
+type TrackerConfig struct {
+	Name                      string                  `yaml:"name"`
+	Hosts                     []string                `yaml:"hosts"`
+	RoutePrefix               string                  `yaml:"route_prefix"`
+	middleware.ResponseConfig `yaml:",inline"`
+	UDPConfig                 udp.Config              `yaml:"udp"`
+	GRPCConfig                grpc.Config             `yaml:"grpc"`
 	Storage                   storageConfig           `yaml:"storage"`
 	PreHooks                  []middleware.HookConfig `yaml:"prehooks"`
 	PostHooks                 []middleware.HookConfig `yaml:"posthooks"`
 }
 
 // PreHookNames returns only the names of the configured middleware.
-func (cfg Config) PreHookNames() (names []string) {
+func (cfg TrackerConfig) PreHookNames() (names []string) {
 	for _, hook := range cfg.PreHooks {
 		names = append(names, hook.Name)
 	}
@@ -52,7 +98,7 @@ func (cfg Config) PreHookNames() (names []string) {
 }
 
 // PostHookNames returns only the names of the configured middleware.
-func (cfg Config) PostHookNames() (names []string) {
+func (cfg TrackerConfig) PostHookNames() (names []string) {
 	for _, hook := range cfg.PostHooks {
 		names = append(names, hook.Name)
 	}
@@ -60,6 +106,69 @@ func (cfg Config) PostHookNames() (names []string) {
 	return
 }
 
+// TrackerConfigs returns the configurations of all tracker instances.
+//
+// The named instances configured in Trackers come first, so that they take
+// precedence when routing HTTP requests. If a top-level storage is
+// configured, the top-level fields make up an additional instance named
+// "default", which matches every HTTP request. Top-level frontends and hooks
+// without a top-level storage are an error, as they would not be served.
+func (cfg Config) TrackerConfigs() ([]TrackerConfig, error) {
+	trackers := make([]TrackerConfig, 0, len(cfg.Trackers)+1)
+	names := make(map[string]struct{})
+	for _, tc := range cfg.Trackers {
+		if tc.Name == "" {
+			return nil, errors.New("tracker instances must have a name")
+		}
+		if _, dup := names[tc.Name]; dup {
+			return nil, errors.New("duplicate tracker instance name: " + tc.Name)
+		}
+		names[tc.Name] = struct{}{}
+
+		prefix, err := normalizeRoutePrefix(tc.RoutePrefix)
+		if err != nil {
+			return nil, errors.New("tracker instance " + tc.Name + ": " + err.Error())
+		}
+		tc.RoutePrefix = prefix
+		trackers = append(trackers, tc)
+	}
+
+	if cfg.Storage.Name != "" {
+		if _, dup := names[defaultTrackerName]; dup {
+			return nil, errors.New("tracker instance name is reserved: " + defaultTrackerName)
+		}
+		trackers = append(trackers, TrackerConfig{
+			Name:           defaultTrackerName,
+			ResponseConfig: cfg.ResponseConfig,
+			UDPConfig:      cfg.UDPConfig,
+			GRPCConfig:     cfg.GRPCConfig,
+			Storage:        cfg.Storage,
+			PreHooks:       cfg.PreHooks,
+			PostHooks:      cfg.PostHooks,
+		})
+	} else if cfg.UDPConfig.Enabled() || cfg.GRPCConfig.Addr != "" || len(cfg.PreHooks) != 0 || len(cfg.PostHooks) != 0 {
+		return nil, errors.New("top-level udp, grpc, prehooks and posthooks require a top-level storage, configure them in trackers instead")
+	}
+
+	if len(trackers) == 0 {
+		return nil, errors.New("no storage or tracker instances configured")
+	}
+
+	return trackers, nil
+}
+
+// normalizeRoutePrefix returns a route prefix without trailing slashes, so
+// that it can be joined with routes and ends at a path segment.
+func normalizeRoutePrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return "", errors.New("route_prefix must start with /: " + prefix)
+	}
+	return strings.TrimRight(prefix, "/"), nil
+}
+
 // ConfigFile represents a namespaced YAML configation file.
 type ConfigFile struct {
 	Chihaya Config `yaml:"chihaya"`
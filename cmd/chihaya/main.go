@@ -4,7 +4,9 @@ package main
 import (
 	"context"
 	"errors"
+	"os"
 	"os/signal"
+	"reflect"
 	"runtime"
 	"strings"
 	"syscall"
@@ -13,9 +15,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/chihaya/chihaya/frontend/grpc"
 	"github.com/chihaya/chihaya/frontend/http"
 	"github.com/chihaya/chihaya/frontend/udp"
 	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/pkg/listenfd"
 	"github.com/chihaya/chihaya/pkg/log"
 	"github.com/chihaya/chihaya/pkg/metrics"
 	"github.com/chihaya/chihaya/pkg/stop"
@@ -24,10 +28,25 @@ import (
 
 // Run represents the state of a running instance of Chihaya.
 type Run struct {
-	configFilePath string
-	peerStore      storage.PeerStore
-	logic          *middleware.Logic
-	sg             *stop.Group
+	configFilePath  string
+	trackers        []*trackerInstance
+	sg              *stop.Group
+	gracefulUpgrade bool
+	upgradeTimeout  time.Duration
+}
+
+// trackerInstance represents the state of a single named tracker instance.
+type trackerInstance struct {
+	name      string
+	peerStore peerStoreInstance
+	logic     *middleware.Logic
+}
+
+// peerStoreInstance is a peer store together with the config it was created
+// with.
+type peerStoreInstance struct {
+	storage.PeerStore
+	cfg storageConfig
 }
 
 // NewRun runs an instance of Chihaya.
@@ -40,64 +59,137 @@ func NewRun(configFilePath string) (*Run, error) {
 }
 
 // Start begins an instance of Chihaya.
-// It is optional to provide an instance of the peer store to avoid the
-// creation of a new one.
-func (r *Run) Start(ps storage.PeerStore) error {
+// It is optional to provide the peer stores of the tracker instances, keyed
+// by their name, to avoid the creation of new ones. A peer store is only
+// reused if its tracker instance still exists and its storage config did not
+// change; all other provided peer stores are stopped.
+func (r *Run) Start(peerStores map[string]peerStoreInstance) error {
 	configFile, err := ParseConfigFile(r.configFilePath)
 	if err != nil {
 		return errors.New("failed to read config: " + err.Error())
 	}
 	cfg := configFile.Chihaya
 
+	trackerCfgs, err := cfg.TrackerConfigs()
+	if err != nil {
+		return errors.New("failed to validate tracker config: " + err.Error())
+	}
+
+	r.gracefulUpgrade = cfg.GracefulUpgrade
+	r.upgradeTimeout = cfg.UpgradeTimeout
+	if r.upgradeTimeout <= 0 {
+		r.upgradeTimeout = defaultUpgradeTimeout
+	}
+
 	r.sg = stop.NewGroup()
 
 	log.Info("starting metrics server", log.Fields{"addr": cfg.MetricsAddr})
 	r.sg.Add(metrics.NewServer(cfg.MetricsAddr))
 
+	reused := make(map[string]peerStoreInstance, len(peerStores))
+	for _, tc := range trackerCfgs {
+		if ps, ok := peerStores[tc.Name]; ok && reflect.DeepEqual(ps.cfg, tc.Storage) {
+			reused[tc.Name] = ps
+			delete(peerStores, tc.Name)
+		}
+	}
+	if len(peerStores) != 0 {
+		log.Info("stopping peer stores of changed or removed trackers")
+		storeGroup := stop.NewGroup()
+		for _, ps := range peerStores {
+			storeGroup.Add(ps)
+		}
+		if errs := storeGroup.Stop().Wait(); len(errs) != 0 {
+			return combineErrors("failed while shutting down peer store", errs)
+		}
+	}
+
+	r.trackers = nil
+	tenants := make([]http.Tenant, 0, len(trackerCfgs))
+	for _, tc := range trackerCfgs {
+		t, err := startTracker(tc, reused[tc.Name].PeerStore)
+		if err != nil {
+			return err
+		}
+		r.trackers = append(r.trackers, t)
+
+		tenants = append(tenants, http.Tenant{
+			Name:        tc.Name,
+			Hosts:       tc.Hosts,
+			RoutePrefix: tc.RoutePrefix,
+			Logic:       t.logic,
+		})
+
+		if tc.UDPConfig.Enabled() {
+			log.Info("starting UDP frontend", log.Fields{"tracker": tc.Name}, tc.UDPConfig)
+			udpfe, err := udp.NewFrontend(t.logic, tc.UDPConfig)
+			if err != nil {
+				return err
+			}
+			r.sg.Add(udpfe)
+		}
+
+		if tc.GRPCConfig.Addr != "" {
+			log.Info("starting gRPC frontend", log.Fields{"tracker": tc.Name}, tc.GRPCConfig)
+			grpcfe, err := grpc.NewFrontend(t.logic, tc.GRPCConfig)
+			if err != nil {
+				return err
+			}
+			r.sg.Add(grpcfe)
+		}
+	}
+
+	if cfg.HTTPConfig.Enabled() {
+		log.Info("starting HTTP frontend", cfg.HTTPConfig)
+		httpfe, err := http.NewMultiTenantFrontend(tenants, cfg.HTTPConfig)
+		if err != nil {
+			return err
+		}
+		r.sg.Add(httpfe)
+	}
+
+	return nil
+}
+
+// startTracker creates the storage and logic of a tracker instance.
+// It is optional to provide an instance of the peer store to avoid the
+// creation of a new one.
+func startTracker(cfg TrackerConfig, ps storage.PeerStore) (*trackerInstance, error) {
+	var err error
 	if ps == nil {
-		log.Info("starting storage", log.Fields{"name": cfg.Storage.Name})
+		log.Info("starting storage", log.Fields{"tracker": cfg.Name, "name": cfg.Storage.Name})
 		ps, err = storage.NewPeerStore(cfg.Storage.Name, cfg.Storage.Config)
 		if err != nil {
-			return errors.New("failed to create storage: " + err.Error())
+			return nil, errors.New("failed to create storage: " + err.Error())
 		}
-		log.Info("started storage", ps)
+		log.Info("started storage", log.Fields{"tracker": cfg.Name}, ps)
 	}
-	r.peerStore = ps
 
 	preHooks, err := middleware.HooksFromHookConfigs(cfg.PreHooks)
 	if err != nil {
-		return errors.New("failed to validate hook config: " + err.Error())
+		return nil, errors.New("failed to validate hook config: " + err.Error())
 	}
 	postHooks, err := middleware.HooksFromHookConfigs(cfg.PostHooks)
 	if err != nil {
-		return errors.New("failed to validate hook config: " + err.Error())
+		return nil, errors.New("failed to validate hook config: " + err.Error())
 	}
 
 	log.Info("starting tracker logic", log.Fields{
+		"tracker":   cfg.Name,
 		"prehooks":  cfg.PreHookNames(),
 		"posthooks": cfg.PostHookNames(),
 	})
-	r.logic = middleware.NewLogic(cfg.ResponseConfig, r.peerStore, preHooks, postHooks)
-
-	if cfg.HTTPConfig.Addr != "" {
-		log.Info("starting HTTP frontend", cfg.HTTPConfig)
-		httpfe, err := http.NewFrontend(r.logic, cfg.HTTPConfig)
-		if err != nil {
-			return err
-		}
-		r.sg.Add(httpfe)
-	}
 
-	if cfg.UDPConfig.Addr != "" {
-		log.Info("starting UDP frontend", cfg.UDPConfig)
-		udpfe, err := udp.NewFrontend(r.logic, cfg.UDPConfig)
-		if err != nil {
-			return err
-		}
-		r.sg.Add(udpfe)
+	logic, err := middleware.NewLogic(cfg.ResponseConfig, ps, preHooks, postHooks)
+	if err != nil {
+		return nil, errors.New("failed to create tracker logic: " + err.Error())
 	}
 
-	return nil
+	return &trackerInstance{
+		name:      cfg.Name,
+		peerStore: peerStoreInstance{PeerStore: ps, cfg: cfg.Storage},
+		logic:     logic,
+	}, nil
 }
 
 func combineErrors(prefix string, errs []error) error {
@@ -110,26 +202,42 @@ func combineErrors(prefix string, errs []error) error {
 }
 
 // Stop shuts down an instance of Chihaya.
-func (r *Run) Stop(keepPeerStore bool) (storage.PeerStore, error) {
+//
+// If keepPeerStores is true, the peer stores of all tracker instances are
+// kept running and returned, keyed by the name of their instance.
+func (r *Run) Stop(keepPeerStores bool) (map[string]peerStoreInstance, error) {
 	log.Debug("stopping frontends and metrics server")
 	if errs := r.sg.Stop().Wait(); len(errs) != 0 {
 		return nil, combineErrors("failed while shutting down frontends", errs)
 	}
 
 	log.Debug("stopping logic")
-	if errs := r.logic.Stop().Wait(); len(errs) != 0 {
+	logicGroup := stop.NewGroup()
+	for _, t := range r.trackers {
+		logicGroup.Add(t.logic)
+	}
+	if errs := logicGroup.Stop().Wait(); len(errs) != 0 {
 		return nil, combineErrors("failed while shutting down middleware", errs)
 	}
 
-	if !keepPeerStore {
-		log.Debug("stopping peer store")
-		if errs := r.peerStore.Stop().Wait(); len(errs) != 0 {
+	peerStores := make(map[string]peerStoreInstance, len(r.trackers))
+	for _, t := range r.trackers {
+		peerStores[t.name] = t.peerStore
+	}
+
+	if !keepPeerStores {
+		log.Debug("stopping peer stores")
+		storeGroup := stop.NewGroup()
+		for _, t := range r.trackers {
+			storeGroup.Add(t.peerStore)
+		}
+		if errs := storeGroup.Stop().Wait(); len(errs) != 0 {
 			return nil, combineErrors("failed while shutting down peer store", errs)
 		}
-		r.peerStore = nil
+		return nil, nil
 	}
 
-	return r.peerStore, nil
+	return peerStores, nil
 }
 
 // RootRunCmdFunc implements a Cobra command that runs an instance of Chihaya
@@ -145,19 +253,48 @@ func RootRunCmdFunc(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// Sockets passed to this process that no frontend was configured for
+	// would only swallow traffic.
+	listenfd.Default().CloseUnused()
+	if err := listenfd.Default().Ready(); err != nil {
+		return errors.New("failed to signal readiness: " + err.Error())
+	}
+
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	reload, _ := signal.NotifyContext(context.Background(), ReloadSignals...)
+	upgrade := make(chan os.Signal, 1)
+	if len(UpgradeSignals) != 0 {
+		signal.Notify(upgrade, UpgradeSignals...)
+	}
 
 	for {
 		select {
+		case <-upgrade:
+			if !r.gracefulUpgrade {
+				log.Warn("ignoring upgrade signal; graceful_upgrade is disabled")
+				continue
+			}
+
+			log.Info("upgrading; received upgrade signal")
+			if err := listenfd.Default().Upgrade(r.upgradeTimeout); err != nil {
+				log.Error("failed to upgrade, continuing to serve", log.Err(err))
+				continue
+			}
+
+			log.Info("shutting down; new process took over")
+			if _, err := r.Stop(false); err != nil {
+				return err
+			}
+
+			return nil
 		case <-reload.Done():
 			log.Info("reloading; received reload signal")
-			peerStore, err := r.Stop(true)
+			peerStores, err := r.Stop(true)
 			if err != nil {
 				return err
 			}
 
-			if err := r.Start(peerStore); err != nil {
+			if err := r.Start(peerStores); err != nil {
 				return err
 			}
 		case <-ctx.Done():
@@ -12,4 +12,11 @@ import (
 // when a configuration reload is requested.
 var ReloadSignals = []os.Signal{
 	syscall.SIGUSR1,
+	syscall.SIGHUP,
+}
+
+// UpgradeSignals are the signals that the current OS will send to the process
+// when a graceful upgrade is requested.
+var UpgradeSignals = []os.Signal{
+	syscall.SIGUSR2,
 }
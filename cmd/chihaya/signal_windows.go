@@ -11,3 +11,7 @@ import (
 var ReloadSignals = []os.Signal{
 	syscall.SIGHUP,
 }
+
+// UpgradeSignals is empty, Windows does not support passing sockets to new
+// processes.
+var UpgradeSignals []os.Signal
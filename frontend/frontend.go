@@ -20,6 +20,9 @@ type TrackerLogic interface {
 
 	// AfterAnnounce does something with the results of an Announce after it
 	// has been completed.
+	//
+	// Frontends call this synchronously; implementations are responsible for
+	// deferring expensive work.
 	AfterAnnounce(context.Context, *bittorrent.AnnounceRequest, *bittorrent.AnnounceResponse)
 
 	// HandleScrape generates a response for a Scrape.
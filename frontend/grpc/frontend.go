@@ -0,0 +1,210 @@
+// Package grpc implements a gRPC frontend, which allows internal services to
+// announce to and observe swarms over a typed API.
+//
+// Like the HTTP and UDP frontends, the IP address of an announcing peer is that
+// of the caller. Trusted callers announcing on behalf of others may be allowed
+// to provide it in the request with AllowIPSpoofing.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/chihaya/chihaya/frontend"
+	"github.com/chihaya/chihaya/frontend/grpc/trackerpb"
+	"github.com/chihaya/chihaya/pkg/listenfd"
+	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/stop"
+)
+
+// Config represents all of the configurable options for a gRPC BitTorrent
+// tracker frontend.
+type Config struct {
+	Addr        string `yaml:"addr"`
+	TLSCertPath string `yaml:"tls_cert_path"`
+	TLSKeyPath  string `yaml:"tls_key_path"`
+
+	// WatchInterval is the interval in which watched swarms are checked for
+	// changes.
+	WatchInterval time.Duration `yaml:"watch_interval"`
+
+	MaxNumWant          uint32 `yaml:"max_numwant"`
+	DefaultNumWant      uint32 `yaml:"default_numwant"`
+	MaxScrapeInfoHashes uint32 `yaml:"max_scrape_infohashes"`
+
+	// AllowIPSpoofing makes announces use the IP address of the peer in the
+	// request instead of the address of the caller, if provided.
+	AllowIPSpoofing bool `yaml:"allow_ip_spoofing"`
+
+	EnableRequestTiming bool `yaml:"enable_request_timing"`
+}
+
+// LogFields renders the current config as a set of Logrus fields.
+func (cfg Config) LogFields() log.Fields {
+	return log.Fields{
+		"addr":                cfg.Addr,
+		"tlsCertPath":         cfg.TLSCertPath,
+		"tlsKeyPath":          cfg.TLSKeyPath,
+		"watchInterval":       cfg.WatchInterval,
+		"maxNumWant":          cfg.MaxNumWant,
+		"defaultNumWant":      cfg.DefaultNumWant,
+		"maxScrapeInfoHashes": cfg.MaxScrapeInfoHashes,
+		"allowIPSpoofing":     cfg.AllowIPSpoofing,
+		"enableRequestTiming": cfg.EnableRequestTiming,
+	}
+}
+
+// Default config constants.
+const (
+	defaultWatchInterval       = 5 * time.Second
+	defaultMaxNumWant          = 100
+	defaultDefaultNumWant      = 50
+	defaultMaxScrapeInfoHashes = 50
+)
+
+// Validate sanity checks values set in a config and returns a new config with
+// default values replacing anything that is invalid.
+//
+// This function warns to the logger when a value is changed.
+func (cfg Config) Validate() Config {
+	validcfg := cfg
+
+	if cfg.WatchInterval <= 0 {
+		validcfg.WatchInterval = defaultWatchInterval
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "grpc.WatchInterval",
+			"provided": cfg.WatchInterval,
+			"default":  validcfg.WatchInterval,
+		})
+	}
+
+	if cfg.MaxNumWant <= 0 {
+		validcfg.MaxNumWant = defaultMaxNumWant
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "grpc.MaxNumWant",
+			"provided": cfg.MaxNumWant,
+			"default":  validcfg.MaxNumWant,
+		})
+	}
+
+	if cfg.DefaultNumWant <= 0 {
+		validcfg.DefaultNumWant = defaultDefaultNumWant
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "grpc.DefaultNumWant",
+			"provided": cfg.DefaultNumWant,
+			"default":  validcfg.DefaultNumWant,
+		})
+	}
+
+	if cfg.MaxScrapeInfoHashes <= 0 {
+		validcfg.MaxScrapeInfoHashes = defaultMaxScrapeInfoHashes
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "grpc.MaxScrapeInfoHashes",
+			"provided": cfg.MaxScrapeInfoHashes,
+			"default":  validcfg.MaxScrapeInfoHashes,
+		})
+	}
+
+	return validcfg
+}
+
+// Frontend serves the gRPC tracker service.
+type Frontend struct {
+	srv      *grpc.Server
+	listener net.Listener
+	closing  chan struct{}
+
+	logic frontend.TrackerLogic
+	Config
+}
+
+// NewFrontend creates a new instance of a gRPC Frontend that asynchronously
+// serves requests.
+func NewFrontend(logic frontend.TrackerLogic, provided Config) (*Frontend, error) {
+	cfg := provided.Validate()
+
+	if cfg.Addr == "" {
+		return nil, errors.New("must specify addr")
+	}
+	if (cfg.TLSCertPath == "") != (cfg.TLSKeyPath == "") {
+		return nil, errors.New("must specify both tls_cert_path and tls_key_path or neither")
+	}
+
+	var opts []grpc.ServerOption
+	if cfg.TLSCertPath != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertPath, cfg.TLSKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	l, err := listenfd.Listen("tcp", cfg.Addr, func() (net.Listener, error) {
+		return net.Listen("tcp", cfg.Addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Frontend{
+		srv:      grpc.NewServer(opts...),
+		listener: l,
+		closing:  make(chan struct{}),
+		logic:    logic,
+		Config:   cfg,
+	}
+	trackerpb.RegisterTrackerServer(f.srv, &server{f: f})
+
+	go func() {
+		if err := f.srv.Serve(l); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			log.Fatal("failed while serving grpc", log.Err(err))
+		}
+	}()
+
+	return f, nil
+}
+
+// Addr returns the address the Frontend is listening on.
+func (f *Frontend) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Stop provides a thread-safe way to shutdown a currently running Frontend.
+//
+// Streams watching swarms are ended, unary calls are finished first.
+func (f *Frontend) Stop() stop.Result {
+	select {
+	case <-f.closing:
+		return stop.AlreadyStopped
+	default:
+	}
+
+	c := make(stop.Channel)
+	go func() {
+		close(f.closing)
+		f.srv.GracefulStop()
+		c.Done()
+	}()
+
+	return c.Result()
+}
+
+// detachedContext carries the values of a context, but not its deadline and
+// cancellation, so that post-hooks run after a call completed.
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{context.Background(), ctx}
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
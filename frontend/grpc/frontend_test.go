@@ -0,0 +1,116 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/chihaya/chihaya/frontend/grpc/trackerpb"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/storage"
+	_ "github.com/chihaya/chihaya/storage/memory"
+)
+
+func TestTrackerService(t *testing.T) {
+	ps, err := storage.NewPeerStore("memory", nil)
+	require.Nil(t, err)
+	lgc, err := middleware.NewLogic(middleware.ResponseConfig{}, ps, nil, nil)
+	require.Nil(t, err)
+
+	fe, err := NewFrontend(lgc, Config{Addr: "127.0.0.1:0", WatchInterval: 10 * time.Millisecond})
+	require.Nil(t, err)
+	defer func() {
+		require.Empty(t, <-fe.Stop())
+		require.Empty(t, <-lgc.Stop())
+	}()
+
+	conn, err := grpc.Dial(fe.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	defer conn.Close()
+	client := trackerpb.NewTrackerClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+	watch, err := client.WatchSwarm(ctx, &trackerpb.WatchSwarmRequest{InfoHash: infoHash})
+	require.Nil(t, err)
+	state, err := watch.Recv()
+	require.Nil(t, err)
+	require.Equal(t, uint32(0), state.Incomplete)
+
+	// Announcing without an IP uses the address of the caller.
+	resp, err := client.Announce(ctx, &trackerpb.AnnounceRequest{
+		InfoHash: infoHash,
+		Peer:     &trackerpb.Peer{Id: []byte("-XX1000-abcdefghijkl"), Port: 6881},
+		Event:    trackerpb.Event_EVENT_STARTED,
+		Left:     1,
+	})
+	require.Nil(t, err)
+
+	// Post-hooks are asynchronous, the watcher eventually sees the peer.
+	awaitState := func(complete, incomplete uint32) {
+		for {
+			state, err := watch.Recv()
+			require.Nil(t, err)
+			if state.Complete == complete && state.Incomplete == incomplete {
+				return
+			}
+		}
+	}
+	awaitState(0, 1)
+
+	resp, err = client.Announce(ctx, &trackerpb.AnnounceRequest{
+		InfoHash: infoHash,
+		Peer:     &trackerpb.Peer{Id: []byte("-XX1000-mnopqrstuvwx"), Ip: net.ParseIP("192.0.2.1").To4(), Port: 6882},
+		Event:    trackerpb.Event_EVENT_STARTED,
+	})
+	require.Nil(t, err)
+	require.NotEmpty(t, resp.Peers)
+	require.Equal(t, net.ParseIP("127.0.0.1").To4(), net.IP(resp.Peers[0].Ip))
+
+	awaitState(1, 1)
+
+	scrape, err := client.Scrape(ctx, &trackerpb.ScrapeRequest{InfoHashes: [][]byte{infoHash}})
+	require.Nil(t, err)
+	require.Len(t, scrape.Swarms, 1)
+	require.Equal(t, infoHash, scrape.Swarms[0].InfoHash)
+
+	// Invalid requests are rejected with InvalidArgument.
+	_, err = client.Announce(ctx, &trackerpb.AnnounceRequest{InfoHash: []byte("short")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestParseAnnounceIPSpoofing(t *testing.T) {
+	caller := net.ParseIP("127.0.0.1").To4()
+	provided := net.ParseIP("192.0.2.1").To4()
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: caller, Port: 1234}})
+	in := &trackerpb.AnnounceRequest{
+		InfoHash: []byte("aaaaaaaaaaaaaaaaaaaa"),
+		Peer:     &trackerpb.Peer{Id: []byte("-XX1000-abcdefghijkl"), Ip: provided, Port: 6881},
+	}
+
+	table := []struct {
+		allowIPSpoofing bool
+		expected        net.IP
+	}{
+		{false, caller},
+		{true, provided},
+	}
+
+	for _, tt := range table {
+		req, err := parseAnnounce(ctx, in, Config{AllowIPSpoofing: tt.allowIPSpoofing})
+		require.Nil(t, err)
+		require.Equal(t, tt.expected, req.IP.IP)
+		require.Equal(t, tt.allowIPSpoofing, req.IPProvided)
+		require.Equal(t, caller, req.SourceIP)
+	}
+}
@@ -0,0 +1,58 @@
+package grpc
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/status"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func init() {
+	prometheus.MustRegister(promResponseDurationMilliseconds, promWatchers)
+}
+
+var (
+	promResponseDurationMilliseconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "chihaya_grpc_response_duration_milliseconds",
+			Help:    "The duration of time it takes to receive and write a response to an API request",
+			Buckets: prometheus.ExponentialBuckets(9.375, 2, 10),
+		},
+		[]string{"action", "address_family", "error"},
+	)
+
+	// promWatchers is a gauge of the number of streams watching swarms.
+	promWatchers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chihaya_grpc_swarm_watchers",
+		Help: "The number of streams watching swarms",
+	})
+)
+
+// recordResponseDuration records the duration of time to respond to a gRPC
+// call in milliseconds.
+func (f *Frontend) recordResponseDuration(action string, af *bittorrent.AddressFamily, err error, start time.Time) {
+	var errString string
+	if err != nil {
+		errString = status.Code(err).String()
+	}
+
+	var afString string
+	if af == nil {
+		afString = "Unknown"
+	} else if *af == bittorrent.IPv4 {
+		afString = "IPv4"
+	} else if *af == bittorrent.IPv6 {
+		afString = "IPv6"
+	}
+
+	var duration time.Duration
+	if f.EnableRequestTiming {
+		duration = time.Since(start)
+	}
+
+	promResponseDurationMilliseconds.
+		WithLabelValues(action, afString, errString).
+		Observe(float64(duration.Nanoseconds()) / float64(time.Millisecond))
+}
@@ -0,0 +1,309 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/frontend/grpc/trackerpb"
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+// errorDomain is the domain of the ErrorInfo of client errors.
+const errorDomain = "chihaya"
+
+// server implements trackerpb.TrackerServer on top of the TrackerLogic of a
+// Frontend.
+type server struct {
+	trackerpb.UnimplementedTrackerServer
+	f *Frontend
+}
+
+func (s *server) Announce(ctx context.Context, in *trackerpb.AnnounceRequest) (_ *trackerpb.AnnounceResponse, err error) {
+	start := time.Now()
+	var af *bittorrent.AddressFamily
+	defer func() { s.f.recordResponseDuration("announce", af, err, start) }()
+
+	req, err := parseAnnounce(ctx, in, s.f.Config)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	af = new(bittorrent.AddressFamily)
+	*af = req.IP.AddressFamily
+
+	ctx, resp, err := s.f.logic.HandleAnnounce(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	s.f.logic.AfterAnnounce(detach(ctx), req, resp)
+
+	return announceResponse(resp), nil
+}
+
+func (s *server) Scrape(ctx context.Context, in *trackerpb.ScrapeRequest) (_ *trackerpb.ScrapeResponse, err error) {
+	start := time.Now()
+	var af *bittorrent.AddressFamily
+	defer func() { s.f.recordResponseDuration("scrape", af, err, start) }()
+
+	req, err := parseScrape(ctx, in, s.f.Config)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	af = new(bittorrent.AddressFamily)
+	*af = req.AddressFamily
+
+	ctx, resp, err := s.f.logic.HandleScrape(ctx, req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	s.f.logic.AfterScrape(detach(ctx), req, resp)
+
+	out := &trackerpb.ScrapeResponse{Swarms: make([]*trackerpb.SwarmState, 0, len(resp.Files))}
+	now := time.Now()
+	for _, sc := range resp.Files {
+		out.Swarms = append(out.Swarms, swarmState(sc, now))
+	}
+	return out, nil
+}
+
+func (s *server) WatchSwarm(in *trackerpb.WatchSwarmRequest, stream trackerpb.Tracker_WatchSwarmServer) error {
+	ctx := stream.Context()
+	req, err := parseScrape(ctx, &trackerpb.ScrapeRequest{InfoHashes: [][]byte{in.InfoHash}}, s.f.Config)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	promWatchers.Inc()
+	defer promWatchers.Dec()
+
+	t := time.NewTicker(s.f.WatchInterval)
+	defer t.Stop()
+
+	var last *trackerpb.SwarmState
+	for {
+		_, resp, err := s.f.logic.HandleScrape(ctx, req)
+		if err != nil {
+			return toStatus(err)
+		}
+		if len(resp.Files) != 1 {
+			return status.Error(codes.Internal, "internal server error")
+		}
+
+		state := swarmState(resp.Files[0], time.Now())
+		if last == nil || !sameSwarmState(last, state) {
+			if err := stream.Send(state); err != nil {
+				return err
+			}
+			last = state
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.f.closing:
+			return status.Error(codes.Unavailable, "shutting down")
+		case <-t.C:
+		}
+	}
+}
+
+// parseAnnounce converts an announce of the gRPC API into a
+// bittorrent.AnnounceRequest.
+func parseAnnounce(ctx context.Context, in *trackerpb.AnnounceRequest, cfg Config) (*bittorrent.AnnounceRequest, error) {
+	if len(in.InfoHash) != 20 {
+		return nil, bittorrent.ClientError("failed to provide valid info_hash")
+	}
+	if in.Peer == nil || len(in.Peer.Id) != 20 {
+		return nil, bittorrent.ClientError("failed to provide valid peer id")
+	}
+	if in.Peer.Port > 0xffff {
+		return nil, bittorrent.ErrInvalidPort
+	}
+
+	event, err := parseEvent(in.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := bittorrent.ParseURLData("")
+	if err != nil {
+		return nil, err
+	}
+
+	req := &bittorrent.AnnounceRequest{
+		Event:           event,
+		EventProvided:   in.Event != trackerpb.Event_EVENT_NONE,
+		InfoHash:        bittorrent.InfoHashFromBytes(in.InfoHash),
+		NumWant:         in.NumWant,
+		NumWantProvided: in.NumWant != 0,
+		Left:            in.Left,
+		Downloaded:      in.Downloaded,
+		Uploaded:        in.Uploaded,
+		TrackerID:       in.TrackerId,
+		Key:             in.Key,
+		SourceIP:        callerIP(ctx),
+		Peer: bittorrent.Peer{
+			ID:   bittorrent.PeerIDFromBytes(in.Peer.Id),
+			Port: uint16(in.Peer.Port),
+		},
+		Params: params,
+	}
+
+	if cfg.AllowIPSpoofing && len(in.Peer.Ip) != 0 {
+		req.IP.IP = net.IP(in.Peer.Ip)
+		req.IPProvided = true
+	} else {
+		req.IP.IP = req.SourceIP
+	}
+
+	if err := bittorrent.SanitizeAnnounce(req, cfg.MaxNumWant, cfg.DefaultNumWant); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseScrape converts a scrape of the gRPC API into a
+// bittorrent.ScrapeRequest for the address family of the caller.
+func parseScrape(ctx context.Context, in *trackerpb.ScrapeRequest, cfg Config) (*bittorrent.ScrapeRequest, error) {
+	if len(in.InfoHashes) == 0 {
+		return nil, bittorrent.ClientError("no info_hash parameter supplied")
+	}
+
+	req := &bittorrent.ScrapeRequest{InfoHashes: make([]bittorrent.InfoHash, 0, len(in.InfoHashes))}
+	for _, ih := range in.InfoHashes {
+		if len(ih) != 20 {
+			return nil, bittorrent.ClientError("failed to provide valid info_hash")
+		}
+		req.InfoHashes = append(req.InfoHashes, bittorrent.InfoHashFromBytes(ih))
+	}
+
+	var err error
+	if req.Params, err = bittorrent.ParseURLData(""); err != nil {
+		return nil, err
+	}
+
+	if ip := callerIP(ctx); ip != nil && ip.To4() == nil {
+		req.AddressFamily = bittorrent.IPv6
+	} else {
+		req.AddressFamily = bittorrent.IPv4
+	}
+
+	if err := bittorrent.SanitizeScrape(req, cfg.MaxScrapeInfoHashes); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func parseEvent(e trackerpb.Event) (bittorrent.Event, error) {
+	switch e {
+	case trackerpb.Event_EVENT_NONE:
+		return bittorrent.None, nil
+	case trackerpb.Event_EVENT_STARTED:
+		return bittorrent.Started, nil
+	case trackerpb.Event_EVENT_STOPPED:
+		return bittorrent.Stopped, nil
+	case trackerpb.Event_EVENT_COMPLETED:
+		return bittorrent.Completed, nil
+	case trackerpb.Event_EVENT_PAUSED:
+		return bittorrent.Paused, nil
+	default:
+		return bittorrent.None, bittorrent.ClientError("failed to provide valid client event")
+	}
+}
+
+// callerIP returns the IP address of the caller, or nil if it is not known.
+func callerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+func announceResponse(resp *bittorrent.AnnounceResponse) *trackerpb.AnnounceResponse {
+	out := &trackerpb.AnnounceResponse{
+		Complete:    resp.Complete,
+		Incomplete:  resp.Incomplete,
+		Interval:    durationpb.New(resp.Interval),
+		MinInterval: durationpb.New(resp.MinInterval),
+		Peers:       make([]*trackerpb.Peer, 0, len(resp.IPv4Peers)+len(resp.IPv6Peers)),
+
+		WarningMessage: resp.WarningMessage,
+		TrackerId:      resp.TrackerID,
+	}
+	for _, ps := range [][]bittorrent.Peer{resp.IPv4Peers, resp.IPv6Peers} {
+		for _, p := range ps {
+			out.Peers = append(out.Peers, &trackerpb.Peer{
+				Id:   append([]byte{}, p.ID[:]...),
+				Ip:   append([]byte{}, p.IP.IP...),
+				Port: uint32(p.Port),
+			})
+		}
+	}
+	return out
+}
+
+func swarmState(s bittorrent.Scrape, now time.Time) *trackerpb.SwarmState {
+	return &trackerpb.SwarmState{
+		InfoHash:    append([]byte{}, s.InfoHash[:]...),
+		Complete:    s.Complete,
+		Incomplete:  s.Incomplete,
+		Downloaders: s.Downloaders,
+		Snatches:    s.Snatches,
+		ObservedAt:  timestamppb.New(now),
+	}
+}
+
+func sameSwarmState(a, b *trackerpb.SwarmState) bool {
+	return bytes.Equal(a.InfoHash, b.InfoHash) &&
+		a.Complete == b.Complete &&
+		a.Incomplete == b.Incomplete &&
+		a.Downloaders == b.Downloaders &&
+		a.Snatches == b.Snatches
+}
+
+// toStatus converts an error of the tracker logic into a gRPC status.
+//
+// Client errors are exposed as invalid arguments, with their code and retry
+// time as ErrorInfo and RetryInfo details. Other errors are logged and hidden
+// from the caller.
+func toStatus(err error) error {
+	var clientErr bittorrent.ClientError
+	switch {
+	case errors.As(err, &clientErr):
+		st := status.New(codes.InvalidArgument, clientErr.Error())
+		var details []proto.Message
+		code, retryIn := bittorrent.ClientErrorDetails(err)
+		if code != "" {
+			details = append(details, &errdetails.ErrorInfo{Reason: code, Domain: errorDomain})
+		}
+		if retryIn > 0 {
+			details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryIn)})
+		}
+		if withDetails, err := st.WithDetails(details...); err == nil {
+			st = withDetails
+		}
+		return st.Err()
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		log.Error("grpc: internal error", log.Err(err))
+		return status.Error(codes.Internal, "internal server error")
+	}
+}
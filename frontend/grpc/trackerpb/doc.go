@@ -0,0 +1,5 @@
+// Package trackerpb contains the protocol buffer definitions of the gRPC
+// tracker service.
+package trackerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tracker.proto
@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: tracker.proto
+
+package trackerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TrackerClient is the client API for Tracker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TrackerClient interface {
+	// Announce announces a peer to a swarm and returns other peers.
+	Announce(ctx context.Context, in *AnnounceRequest, opts ...grpc.CallOption) (*AnnounceResponse, error)
+	// Scrape returns the state of swarms.
+	Scrape(ctx context.Context, in *ScrapeRequest, opts ...grpc.CallOption) (*ScrapeResponse, error)
+	// WatchSwarm streams the state of a swarm: once immediately and then
+	// whenever it changes, until the call is cancelled.
+	WatchSwarm(ctx context.Context, in *WatchSwarmRequest, opts ...grpc.CallOption) (Tracker_WatchSwarmClient, error)
+}
+
+type trackerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTrackerClient(cc grpc.ClientConnInterface) TrackerClient {
+	return &trackerClient{cc}
+}
+
+func (c *trackerClient) Announce(ctx context.Context, in *AnnounceRequest, opts ...grpc.CallOption) (*AnnounceResponse, error) {
+	out := new(AnnounceResponse)
+	err := c.cc.Invoke(ctx, "/chihaya.tracker.v1.Tracker/Announce", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) Scrape(ctx context.Context, in *ScrapeRequest, opts ...grpc.CallOption) (*ScrapeResponse, error) {
+	out := new(ScrapeResponse)
+	err := c.cc.Invoke(ctx, "/chihaya.tracker.v1.Tracker/Scrape", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) WatchSwarm(ctx context.Context, in *WatchSwarmRequest, opts ...grpc.CallOption) (Tracker_WatchSwarmClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Tracker_ServiceDesc.Streams[0], "/chihaya.tracker.v1.Tracker/WatchSwarm", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &trackerWatchSwarmClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Tracker_WatchSwarmClient interface {
+	Recv() (*SwarmState, error)
+	grpc.ClientStream
+}
+
+type trackerWatchSwarmClient struct {
+	grpc.ClientStream
+}
+
+func (x *trackerWatchSwarmClient) Recv() (*SwarmState, error) {
+	m := new(SwarmState)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TrackerServer is the server API for Tracker service.
+// All implementations must embed UnimplementedTrackerServer
+// for forward compatibility
+type TrackerServer interface {
+	// Announce announces a peer to a swarm and returns other peers.
+	Announce(context.Context, *AnnounceRequest) (*AnnounceResponse, error)
+	// Scrape returns the state of swarms.
+	Scrape(context.Context, *ScrapeRequest) (*ScrapeResponse, error)
+	// WatchSwarm streams the state of a swarm: once immediately and then
+	// whenever it changes, until the call is cancelled.
+	WatchSwarm(*WatchSwarmRequest, Tracker_WatchSwarmServer) error
+	mustEmbedUnimplementedTrackerServer()
+}
+
+// UnimplementedTrackerServer must be embedded to have forward compatible implementations.
+type UnimplementedTrackerServer struct {
+}
+
+func (UnimplementedTrackerServer) Announce(context.Context, *AnnounceRequest) (*AnnounceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Announce not implemented")
+}
+func (UnimplementedTrackerServer) Scrape(context.Context, *ScrapeRequest) (*ScrapeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scrape not implemented")
+}
+func (UnimplementedTrackerServer) WatchSwarm(*WatchSwarmRequest, Tracker_WatchSwarmServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchSwarm not implemented")
+}
+func (UnimplementedTrackerServer) mustEmbedUnimplementedTrackerServer() {}
+
+// UnsafeTrackerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TrackerServer will
+// result in compilation errors.
+type UnsafeTrackerServer interface {
+	mustEmbedUnimplementedTrackerServer()
+}
+
+func RegisterTrackerServer(s grpc.ServiceRegistrar, srv TrackerServer) {
+	s.RegisterService(&Tracker_ServiceDesc, srv)
+}
+
+func _Tracker_Announce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnnounceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).Announce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chihaya.tracker.v1.Tracker/Announce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).Announce(ctx, req.(*AnnounceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_Scrape_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScrapeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).Scrape(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/chihaya.tracker.v1.Tracker/Scrape",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).Scrape(ctx, req.(*ScrapeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_WatchSwarm_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchSwarmRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TrackerServer).WatchSwarm(m, &trackerWatchSwarmServer{stream})
+}
+
+type Tracker_WatchSwarmServer interface {
+	Send(*SwarmState) error
+	grpc.ServerStream
+}
+
+type trackerWatchSwarmServer struct {
+	grpc.ServerStream
+}
+
+func (x *trackerWatchSwarmServer) Send(m *SwarmState) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Tracker_ServiceDesc is the grpc.ServiceDesc for Tracker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Tracker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chihaya.tracker.v1.Tracker",
+	HandlerType: (*TrackerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Announce",
+			Handler:    _Tracker_Announce_Handler,
+		},
+		{
+			MethodName: "Scrape",
+			Handler:    _Tracker_Scrape_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchSwarm",
+			Handler:       _Tracker_WatchSwarm_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tracker.proto",
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+// defaultTLSReloadInterval is the default interval in which certificate files
+// are checked for changes.
+const defaultTLSReloadInterval = time.Minute
+
+// TLSCertificate is a certificate and key pair served over HTTPS.
+type TLSCertificate struct {
+	CertPath string `yaml:"cert_path"`
+	KeyPath  string `yaml:"key_path"`
+}
+
+// loadedCertificate is a TLSCertificate loaded from disk.
+type loadedCertificate struct {
+	TLSCertificate
+	certModTime time.Time
+	keyModTime  time.Time
+	cert        *tls.Certificate
+}
+
+// certificateStore serves certificates to TLS handshakes and reloads them
+// when their files change.
+//
+// Handshakes use whatever certificates are current when they start, so
+// reloading never affects established connections.
+type certificateStore struct {
+	certs    atomic.Value // []*loadedCertificate
+	closing  chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newCertificateStore loads the given certificates and starts watching them
+// for changes every interval.
+func newCertificateStore(certs []TLSCertificate, interval time.Duration) (*certificateStore, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no TLS certificates provided")
+	}
+
+	loaded := make([]*loadedCertificate, 0, len(certs))
+	for _, c := range certs {
+		lc, err := loadCertificate(c)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, lc)
+	}
+
+	s := &certificateStore{closing: make(chan struct{})}
+	s.certs.Store(loaded)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.closing:
+				return
+			case <-t.C:
+				s.reload()
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func loadCertificate(c TLSCertificate) (*loadedCertificate, error) {
+	certInfo, err := os.Stat(c.CertPath)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(c.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the leaf once, rather than on every handshake.
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &loadedCertificate{
+		TLSCertificate: c,
+		certModTime:    certInfo.ModTime(),
+		keyModTime:     keyInfo.ModTime(),
+		cert:           &cert,
+	}, nil
+}
+
+// changed reports whether the files of a certificate were modified since it
+// was loaded.
+func (lc *loadedCertificate) changed() bool {
+	certInfo, err := os.Stat(lc.CertPath)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(lc.KeyPath)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(lc.certModTime) || !keyInfo.ModTime().Equal(lc.keyModTime)
+}
+
+// reload reloads all certificates whose files changed.
+// If a certificate fails to load, the previous version is kept.
+func (s *certificateStore) reload() {
+	current := s.certs.Load().([]*loadedCertificate)
+
+	var updated []*loadedCertificate
+	for i, lc := range current {
+		if !lc.changed() {
+			continue
+		}
+
+		reloaded, err := loadCertificate(lc.TLSCertificate)
+		if err != nil {
+			// Renewals may replace the certificate and key one after
+			// another, we'll try again next time.
+			log.Warn("http: failed to reload TLS certificate, keeping previous version", log.Fields{
+				"certPath": lc.CertPath,
+				"keyPath":  lc.KeyPath,
+				"error":    err,
+			})
+			continue
+		}
+
+		if updated == nil {
+			updated = append([]*loadedCertificate{}, current...)
+		}
+		updated[i] = reloaded
+		log.Info("http: reloaded TLS certificate", log.Fields{
+			"certPath": lc.CertPath,
+			"notAfter": reloaded.cert.Leaf.NotAfter,
+		})
+	}
+
+	if updated != nil {
+		s.certs.Store(updated)
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+//
+// The first certificate that is valid for the server name requested by the
+// client is returned, or the first certificate if none is.
+func (s *certificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := s.certs.Load().([]*loadedCertificate)
+	if len(certs) > 1 {
+		for _, lc := range certs {
+			if hello.SupportsCertificate(lc.cert) == nil {
+				return lc.cert, nil
+			}
+		}
+	}
+	return certs[0].cert, nil
+}
+
+// stop stops watching the certificates.
+func (s *certificateStore) stop() {
+	s.stopOnce.Do(func() { close(s.closing) })
+	s.wg.Wait()
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate for name to dir and
+// returns its paths.
+func writeCertificate(t *testing.T, dir, name string, serial int64) TLSCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	c := TLSCertificate{
+		CertPath: filepath.Join(dir, name+".crt"),
+		KeyPath:  filepath.Join(dir, name+".key"),
+	}
+	require.Nil(t, os.WriteFile(c.CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.Nil(t, os.WriteFile(c.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return c
+}
+
+func hello(serverName string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        serverName,
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+	}
+}
+
+func TestCertificateStore(t *testing.T) {
+	dir := t.TempDir()
+	a := writeCertificate(t, dir, "a.example.com", 1)
+	b := writeCertificate(t, dir, "b.example.com", 2)
+
+	s, err := newCertificateStore([]TLSCertificate{a, b}, time.Hour)
+	require.Nil(t, err)
+	defer s.stop()
+
+	// Certificates are chosen by SNI, falling back to the first.
+	for _, tt := range []struct {
+		serverName string
+		expected   string
+	}{
+		{"a.example.com", "a.example.com"},
+		{"b.example.com", "b.example.com"},
+		{"c.example.com", "a.example.com"},
+		{"", "a.example.com"},
+	} {
+		cert, err := s.GetCertificate(hello(tt.serverName))
+		require.Nil(t, err)
+		require.Equal(t, tt.expected, cert.Leaf.Subject.CommonName)
+	}
+
+	// A renewed certificate is picked up on reload.
+	writeCertificate(t, dir, "b.example.com", 3)
+	future := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(b.CertPath, future, future))
+	s.reload()
+
+	cert, err := s.GetCertificate(hello("b.example.com"))
+	require.Nil(t, err)
+	require.Equal(t, int64(3), cert.Leaf.SerialNumber.Int64())
+
+	// A broken certificate keeps the previous version.
+	require.Nil(t, os.WriteFile(b.CertPath, []byte("garbage"), 0o600))
+	future = future.Add(time.Minute)
+	require.Nil(t, os.Chtimes(b.CertPath, future, future))
+	s.reload()
+
+	cert, err = s.GetCertificate(hello("b.example.com"))
+	require.Nil(t, err)
+	require.Equal(t, int64(3), cert.Leaf.SerialNumber.Int64())
+}
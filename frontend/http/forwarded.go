@@ -0,0 +1,89 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/chihaya/chihaya/pkg/proxyproto"
+)
+
+// Names of the headers carrying a chain of proxies, which are parsed
+// accordingly when used as RealIPHeader.
+const (
+	xForwardedForHeader = "X-Forwarded-For"
+	forwardedHeader     = "Forwarded"
+)
+
+// headerIP determines the client IP from the header named name, given the
+// request was sent by a trusted proxy.
+//
+// Chains of proxies are walked from the right, skipping trusted proxies; the
+// first untrusted hop is taken as the client. If every hop is trusted, the
+// leftmost is the client. present is false if the header is absent; ip is nil
+// if the relevant hop is not an IP address, such as an obfuscated identifier.
+func headerIP(h http.Header, name string, trusted proxyproto.Trusted) (ip net.IP, present bool) {
+	var hops []string
+	switch http.CanonicalHeaderKey(name) {
+	case xForwardedForHeader:
+		hops = xForwardedForHops(h.Values(name))
+	case forwardedHeader:
+		hops = forwardedHops(h.Values(name))
+	default:
+		if v := strings.TrimSpace(h.Get(name)); v != "" {
+			return parseHopIP(v), true
+		}
+		return nil, false
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHopIP(hops[i])
+		if ip == nil || i == 0 || !trusted.Contains(ip) {
+			return ip, true
+		}
+	}
+	return nil, false
+}
+
+// xForwardedForHops returns the hops of X-Forwarded-For headers, leftmost
+// first.
+func xForwardedForHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedHops returns the "for" parameters of Forwarded headers as
+// described in RFC 7239, leftmost first.
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			var hop string
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hop = strings.Trim(kv[1], `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHopIP parses the IP of a hop, which may include a port and brackets
+// around IPv6 addresses.
+func parseHopIP(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func TestRequestedIP(t *testing.T) {
+	table := []struct {
+		name     string
+		trusted  []string
+		header   string
+		remote   string
+		headers  map[string][]string
+		expected string
+		invalid  bool
+	}{
+		{
+			name:     "header without trusted proxies",
+			header:   "X-Real-IP",
+			remote:   "192.0.2.1:1234",
+			headers:  map[string][]string{"X-Real-IP": {"198.51.100.7"}},
+			expected: "192.0.2.1",
+		},
+		{
+			name:     "header from untrusted source",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "X-Real-IP",
+			remote:   "192.0.2.1:1234",
+			headers:  map[string][]string{"X-Real-IP": {"198.51.100.7"}},
+			expected: "192.0.2.1",
+		},
+		{
+			name:     "header from trusted proxy",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "X-Real-IP",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string][]string{"X-Real-IP": {"198.51.100.7"}},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "header over unix socket",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "X-Real-IP",
+			remote:   "@",
+			headers:  map[string][]string{"X-Real-IP": {"198.51.100.7"}},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "x-forwarded-for over unix socket",
+			header:   "X-Forwarded-For",
+			remote:   "@",
+			headers:  map[string][]string{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7"}},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "x-forwarded-for skips trusted hops",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "X-Forwarded-For",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string][]string{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7", "10.0.0.2"}},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "x-forwarded-for with only trusted hops",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "x-forwarded-for",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected: "10.0.0.3",
+		},
+		{
+			name:     "forwarded",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "Forwarded",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string][]string{"Forwarded": {`for=203.0.113.9, For="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`}},
+			expected: "2001:db8:cafe::17",
+		},
+		{
+			name:    "forwarded with obfuscated hop",
+			trusted: []string{"10.0.0.0/8"},
+			header:  "Forwarded",
+			remote:  "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.9, for=_hidden"}},
+			invalid: true,
+		},
+		{
+			name:    "invalid header from trusted proxy",
+			trusted: []string{"10.0.0.0/8"},
+			header:  "X-Real-IP",
+			remote:  "10.0.0.1:1234",
+			headers: map[string][]string{"X-Real-IP": {"unknown"}},
+			invalid: true,
+		},
+		{
+			name:     "invalid header from untrusted source",
+			trusted:  []string{"10.0.0.0/8"},
+			header:   "X-Real-IP",
+			remote:   "192.0.2.1:1234",
+			headers:  map[string][]string{"X-Real-IP": {"unknown"}},
+			expected: "192.0.2.1",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := ParseOptions{RealIPHeader: tt.header, TrustedProxies: tt.trusted}
+			require.Nil(t, opts.parseTrustedProxies())
+
+			r := httptest.NewRequest("GET", "/announce", nil)
+			r.RemoteAddr = tt.remote
+			for k, vs := range tt.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+
+			ip, _, err := requestedIP(r, &bittorrent.QueryParams{}, opts)
+			if tt.invalid {
+				require.IsType(t, bittorrent.ClientError(""), err)
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, net.ParseIP(tt.expected).String(), ip.String())
+		})
+	}
+}
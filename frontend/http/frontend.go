@@ -8,31 +8,71 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/frontend"
+	"github.com/chihaya/chihaya/pkg/listenfd"
 	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/proxyproto"
 	"github.com/chihaya/chihaya/pkg/stop"
 )
 
 // Config represents all of the configurable options for an HTTP BitTorrent
 // Frontend.
 type Config struct {
-	Addr                string        `yaml:"addr"`
-	HTTPSAddr           string        `yaml:"https_addr"`
-	ReadTimeout         time.Duration `yaml:"read_timeout"`
-	WriteTimeout        time.Duration `yaml:"write_timeout"`
-	IdleTimeout         time.Duration `yaml:"idle_timeout"`
-	EnableKeepAlive     bool          `yaml:"enable_keepalive"`
-	TLSCertPath         string        `yaml:"tls_cert_path"`
-	TLSKeyPath          string        `yaml:"tls_key_path"`
-	AnnounceRoutes      []string      `yaml:"announce_routes"`
-	ScrapeRoutes        []string      `yaml:"scrape_routes"`
-	EnableRequestTiming bool          `yaml:"enable_request_timing"`
-	ParseOptions        `yaml:",inline"`
+	Addr            string        `yaml:"addr"`
+	HTTPSAddr       string        `yaml:"https_addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	EnableKeepAlive bool          `yaml:"enable_keepalive"`
+	TLSCertPath     string        `yaml:"tls_cert_path"`
+	TLSKeyPath      string        `yaml:"tls_key_path"`
+
+	// TLSCertificates are additional certificates, chosen by the server name
+	// requested by clients.
+	TLSCertificates []TLSCertificate `yaml:"tls_certificates"`
+
+	// TLSReloadInterval is the interval in which certificate files are
+	// checked for changes.
+	TLSReloadInterval time.Duration `yaml:"tls_reload_interval"`
+
+	// Addrs and HTTPSAddrs are further addresses to listen on, in addition
+	// to Addr and HTTPSAddr.
+	Addrs      []string `yaml:"addrs"`
+	HTTPSAddrs []string `yaml:"https_addrs"`
+
+	// UnixSocket is the path of a Unix domain socket to serve plain HTTP on,
+	// created with the permissions in UnixSocketMode. The peer of the socket
+	// is trusted as a proxy, so RealIPHeader is required.
+	UnixSocket     string      `yaml:"unix_socket"`
+	UnixSocketMode os.FileMode `yaml:"unix_socket_mode"`
+
+	AnnounceRoutes      []string `yaml:"announce_routes"`
+	ScrapeRoutes        []string `yaml:"scrape_routes"`
+	EnableRequestTiming bool     `yaml:"enable_request_timing"`
+
+	// JSONAnnounceRoutes and JSONScrapeRoutes accept the same parameters as
+	// AnnounceRoutes and ScrapeRoutes, but respond with JSON.
+	JSONAnnounceRoutes []string `yaml:"json_announce_routes"`
+	JSONScrapeRoutes   []string `yaml:"json_scrape_routes"`
+
+	// SendExternalIP sends the IP address the request was observed from as
+	// BEP 24 external ip. It is taken from the connection or RealIPHeader,
+	// never from the params of the request.
+	SendExternalIP bool `yaml:"send_external_ip"`
+
+	// ProxyProtocolTrusted is a list of CIDRs of proxies allowed to send
+	// PROXY protocol headers. Connections from other addresses are taken
+	// as is.
+	ProxyProtocolTrusted []string `yaml:"proxy_protocol_trusted"`
+
+	ParseOptions `yaml:",inline"`
 }
 
 // LogFields renders the current config as a set of Logrus fields.
@@ -40,28 +80,69 @@ func (cfg Config) LogFields() log.Fields {
 	return log.Fields{
 		"addr":                cfg.Addr,
 		"httpsAddr":           cfg.HTTPSAddr,
+		"addrs":               cfg.Addrs,
+		"httpsAddrs":          cfg.HTTPSAddrs,
+		"unixSocket":          cfg.UnixSocket,
+		"unixSocketMode":      cfg.UnixSocketMode,
 		"readTimeout":         cfg.ReadTimeout,
 		"writeTimeout":        cfg.WriteTimeout,
 		"idleTimeout":         cfg.IdleTimeout,
 		"enableKeepAlive":     cfg.EnableKeepAlive,
 		"tlsCertPath":         cfg.TLSCertPath,
 		"tlsKeyPath":          cfg.TLSKeyPath,
+		"tlsCertificates":     cfg.TLSCertificates,
+		"tlsReloadInterval":   cfg.TLSReloadInterval,
 		"announceRoutes":      cfg.AnnounceRoutes,
 		"scrapeRoutes":        cfg.ScrapeRoutes,
+		"jsonAnnounceRoutes":  cfg.JSONAnnounceRoutes,
+		"jsonScrapeRoutes":    cfg.JSONScrapeRoutes,
 		"enableRequestTiming": cfg.EnableRequestTiming,
+		"sendExternalIP":      cfg.SendExternalIP,
+		"proxyProtocol":       cfg.ProxyProtocolTrusted,
 		"allowIPSpoofing":     cfg.AllowIPSpoofing,
+		"allowDualStack":      cfg.AllowDualStack,
 		"realIPHeader":        cfg.RealIPHeader,
+		"trustedProxies":      cfg.TrustedProxies,
 		"maxNumWant":          cfg.MaxNumWant,
 		"defaultNumWant":      cfg.DefaultNumWant,
 		"maxScrapeInfoHashes": cfg.MaxScrapeInfoHashes,
 	}
 }
 
+// HTTPListenAddrs returns all addresses to serve plain HTTP on.
+func (cfg Config) HTTPListenAddrs() []string {
+	return joinAddrs(cfg.Addr, cfg.Addrs)
+}
+
+// HTTPSListenAddrs returns all addresses to serve HTTPS on.
+func (cfg Config) HTTPSListenAddrs() []string {
+	return joinAddrs(cfg.HTTPSAddr, cfg.HTTPSAddrs)
+}
+
+// Enabled reports whether the config specifies anything to listen on.
+func (cfg Config) Enabled() bool {
+	return len(cfg.HTTPListenAddrs()) != 0 || len(cfg.HTTPSListenAddrs()) != 0 || cfg.UnixSocket != ""
+}
+
+func joinAddrs(addr string, addrs []string) []string {
+	var joined []string
+	if addr != "" {
+		joined = append(joined, addr)
+	}
+	for _, a := range addrs {
+		if a != "" {
+			joined = append(joined, a)
+		}
+	}
+	return joined
+}
+
 // Default config constants.
 const (
-	defaultReadTimeout  = 2 * time.Second
-	defaultWriteTimeout = 2 * time.Second
-	defaultIdleTimeout  = 30 * time.Second
+	defaultReadTimeout    = 2 * time.Second
+	defaultWriteTimeout   = 2 * time.Second
+	defaultIdleTimeout    = 30 * time.Second
+	defaultUnixSocketMode = 0o660
 )
 
 // Validate sanity checks values set in a config and returns a new config with
@@ -102,6 +183,37 @@ func (cfg Config) Validate() Config {
 		}
 	}
 
+	if cfg.RealIPHeader != "" && len(cfg.TrustedProxies) == 0 {
+		log.Warn("http.RealIPHeader is ignored for TCP connections, set http.TrustedProxies to the addresses of your proxies", log.Fields{
+			"realIPHeader": cfg.RealIPHeader,
+		})
+	}
+
+	if cfg.UnixSocketMode == 0 {
+		validcfg.UnixSocketMode = defaultUnixSocketMode
+
+		if cfg.UnixSocket != "" {
+			log.Warn("falling back to default configuration", log.Fields{
+				"name":     "http.UnixSocketMode",
+				"provided": cfg.UnixSocketMode,
+				"default":  validcfg.UnixSocketMode,
+			})
+		}
+	}
+
+	if cfg.TLSReloadInterval <= 0 {
+		validcfg.TLSReloadInterval = defaultTLSReloadInterval
+
+		if len(cfg.HTTPSListenAddrs()) != 0 {
+			// If TLS is disabled, this configuration isn't used anyway.
+			log.Warn("falling back to default configuration", log.Fields{
+				"name":     "http.TLSReloadInterval",
+				"provided": cfg.TLSReloadInterval,
+				"default":  validcfg.TLSReloadInterval,
+			})
+		}
+	}
+
 	if cfg.MaxNumWant <= 0 {
 		validcfg.MaxNumWant = defaultMaxNumWant
 		log.Warn("falling back to default configuration", log.Fields{
@@ -132,91 +244,246 @@ func (cfg Config) Validate() Config {
 	return validcfg
 }
 
+// Tenant is a TrackerLogic served by a Frontend for a subset of requests.
+//
+// A request is handled by a Tenant if the Host header of the request is
+// contained in Hosts and the RoutePrefix is a prefix of the request path that
+// ends at a path segment boundary.
+// Empty Hosts or an empty RoutePrefix match any request. A RoutePrefix must
+// start with a slash and must not end with one.
+type Tenant struct {
+	Name        string
+	Hosts       []string
+	RoutePrefix string
+	Logic       frontend.TrackerLogic
+}
+
+// LogFields renders the current tenant as a set of Logrus fields.
+func (t Tenant) LogFields() log.Fields {
+	return log.Fields{
+		"name":        t.Name,
+		"hosts":       t.Hosts,
+		"routePrefix": t.RoutePrefix,
+	}
+}
+
+// matches reports whether the request should be handled by the tenant.
+func (t Tenant) matches(r *http.Request) bool {
+	if t.RoutePrefix != "" {
+		// The prefix must end at a path segment, "/a" does not match "/abc".
+		if r.URL.Path != t.RoutePrefix && !strings.HasPrefix(r.URL.Path, t.RoutePrefix+"/") {
+			return false
+		}
+	}
+
+	if len(t.Hosts) == 0 {
+		return true
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, h := range t.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Frontend represents the state of an HTTP BitTorrent Frontend.
 type Frontend struct {
 	srv    *http.Server
 	tlsSrv *http.Server
 	tlsCfg *tls.Config
+	certs  *certificateStore
 
-	logic frontend.TrackerLogic
+	tenants []Tenant
 	Config
 }
 
 // NewFrontend creates a new instance of an HTTP Frontend that asynchronously
 // serves requests.
 func NewFrontend(logic frontend.TrackerLogic, provided Config) (*Frontend, error) {
+	return NewMultiTenantFrontend([]Tenant{{Logic: logic}}, provided)
+}
+
+// NewMultiTenantFrontend creates a new instance of an HTTP Frontend that
+// asynchronously serves requests for multiple tenants.
+//
+// Requests are handled by the first tenant that matches them, requests that
+// match no tenant are answered with 404 Not Found.
+func NewMultiTenantFrontend(tenants []Tenant, provided Config) (*Frontend, error) {
 	cfg := provided.Validate()
 
 	f := &Frontend{
-		logic:  logic,
-		Config: cfg,
+		tenants: tenants,
+		Config:  cfg,
 	}
 
-	if cfg.Addr == "" && cfg.HTTPSAddr == "" {
-		return nil, errors.New("must specify addr or https_addr or both")
+	if !cfg.Enabled() {
+		return nil, errors.New("must specify addr, https_addr or unix_socket")
 	}
 
 	if len(cfg.AnnounceRoutes) < 1 || len(cfg.ScrapeRoutes) < 1 {
 		return nil, errors.New("must specify routes")
 	}
 
-	// If TLS is enabled, create a key pair.
+	if len(tenants) < 1 {
+		return nil, errors.New("must specify at least one tenant")
+	}
+
+	// Prefixes are joined with the routes.
+	for _, t := range tenants {
+		if t.RoutePrefix != "" && (!strings.HasPrefix(t.RoutePrefix, "/") || strings.HasSuffix(t.RoutePrefix, "/")) {
+			return nil, errors.New("route prefix of tenant " + t.Name + " must start with / and must not end with /")
+		}
+	}
+
+	// Requests on a Unix domain socket carry no client address of their own.
+	if cfg.UnixSocket != "" && cfg.RealIPHeader == "" {
+		return nil, errors.New("must specify real_ip_header to use unix_socket")
+	}
+
+	if err := f.ParseOptions.parseTrustedProxies(); err != nil {
+		return nil, err
+	}
+
+	// If TLS is enabled, load the certificates.
+	var certs []TLSCertificate
 	if cfg.TLSCertPath != "" && cfg.TLSKeyPath != "" {
+		certs = append(certs, TLSCertificate{CertPath: cfg.TLSCertPath, KeyPath: cfg.TLSKeyPath})
+	}
+	certs = append(certs, cfg.TLSCertificates...)
+
+	if len(cfg.HTTPSListenAddrs()) != 0 && len(certs) == 0 {
+		return nil, errors.New("must specify tls_cert_path and tls_key_path or tls_certificates when using https_addr")
+	}
+	if len(cfg.HTTPSListenAddrs()) == 0 && len(certs) != 0 {
+		return nil, errors.New("must specify https_addr when using tls_cert_path and tls_key_path or tls_certificates")
+	}
+
+	if len(certs) != 0 {
 		var err error
-		f.tlsCfg = &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			Certificates: make([]tls.Certificate, 1),
-		}
-		f.tlsCfg.Certificates[0], err = tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath)
+		f.certs, err = newCertificateStore(certs, cfg.TLSReloadInterval)
 		if err != nil {
 			return nil, err
 		}
+		f.tlsCfg = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: f.certs.GetCertificate,
+		}
 	}
 
-	if cfg.HTTPSAddr != "" && f.tlsCfg == nil {
-		return nil, errors.New("must specify tls_cert_path and tls_key_path when using https_addr")
-	}
-	if cfg.HTTPSAddr == "" && f.tlsCfg != nil {
-		return nil, errors.New("must specify https_addr when using tls_cert_path and tls_key_path")
+	trusted, err := proxyproto.ParseTrusted(cfg.ProxyProtocolTrusted)
+	if err != nil {
+		f.stopCertificates()
+		return nil, err
 	}
 
-	var listenerHTTP, listenerHTTPS net.Listener
-	var err error
-	if cfg.Addr != "" {
-		listenerHTTP, err = net.Listen("tcp", f.Addr)
+	var listenersHTTP, listenersHTTPS []net.Listener
+	closeAll := func() {
+		for _, l := range append(listenersHTTP, listenersHTTPS...) {
+			l.Close()
+		}
+		f.stopCertificates()
+	}
+	for _, addr := range cfg.HTTPListenAddrs() {
+		l, err := f.listen(addr, trusted)
 		if err != nil {
+			closeAll()
 			return nil, err
 		}
+		listenersHTTP = append(listenersHTTP, l)
 	}
-	if cfg.HTTPSAddr != "" {
-		listenerHTTPS, err = net.Listen("tcp", f.HTTPSAddr)
+	if cfg.UnixSocket != "" {
+		l, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketMode)
 		if err != nil {
-			if listenerHTTP != nil {
-				listenerHTTP.Close()
-			}
+			closeAll()
+			return nil, err
+		}
+		listenersHTTP = append(listenersHTTP, l)
+	}
+	for _, addr := range cfg.HTTPSListenAddrs() {
+		l, err := f.listen(addr, trusted)
+		if err != nil {
+			closeAll()
 			return nil, err
 		}
+		listenersHTTPS = append(listenersHTTPS, l)
 	}
 
-	if cfg.Addr != "" {
-		go func() {
-			if err := f.serveHTTP(listenerHTTP); err != nil {
+	if len(listenersHTTP) != 0 {
+		f.srv = f.newServer(nil)
+	}
+	if len(listenersHTTPS) != 0 {
+		f.tlsSrv = f.newServer(f.tlsCfg)
+	}
+
+	for _, l := range listenersHTTP {
+		go func(l net.Listener) {
+			if err := f.serveHTTP(l); err != nil {
 				log.Fatal("failed while serving http", log.Err(err))
 			}
-		}()
+		}(l)
 	}
 
-	if cfg.HTTPSAddr != "" {
-		go func() {
-			if err := f.serveHTTPS(listenerHTTPS); err != nil {
+	for _, l := range listenersHTTPS {
+		go func(l net.Listener) {
+			if err := f.serveHTTPS(l); err != nil {
 				log.Fatal("failed while serving https", log.Err(err))
 			}
-		}()
+		}(l)
 	}
 
 	return f, nil
 }
 
+// listen binds a TCP listener, accepting PROXY protocol headers from trusted
+// proxies.
+func (f *Frontend) listen(addr string, trusted proxyproto.Trusted) (net.Listener, error) {
+	l, err := listenfd.Listen("tcp", addr, func() (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trusted) == 0 {
+		return l, nil
+	}
+	return &proxyproto.Listener{Listener: l, Trusted: trusted, HeaderTimeout: f.ReadTimeout}, nil
+}
+
+// listenUnix binds a Unix domain socket with the given permissions, replacing
+// a stale socket left behind by a previous process.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	return listenfd.Listen("unix", path, func() (net.Listener, error) {
+		return bindUnix(path, mode)
+	})
+}
+
+func bindUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
 // Stop provides a thread-safe way to shutdown a currently running Frontend.
 func (f *Frontend) Stop() stop.Result {
 	stopGroup := stop.NewGroup()
@@ -228,7 +495,16 @@ func (f *Frontend) Stop() stop.Result {
 		stopGroup.AddFunc(f.makeStopFunc(f.tlsSrv))
 	}
 
-	return stopGroup.Stop()
+	result := stopGroup.Stop()
+	f.stopCertificates()
+	return result
+}
+
+// stopCertificates stops watching certificate files, if TLS is enabled.
+func (f *Frontend) stopCertificates() {
+	if f.certs != nil {
+		f.certs.stop()
+	}
 }
 
 func (f *Frontend) makeStopFunc(stopSrv *http.Server) stop.Func {
@@ -242,29 +518,42 @@ func (f *Frontend) makeStopFunc(stopSrv *http.Server) stop.Func {
 }
 
 func (f *Frontend) handler() http.Handler {
-	router := httprouter.New()
-	for _, route := range f.AnnounceRoutes {
-		router.GET(route, f.announceRoute)
+	routers := make([]http.Handler, len(f.tenants))
+	for i, t := range f.tenants {
+		router := httprouter.New()
+		for _, route := range f.AnnounceRoutes {
+			router.GET(t.RoutePrefix+route, f.announceRoute(t.Logic, bencodeEncoding))
+		}
+		for _, route := range f.ScrapeRoutes {
+			router.GET(t.RoutePrefix+route, f.scrapeRoute(t.Logic, bencodeEncoding))
+		}
+		for _, route := range f.JSONAnnounceRoutes {
+			router.GET(t.RoutePrefix+route, f.announceRoute(t.Logic, jsonEncoding))
+		}
+		for _, route := range f.JSONScrapeRoutes {
+			router.GET(t.RoutePrefix+route, f.scrapeRoute(t.Logic, jsonEncoding))
+		}
+		routers[i] = router
 	}
-	for _, route := range f.ScrapeRoutes {
-		router.GET(route, f.scrapeRoute)
+
+	if len(routers) == 1 && len(f.tenants[0].Hosts) == 0 {
+		return routers[0]
 	}
-	return router
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, t := range f.tenants {
+			if t.matches(r) {
+				routers[i].ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
 }
 
 // serveHTTP blocks while listening and serving non-TLS HTTP BitTorrent
 // requests until Stop() is called or an error is returned.
 func (f *Frontend) serveHTTP(l net.Listener) error {
-	f.srv = &http.Server{
-		Addr:         f.Addr,
-		Handler:      f.handler(),
-		ReadTimeout:  f.ReadTimeout,
-		WriteTimeout: f.WriteTimeout,
-		IdleTimeout:  f.IdleTimeout,
-	}
-
-	f.srv.SetKeepAlivesEnabled(f.EnableKeepAlive)
-
 	// Start the HTTP server.
 	if err := f.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
 		return err
@@ -275,16 +564,6 @@ func (f *Frontend) serveHTTP(l net.Listener) error {
 // serveHTTPS blocks while listening and serving TLS HTTP BitTorrent
 // requests until Stop() is called or an error is returned.
 func (f *Frontend) serveHTTPS(l net.Listener) error {
-	f.tlsSrv = &http.Server{
-		Addr:         f.HTTPSAddr,
-		TLSConfig:    f.tlsCfg,
-		Handler:      f.handler(),
-		ReadTimeout:  f.ReadTimeout,
-		WriteTimeout: f.WriteTimeout,
-	}
-
-	f.tlsSrv.SetKeepAlivesEnabled(f.EnableKeepAlive)
-
 	// Start the HTTP server.
 	if err := f.tlsSrv.ServeTLS(l, "", ""); !errors.Is(err, http.ErrServerClosed) {
 		return err
@@ -292,6 +571,19 @@ func (f *Frontend) serveHTTPS(l net.Listener) error {
 	return nil
 }
 
+// newServer creates the server for all plain or all TLS listeners.
+func (f *Frontend) newServer(tlsCfg *tls.Config) *http.Server {
+	srv := &http.Server{
+		TLSConfig:    tlsCfg,
+		Handler:      f.handler(),
+		ReadTimeout:  f.ReadTimeout,
+		WriteTimeout: f.WriteTimeout,
+		IdleTimeout:  f.IdleTimeout,
+	}
+	srv.SetKeepAlivesEnabled(f.EnableKeepAlive)
+	return srv
+}
+
 func injectRouteParamsToContext(ctx context.Context, ps httprouter.Params) context.Context {
 	rp := bittorrent.RouteParams{}
 	for _, p := range ps {
@@ -300,8 +592,16 @@ func injectRouteParamsToContext(ctx context.Context, ps httprouter.Params) conte
 	return context.WithValue(ctx, bittorrent.RouteParamsKey, rp)
 }
 
-// announceRoute parses and responds to an Announce.
-func (f *Frontend) announceRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// announceRoute returns a handler that parses and responds to an Announce
+// using the provided TrackerLogic and encoding.
+func (f *Frontend) announceRoute(logic frontend.TrackerLogic, enc encoding) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		f.handleAnnounce(logic, enc, w, r, ps)
+	}
+}
+
+// handleAnnounce parses and responds to an Announce.
+func (f *Frontend) handleAnnounce(logic frontend.TrackerLogic, enc encoding, w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var err error
 	var start time.Time
 	if f.EnableRequestTiming {
@@ -318,31 +618,43 @@ func (f *Frontend) announceRoute(w http.ResponseWriter, r *http.Request, ps http
 
 	req, err := ParseAnnounce(r, f.ParseOptions)
 	if err != nil {
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
 	af = new(bittorrent.AddressFamily)
 	*af = req.IP.AddressFamily
 
 	ctx := injectRouteParamsToContext(context.Background(), ps)
-	ctx, resp, err := f.logic.HandleAnnounce(ctx, req)
+	ctx, resp, err := logic.HandleAnnounce(ctx, req)
 	if err != nil {
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	err = WriteAnnounceResponse(w, resp)
+	if f.SendExternalIP && resp.ExternalIP == nil {
+		resp.ExternalIP = req.SourceIP
+	}
+
+	w.Header().Set("Content-Type", enc.contentType)
+	err = enc.writeAnnounce(w, resp)
 	if err != nil {
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
 
-	go f.logic.AfterAnnounce(ctx, req, resp)
+	logic.AfterAnnounce(ctx, req, resp)
 }
 
-// scrapeRoute parses and responds to a Scrape.
-func (f *Frontend) scrapeRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// scrapeRoute returns a handler that parses and responds to a Scrape using
+// the provided TrackerLogic and encoding.
+func (f *Frontend) scrapeRoute(logic frontend.TrackerLogic, enc encoding) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		f.handleScrape(logic, enc, w, r, ps)
+	}
+}
+
+// handleScrape parses and responds to a Scrape.
+func (f *Frontend) handleScrape(logic frontend.TrackerLogic, enc encoding, w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var err error
 	var start time.Time
 	if f.EnableRequestTiming {
@@ -359,43 +671,40 @@ func (f *Frontend) scrapeRoute(w http.ResponseWriter, r *http.Request, ps httpro
 
 	req, err := ParseScrape(r, f.ParseOptions)
 	if err != nil {
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
 
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	reqIP, err := clientIP(r, f.ParseOptions)
 	if err != nil {
-		log.Error("http: unable to determine remote address for scrape", log.Err(err))
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
-
-	reqIP := net.ParseIP(host)
 	if reqIP.To4() != nil {
 		req.AddressFamily = bittorrent.IPv4
 	} else if len(reqIP) == net.IPv6len { // implies reqIP.To4() == nil
 		req.AddressFamily = bittorrent.IPv6
 	} else {
 		log.Error("http: invalid IP: neither v4 nor v6", log.Fields{"RemoteAddr": r.RemoteAddr})
-		_ = WriteError(w, bittorrent.ErrInvalidIP)
+		_ = enc.writeError(w, bittorrent.ErrInvalidIP)
 		return
 	}
 	af = new(bittorrent.AddressFamily)
 	*af = req.AddressFamily
 
 	ctx := injectRouteParamsToContext(context.Background(), ps)
-	ctx, resp, err := f.logic.HandleScrape(ctx, req)
+	ctx, resp, err := logic.HandleScrape(ctx, req)
 	if err != nil {
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	err = WriteScrapeResponse(w, resp)
+	w.Header().Set("Content-Type", enc.contentType)
+	err = enc.writeScrape(w, resp)
 	if err != nil {
-		_ = WriteError(w, err)
+		_ = enc.writeError(w, err)
 		return
 	}
 
-	go f.logic.AfterScrape(ctx, req, resp)
+	logic.AfterScrape(ctx, req, resp)
 }
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantMatches(t *testing.T) {
+	table := []struct {
+		tenant   Tenant
+		target   string
+		host     string
+		expected bool
+	}{
+		{Tenant{}, "/announce", "example.com", true},
+		{Tenant{Hosts: []string{"a.example.com"}}, "/announce", "a.example.com", true},
+		{Tenant{Hosts: []string{"a.example.com"}}, "/announce", "A.example.com:6969", true},
+		{Tenant{Hosts: []string{"a.example.com"}}, "/announce", "b.example.com", false},
+		{Tenant{RoutePrefix: "/a"}, "/a/announce", "example.com", true},
+		{Tenant{RoutePrefix: "/a"}, "/b/announce", "example.com", false},
+		{Tenant{RoutePrefix: "/a"}, "/abc/announce", "example.com", false},
+		{Tenant{RoutePrefix: "/a/b"}, "/a/b/announce", "example.com", true},
+		{Tenant{Hosts: []string{"a.example.com"}, RoutePrefix: "/a"}, "/a/announce", "b.example.com", false},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.Host = tt.host
+		require.Equal(t, tt.expected, tt.tenant.matches(r), "tenant %v, target %s, host %s", tt.tenant.LogFields(), tt.target, tt.host)
+	}
+}
+
+func TestMultiTenantHandler(t *testing.T) {
+	f := &Frontend{
+		tenants: []Tenant{
+			{Name: "a", Hosts: []string{"a.example.com"}},
+			{Name: "b", RoutePrefix: "/b"},
+		},
+		Config: Config{
+			AnnounceRoutes: []string{"/announce"},
+			ScrapeRoutes:   []string{"/scrape"},
+		},
+	}
+	h := f.handler()
+
+	// Requests matching no tenant are not found.
+	r := httptest.NewRequest("GET", "/announce", nil)
+	r.Host = "c.example.com"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, 404, w.Code)
+
+	// Requests matching a tenant are parsed, which fails without an infohash.
+	r = httptest.NewRequest("GET", "/b/announce", nil)
+	r.Host = "c.example.com"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), "failure reason")
+}
+
+func TestInvalidRoutePrefix(t *testing.T) {
+	cfg := Config{
+		Addr:           "127.0.0.1:0",
+		AnnounceRoutes: []string{"/announce"},
+		ScrapeRoutes:   []string{"/scrape"},
+	}
+	for _, prefix := range []string{"a", "/a/"} {
+		_, err := NewMultiTenantFrontend([]Tenant{{Name: "a", RoutePrefix: prefix}}, cfg)
+		require.NotNil(t, err, prefix)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	// A stale socket file is replaced.
+	stale, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := Config{
+		UnixSocket:     path,
+		UnixSocketMode: 0o600,
+		AnnounceRoutes: []string{"/announce"},
+		ScrapeRoutes:   []string{"/scrape"},
+	}
+
+	// The client address can only be taken from a header.
+	_, err = NewFrontend(nil, cfg)
+	require.NotNil(t, err)
+
+	cfg.RealIPHeader = "X-Forwarded-For"
+	f, err := NewFrontend(nil, cfg)
+	require.Nil(t, err)
+	defer func() { require.Empty(t, <-f.Stop()) }()
+
+	fi, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://tracker/announce")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, string(body), "failure reason")
+}
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+const jsonContentType = "application/json"
+
+// encoding writes the responses of a set of routes.
+type encoding struct {
+	contentType   string
+	writeError    func(http.ResponseWriter, error) error
+	writeAnnounce func(http.ResponseWriter, *bittorrent.AnnounceResponse) error
+	writeScrape   func(http.ResponseWriter, *bittorrent.ScrapeResponse) error
+}
+
+var (
+	// bencodeEncoding is used by the routes for BitTorrent clients.
+	bencodeEncoding = encoding{
+		contentType:   "text/plain; charset=utf-8",
+		writeError:    WriteError,
+		writeAnnounce: WriteAnnounceResponse,
+		writeScrape:   WriteScrapeResponse,
+	}
+
+	// jsonEncoding is used by the JSON routes.
+	jsonEncoding = encoding{
+		contentType:   jsonContentType,
+		writeError:    WriteJSONError,
+		writeAnnounce: WriteJSONAnnounceResponse,
+		writeScrape:   WriteJSONScrapeResponse,
+	}
+)
+
+// Codes of JSON errors.
+const (
+	jsonErrorCodeInvalidRequest = "invalid_request"
+	jsonErrorCodeInternal       = "internal_error"
+)
+
+// JSONError is the body of a JSON error response.
+type JSONError struct {
+	Error JSONErrorDetails `json:"error"`
+}
+
+// JSONErrorDetails describes an error of a JSON route.
+//
+// RetryIn is the number of seconds to wait before retrying, -1 if the request
+// should not be retried and omitted if unspecified.
+type JSONErrorDetails struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RetryIn int64  `json:"retry_in,omitempty"`
+}
+
+// JSONAnnounceResponse is the body of a JSON announce response.
+type JSONAnnounceResponse struct {
+	Complete    uint32     `json:"complete"`
+	Incomplete  uint32     `json:"incomplete"`
+	Interval    int64      `json:"interval"`
+	MinInterval int64      `json:"min_interval"`
+	Peers       []JSONPeer `json:"peers"`
+
+	WarningMessage string `json:"warning_message,omitempty"`
+	TrackerID      string `json:"tracker_id,omitempty"`
+	ExternalIP     string `json:"external_ip,omitempty"`
+}
+
+// JSONPeer is a peer in a JSON announce response.
+type JSONPeer struct {
+	ID   string `json:"peer_id"`
+	IP   string `json:"ip"`
+	Port uint16 `json:"port"`
+}
+
+// JSONScrapeResponse is the body of a JSON scrape response, with files keyed
+// by their hex-encoded infohash.
+type JSONScrapeResponse struct {
+	Files map[string]JSONScrape `json:"files"`
+}
+
+// JSONScrape is the state of a swarm in a JSON scrape response.
+type JSONScrape struct {
+	Complete    uint32 `json:"complete"`
+	Incomplete  uint32 `json:"incomplete"`
+	Downloaders uint32 `json:"downloaders"`
+	Snatches    uint32 `json:"snatches"`
+}
+
+// WriteJSONError communicates an error to a client of the JSON routes.
+//
+// Client errors are answered with 400 Bad Request and their code, if they
+// carry one. All other errors are answered with 500 Internal Server Error and
+// a generic message.
+func WriteJSONError(w http.ResponseWriter, err error) error {
+	status := http.StatusInternalServerError
+	details := JSONErrorDetails{Code: jsonErrorCodeInternal, Message: "internal server error"}
+
+	var clientErr bittorrent.ClientError
+	if errors.As(err, &clientErr) {
+		status = http.StatusBadRequest
+		details = JSONErrorDetails{Code: jsonErrorCodeInvalidRequest, Message: clientErr.Error()}
+
+		code, retryIn := bittorrent.ClientErrorDetails(err)
+		if code != "" {
+			details.Code = code
+		}
+		if retryIn == bittorrent.RetryNever {
+			details.RetryIn = -1
+		} else if retryIn > 0 {
+			details.RetryIn = int64((retryIn + time.Second - 1) / time.Second)
+		}
+	} else {
+		log.Error("http: internal error", log.Err(err))
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(JSONError{Error: details})
+}
+
+// WriteJSONAnnounceResponse communicates the results of an Announce as JSON.
+//
+// Peers are always listed as objects, regardless of whether a compact
+// response was requested.
+func WriteJSONAnnounceResponse(w http.ResponseWriter, resp *bittorrent.AnnounceResponse) error {
+	peers := make([]JSONPeer, 0, len(resp.IPv4Peers)+len(resp.IPv6Peers))
+	for _, ps := range [][]bittorrent.Peer{resp.IPv4Peers, resp.IPv6Peers} {
+		for _, p := range ps {
+			peers = append(peers, JSONPeer{ID: p.ID.String(), IP: p.IP.String(), Port: p.Port})
+		}
+	}
+
+	var externalIP string
+	if resp.ExternalIP != nil {
+		externalIP = resp.ExternalIP.String()
+	}
+
+	return json.NewEncoder(w).Encode(JSONAnnounceResponse{
+		Complete:    resp.Complete,
+		Incomplete:  resp.Incomplete,
+		Interval:    int64(resp.Interval.Seconds()),
+		MinInterval: int64(resp.MinInterval.Seconds()),
+		Peers:       peers,
+
+		WarningMessage: resp.WarningMessage,
+		TrackerID:      resp.TrackerID,
+		ExternalIP:     externalIP,
+	})
+}
+
+// WriteJSONScrapeResponse communicates the results of a Scrape as JSON.
+func WriteJSONScrapeResponse(w http.ResponseWriter, resp *bittorrent.ScrapeResponse) error {
+	files := make(map[string]JSONScrape, len(resp.Files))
+	for _, s := range resp.Files {
+		files[s.InfoHash.String()] = JSONScrape{
+			Complete:    s.Complete,
+			Incomplete:  s.Incomplete,
+			Downloaders: s.Downloaders,
+			Snatches:    s.Snatches,
+		}
+	}
+
+	return json.NewEncoder(w).Encode(JSONScrapeResponse{Files: files})
+}
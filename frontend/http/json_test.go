@@ -0,0 +1,107 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func TestWriteJSONError(t *testing.T) {
+	table := []struct {
+		err      error
+		status   int
+		expected string
+	}{
+		{bittorrent.ClientError("invalid port"), 400, `{"error":{"code":"invalid_request","message":"invalid port"}}`},
+		{bittorrent.ClientError("banned").WithCode("banned_passkey").WithRetryIn(time.Hour), 400, `{"error":{"code":"banned_passkey","message":"banned","retry_in":3600}}`},
+		{errors.New("storage unavailable"), 500, `{"error":{"code":"internal_error","message":"internal server error"}}`},
+	}
+
+	for _, tt := range table {
+		w := httptest.NewRecorder()
+		require.Nil(t, WriteJSONError(w, tt.err))
+		require.Equal(t, tt.status, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		require.JSONEq(t, tt.expected, w.Body.String())
+	}
+}
+
+func TestWriteJSONAnnounceResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := WriteJSONAnnounceResponse(w, &bittorrent.AnnounceResponse{
+		Compact:     true,
+		Complete:    1,
+		Incomplete:  2,
+		Interval:    30 * time.Minute,
+		MinInterval: 15 * time.Minute,
+		IPv4Peers: []bittorrent.Peer{{
+			ID:   bittorrent.PeerIDFromString("-XX1000-abcdefghijkl"),
+			IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4},
+			Port: 6881,
+		}},
+		IPv6Peers: []bittorrent.Peer{{
+			ID:   bittorrent.PeerIDFromString("-XX1000-mnopqrstuvwx"),
+			IP:   bittorrent.IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: bittorrent.IPv6},
+			Port: 6882,
+		}},
+		WarningMessage: "low ratio",
+		ExternalIP:     net.ParseIP("198.51.100.7"),
+	})
+	require.Nil(t, err)
+	require.JSONEq(t, `{
+		"complete": 1,
+		"incomplete": 2,
+		"interval": 1800,
+		"min_interval": 900,
+		"peers": [
+			{"peer_id": "2d5858313030302d6162636465666768696a6b6c", "ip": "192.0.2.1", "port": 6881},
+			{"peer_id": "2d5858313030302d6d6e6f707172737475767778", "ip": "2001:db8::1", "port": 6882}
+		],
+		"warning_message": "low ratio",
+		"external_ip": "198.51.100.7"
+	}`, w.Body.String())
+}
+
+func TestWriteJSONScrapeResponse(t *testing.T) {
+	ih := bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	w := httptest.NewRecorder()
+	err := WriteJSONScrapeResponse(w, &bittorrent.ScrapeResponse{
+		Files: []bittorrent.Scrape{{InfoHash: ih, Snatches: 3, Complete: 1, Incomplete: 2, Downloaders: 1}},
+	})
+	require.Nil(t, err)
+	require.JSONEq(t, `{"files": {"6161616161616161616161616161616161616161": {"complete": 1, "incomplete": 2, "downloaders": 1, "snatches": 3}}}`, w.Body.String())
+}
+
+func TestJSONRoutes(t *testing.T) {
+	f := &Frontend{
+		tenants: []Tenant{{}},
+		Config: Config{
+			AnnounceRoutes:     []string{"/announce"},
+			ScrapeRoutes:       []string{"/scrape"},
+			JSONAnnounceRoutes: []string{"/api/announce"},
+			JSONScrapeRoutes:   []string{"/api/scrape"},
+		},
+	}
+	h := f.handler()
+
+	// Parse errors are answered in the encoding of the route.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/announce", nil))
+	require.Equal(t, 400, w.Code)
+	require.JSONEq(t, `{"error": {"code": "invalid_request", "message": "no info_hash parameter supplied"}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/scrape", nil))
+	require.Equal(t, 400, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/announce", nil))
+	require.Equal(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), "failure reason")
+}
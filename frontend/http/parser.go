@@ -4,21 +4,41 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/pkg/proxyproto"
 )
 
 // ParseOptions is the configuration used to parse an Announce Request.
 //
 // If AllowIPSpoofing is true, IPs provided via BitTorrent params will be used.
-// If RealIPHeader is not empty string, the value of the first HTTP Header with
-// that name will be used.
+// If AllowDualStack is true, an address of the other family than the one of
+// the client, provided via the ipv4 or ipv6 params of BEP 7, is announced in
+// addition; with AllowIPSpoofing, both params are always honored.
+// If RealIPHeader is not empty string, the value of the HTTP Header with that
+// name will be used, if the request was sent from one of the TrustedProxies.
+// X-Forwarded-For and Forwarded headers are parsed as chains of proxies.
+// Without TrustedProxies, the header is ignored for requests received over
+// TCP.
 type ParseOptions struct {
-	AllowIPSpoofing     bool   `yaml:"allow_ip_spoofing"`
-	RealIPHeader        string `yaml:"real_ip_header"`
-	MaxNumWant          uint32 `yaml:"max_numwant"`
-	DefaultNumWant      uint32 `yaml:"default_numwant"`
-	MaxScrapeInfoHashes uint32 `yaml:"max_scrape_infohashes"`
+	AllowIPSpoofing     bool     `yaml:"allow_ip_spoofing"`
+	AllowDualStack      bool     `yaml:"allow_dual_stack"`
+	RealIPHeader        string   `yaml:"real_ip_header"`
+	TrustedProxies      []string `yaml:"trusted_proxies"`
+	MaxNumWant          uint32   `yaml:"max_numwant"`
+	DefaultNumWant      uint32   `yaml:"default_numwant"`
+	MaxScrapeInfoHashes uint32   `yaml:"max_scrape_infohashes"`
+
+	trustedProxies proxyproto.Trusted
+}
+
+// parseTrustedProxies parses TrustedProxies. It must be called before the
+// options are used.
+func (opts *ParseOptions) parseTrustedProxies() (err error) {
+	opts.trustedProxies, err = proxyproto.ParseTrusted(opts.TrustedProxies)
+	return err
 }
 
 // Default parser config constants.
@@ -100,6 +120,12 @@ func ParseAnnounce(r *http.Request, opts ParseOptions) (*bittorrent.AnnounceRequ
 	request.NumWantProvided = err == nil
 	request.NumWant = uint32(numwant)
 
+	// The tracker id is optional and echoed back in the response.
+	request.TrackerID, _ = qp.String("trackerid")
+
+	// The key is optional and identifies the client across IP changes.
+	request.Key, _ = qp.String("key")
+
 	// Parse the port where the client is listening.
 	port, err := qp.Uint("port", 16)
 	if err != nil {
@@ -108,10 +134,18 @@ func ParseAnnounce(r *http.Request, opts ParseOptions) (*bittorrent.AnnounceRequ
 	request.Peer.Port = uint16(port)
 
 	// Parse the IP address where the client is listening.
-	request.Peer.IP.IP, request.IPProvided = requestedIP(r, qp, opts)
+	request.Peer.IP.IP, request.IPProvided, err = requestedIP(r, qp, opts)
+	if err != nil {
+		return nil, err
+	}
 	if request.Peer.IP.IP == nil {
 		return nil, bittorrent.ClientError("failed to parse peer IP address")
 	}
+	if request.SourceIP, err = clientIP(r, opts); err != nil {
+		return nil, err
+	}
+	request.AdditionalPeers = dualStackPeers(qp, request.Peer, opts)
+	request.AdditionalPeersVerified = opts.AllowIPSpoofing
 
 	if err := bittorrent.SanitizeAnnounce(request, opts.MaxNumWant, opts.DefaultNumWant); err != nil {
 		return nil, err
@@ -145,27 +179,98 @@ func ParseScrape(r *http.Request, opts ParseOptions) (*bittorrent.ScrapeRequest,
 }
 
 // requestedIP determines the IP address for a BitTorrent client request.
-func requestedIP(r *http.Request, p bittorrent.Params, opts ParseOptions) (ip net.IP, provided bool) {
+func requestedIP(r *http.Request, p bittorrent.Params, opts ParseOptions) (ip net.IP, provided bool, err error) {
 	if opts.AllowIPSpoofing {
 		if ipstr, ok := p.String("ip"); ok {
-			return net.ParseIP(ipstr), true
+			return net.ParseIP(ipstr), true, nil
 		}
 
 		if ipstr, ok := p.String("ipv4"); ok {
-			return net.ParseIP(ipstr), true
+			return net.ParseIP(ipstr), true, nil
 		}
 
 		if ipstr, ok := p.String("ipv6"); ok {
-			return net.ParseIP(ipstr), true
+			return net.ParseIP(ipstr), true, nil
 		}
 	}
 
-	if opts.RealIPHeader != "" {
-		if ip := r.Header.Get(opts.RealIPHeader); ip != "" {
-			return net.ParseIP(ip), false
+	ip, err = clientIP(r, opts)
+	return ip, false, err
+}
+
+// dualStackPeers returns the endpoints announced via the ipv4 and ipv6 params
+// of BEP 7 in addition to the one of peer. The params may carry a port.
+//
+// Without AllowIPSpoofing, only an address of the other family than the one
+// of peer is accepted. It cannot be verified here, so it is only announced if
+// the client announced from it with the same key before.
+func dualStackPeers(p bittorrent.Params, peer bittorrent.Peer, opts ParseOptions) (peers []bittorrent.Peer) {
+	if !opts.AllowIPSpoofing && !opts.AllowDualStack {
+		return nil
+	}
+
+	peerIsIPv4 := peer.IP.IP.To4() != nil
+	for _, key := range []string{"ipv4", "ipv6"} {
+		str, ok := p.String(key)
+		if !ok {
+			continue
+		}
+
+		ip, port := parseEndpoint(str)
+		isIPv4 := ip.To4() != nil
+		switch {
+		case ip == nil, isIPv4 != (key == "ipv4"), ip.Equal(peer.IP.IP):
+			continue
+		case !opts.AllowIPSpoofing && isIPv4 == peerIsIPv4:
+			continue
 		}
+
+		peers = append(peers, bittorrent.Peer{
+			ID:   peer.ID,
+			IP:   bittorrent.IP{IP: ip},
+			Port: port,
+		})
+	}
+
+	return peers
+}
+
+// parseEndpoint parses an IP address, optionally with a port, such as
+// "192.0.2.1:6881", "[2001:db8::1]:6881" or "2001:db8::1".
+// A missing port is returned as zero.
+func parseEndpoint(s string) (net.IP, uint16) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return net.ParseIP(strings.Trim(s, "[]")), 0
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, 0
 	}
+	return net.ParseIP(host), uint16(port)
+}
 
+// clientIP determines the IP address of the client that sent a request,
+// honoring RealIPHeader.
+//
+// The header is only used for requests sent from one of the TrustedProxies.
+// Requests received on a Unix domain socket have no remote IP address and
+// must have come from a local proxy, so the socket peer is trusted as well.
+// If a trusted proxy sent the header but the client cannot be determined from
+// it, a ClientError is returned rather than the address of the proxy.
+func clientIP(r *http.Request, opts ParseOptions) (net.IP, error) {
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return net.ParseIP(host), false
+	remoteIP := net.ParseIP(host)
+
+	if opts.RealIPHeader != "" && (remoteIP == nil || opts.trustedProxies.Contains(remoteIP)) {
+		if ip, present := headerIP(r.Header, opts.RealIPHeader, opts.trustedProxies); present {
+			if ip == nil {
+				return nil, bittorrent.ClientError("failed to parse client IP address from " + opts.RealIPHeader)
+			}
+			return ip, nil
+		}
+	}
+
+	return remoteIP, nil
 }
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func TestParseAnnounceDualStack(t *testing.T) {
+	const base = "/announce?info_hash=aaaaaaaaaaaaaaaaaaaa&peer_id=-XX1000-abcdefghijkl&port=6881&left=0&downloaded=0&uploaded=0"
+
+	table := []struct {
+		name     string
+		query    string
+		opts     ParseOptions
+		expected []string
+	}{
+		{
+			name:     "disabled",
+			query:    "&ipv6=2001:db8::1",
+			expected: []string{"192.0.2.1:6881"},
+		},
+		{
+			name:     "other family",
+			query:    "&ipv6=2001:db8::1",
+			opts:     ParseOptions{AllowDualStack: true},
+			expected: []string{"192.0.2.1:6881", "[2001:db8::1]:6881"},
+		},
+		{
+			name:     "other family with port",
+			query:    "&ipv4=192.0.2.1&ipv6=%5B2001:db8::1%5D:6882",
+			opts:     ParseOptions{AllowDualStack: true},
+			expected: []string{"192.0.2.1:6881", "[2001:db8::1]:6882"},
+		},
+		{
+			name:     "same family is not spoofed",
+			query:    "&ipv4=198.51.100.7",
+			opts:     ParseOptions{AllowDualStack: true},
+			expected: []string{"192.0.2.1:6881"},
+		},
+		{
+			name:     "mismatched family",
+			query:    "&ipv6=198.51.100.7",
+			opts:     ParseOptions{AllowDualStack: true},
+			expected: []string{"192.0.2.1:6881"},
+		},
+		{
+			name:     "spoofing honors both",
+			query:    "&ipv4=198.51.100.7&ipv6=2001:db8::1",
+			opts:     ParseOptions{AllowIPSpoofing: true},
+			expected: []string{"198.51.100.7:6881", "[2001:db8::1]:6881"},
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", base+tt.query, nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			tt.opts.MaxNumWant, tt.opts.DefaultNumWant = 50, 50
+
+			req, err := ParseAnnounce(r, tt.opts)
+			require.Nil(t, err)
+
+			var endpoints []string
+			for _, p := range req.Peers() {
+				require.Equal(t, req.Peer.ID, p.ID)
+				endpoints = append(endpoints, net.JoinHostPort(p.IP.String(), fmt.Sprint(p.Port)))
+			}
+			require.Equal(t, tt.expected, endpoints)
+			require.Equal(t, bittorrent.IPv4, req.IP.AddressFamily)
+		})
+	}
+}
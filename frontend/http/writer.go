@@ -2,7 +2,9 @@ package http
 
 import (
 	"errors"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/frontend/http/bencode"
@@ -10,19 +12,36 @@ import (
 )
 
 // WriteError communicates an error to a BitTorrent client over HTTP.
+//
+// The code and retry time of a bittorrent.DetailedClientError are sent as
+// "failure code" and BEP 31 "retry in".
 func WriteError(w http.ResponseWriter, err error) error {
-	message := "internal server error"
+	bdict := bencode.Dict{"failure reason": "internal server error"}
 	var clientErr bittorrent.ClientError
 	if errors.As(err, &clientErr) {
-		message = clientErr.Error()
+		bdict["failure reason"] = clientErr.Error()
+
+		code, retryIn := bittorrent.ClientErrorDetails(err)
+		if code != "" {
+			bdict["failure code"] = code
+		}
+		if retryIn == bittorrent.RetryNever {
+			bdict["retry in"] = "never"
+		} else if retryIn > 0 {
+			bdict["retry in"] = retryInMinutes(retryIn)
+		}
 	} else {
 		log.Error("http: internal error", log.Err(err))
 	}
 
 	w.WriteHeader(http.StatusOK)
-	return bencode.NewEncoder(w).Encode(bencode.Dict{
-		"failure reason": message,
-	})
+	return bencode.NewEncoder(w).Encode(bdict)
+}
+
+// retryInMinutes converts a retry time into the minutes of BEP 31, rounded
+// up so that clients never retry early.
+func retryInMinutes(d time.Duration) int64 {
+	return int64((d + time.Minute - 1) / time.Minute)
 }
 
 // WriteAnnounceResponse communicates the results of an Announce to a
@@ -34,6 +53,15 @@ func WriteAnnounceResponse(w http.ResponseWriter, resp *bittorrent.AnnounceRespo
 		"interval":     resp.Interval,
 		"min interval": resp.MinInterval,
 	}
+	if resp.WarningMessage != "" {
+		bdict["warning message"] = resp.WarningMessage
+	}
+	if resp.TrackerID != "" {
+		bdict["tracker id"] = resp.TrackerID
+	}
+	if resp.ExternalIP != nil {
+		bdict["external ip"] = compactIP(resp.ExternalIP)
+	}
 
 	// Add the peers to the dictionary in the compact format.
 	if resp.Compact {
@@ -77,8 +105,9 @@ func WriteScrapeResponse(w http.ResponseWriter, resp *bittorrent.ScrapeResponse)
 	filesDict := bencode.NewDict()
 	for _, scrape := range resp.Files {
 		filesDict[string(scrape.InfoHash[:])] = bencode.Dict{
-			"complete":   scrape.Complete,
-			"incomplete": scrape.Incomplete,
+			"complete":    scrape.Complete,
+			"incomplete":  scrape.Incomplete,
+			"downloaders": scrape.Downloaders,
 		}
 	}
 
@@ -87,6 +116,15 @@ func WriteScrapeResponse(w http.ResponseWriter, resp *bittorrent.ScrapeResponse)
 	})
 }
 
+// compactIP returns the 4 or 16 bytes of an IP address, depending on its
+// family.
+func compactIP(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
 func compact4(peer bittorrent.Peer) (buf []byte) {
 	if ip := peer.IP.To4(); ip == nil {
 		panic("non-IPv4 IP for Peer in IPv4Peers")
@@ -2,12 +2,15 @@ package http
 
 import (
 	"fmt"
+	"net"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/frontend/http/bencode"
 )
 
 func TestWriteError(t *testing.T) {
@@ -44,3 +47,72 @@ func TestWriteStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteErrorDetails(t *testing.T) {
+	table := []struct {
+		err      error
+		expected bencode.Dict
+	}{
+		{
+			bittorrent.ClientError("banned").WithCode("banned_passkey"),
+			bencode.Dict{"failure reason": "banned", "failure code": "banned_passkey"},
+		},
+		{
+			bittorrent.ClientError("overloaded").WithRetryIn(90 * time.Second),
+			bencode.Dict{"failure reason": "overloaded", "retry in": int64(2)},
+		},
+		{
+			bittorrent.ClientError("banned").WithRetryIn(bittorrent.RetryNever),
+			bencode.Dict{"failure reason": "banned", "retry in": "never"},
+		},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRecorder()
+		require.Nil(t, WriteError(r, tt.err))
+		decoded, err := bencode.Unmarshal(r.Body.Bytes())
+		require.Nil(t, err)
+		require.Equal(t, tt.expected, decoded)
+	}
+}
+
+func TestWriteAnnounceResponseWarning(t *testing.T) {
+	for _, compact := range []bool{true, false} {
+		r := httptest.NewRecorder()
+		require.Nil(t, WriteAnnounceResponse(r, &bittorrent.AnnounceResponse{
+			Compact:        compact,
+			WarningMessage: "low ratio",
+			TrackerID:      "chihaya",
+		}))
+		decoded, err := bencode.Unmarshal(r.Body.Bytes())
+		require.Nil(t, err)
+		require.Equal(t, "low ratio", decoded.(bencode.Dict)["warning message"])
+		require.Equal(t, "chihaya", decoded.(bencode.Dict)["tracker id"])
+	}
+
+	// Empty values are omitted.
+	r := httptest.NewRecorder()
+	require.Nil(t, WriteAnnounceResponse(r, &bittorrent.AnnounceResponse{Compact: true}))
+	decoded, err := bencode.Unmarshal(r.Body.Bytes())
+	require.Nil(t, err)
+	require.NotContains(t, decoded, "warning message")
+	require.NotContains(t, decoded, "tracker id")
+}
+
+func TestWriteAnnounceResponseExternalIP(t *testing.T) {
+	table := []struct {
+		ip       string
+		expected string
+	}{
+		{"192.0.2.1", "\xc0\x00\x02\x01"},
+		{"2001:db8::1", "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"},
+	}
+
+	for _, tt := range table {
+		r := httptest.NewRecorder()
+		require.Nil(t, WriteAnnounceResponse(r, &bittorrent.AnnounceResponse{Compact: true, ExternalIP: net.ParseIP(tt.ip)}))
+		decoded, err := bencode.Unmarshal(r.Body.Bytes())
+		require.Nil(t, err)
+		require.Equal(t, tt.expected, decoded.(bencode.Dict)["external ip"])
+	}
+}
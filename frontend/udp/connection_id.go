@@ -114,3 +114,91 @@ func (g *ConnectionIDGenerator) Validate(connectionID []byte, ip net.IP, now tim
 	g.scratch = g.mac.Sum(g.scratch)
 	return hmac.Equal(g.scratch[:4], connectionID[4:])
 }
+
+// keyRing derives the keys used for connection IDs from a secret.
+//
+// If interval is positive, the key changes every interval. Keys are derived
+// from the secret and the number of the current interval, so every tracker
+// sharing the secret rotates to the same key at the same time.
+type keyRing struct {
+	secret   string
+	interval time.Duration
+}
+
+// epoch returns the number of the interval now falls into.
+func (k keyRing) epoch(now time.Time) int64 {
+	if k.interval <= 0 {
+		return 0
+	}
+	return now.UnixNano() / int64(k.interval)
+}
+
+// key returns the key for an epoch.
+func (k keyRing) key(epoch int64) string {
+	if k.interval <= 0 {
+		return k.secret
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(epoch))
+	mac := hmac.New(sha256.New, []byte(k.secret))
+	mac.Write(buf[:])
+	return string(mac.Sum(nil))
+}
+
+// rotatingGenerator generates and validates connection IDs with the current
+// key of a keyRing, accepting connection IDs generated with the previous key
+// for maxClockSkew after a rotation.
+//
+// Like ConnectionIDGenerator, it is not thread safe, but safe to be pooled.
+type rotatingGenerator struct {
+	ring     keyRing
+	epoch    int64
+	current  *ConnectionIDGenerator
+	previous *ConnectionIDGenerator
+}
+
+func newRotatingGenerator(ring keyRing) *rotatingGenerator {
+	return &rotatingGenerator{ring: ring, epoch: -1}
+}
+
+// sync updates the keys of the generator to the epoch of now.
+func (g *rotatingGenerator) sync(now time.Time) {
+	epoch := g.ring.epoch(now)
+	if epoch == g.epoch {
+		return
+	}
+
+	if g.current != nil && epoch == g.epoch+1 {
+		g.previous = g.current
+	} else if g.ring.interval > 0 {
+		g.previous = NewConnectionIDGenerator(g.ring.key(epoch - 1))
+	}
+	g.current = NewConnectionIDGenerator(g.ring.key(epoch))
+	g.epoch = epoch
+}
+
+// Generate generates a connection ID with the current key.
+// See ConnectionIDGenerator.Generate for usage notes.
+func (g *rotatingGenerator) Generate(ip net.IP, now time.Time) []byte {
+	g.sync(now)
+	return g.current.Generate(ip, now)
+}
+
+// Validate validates a connection ID with the current key or, shortly after a
+// rotation, the previous key.
+func (g *rotatingGenerator) Validate(connectionID []byte, ip net.IP, now time.Time, maxClockSkew time.Duration) bool {
+	g.sync(now)
+	if g.current.Validate(connectionID, ip, now, maxClockSkew) {
+		return true
+	}
+
+	if g.previous == nil {
+		return false
+	}
+	rotatedAt := time.Unix(0, g.epoch*int64(g.ring.interval))
+	if now.Sub(rotatedAt) > maxClockSkew {
+		return false
+	}
+	return g.previous.Validate(connectionID, ip, now, maxClockSkew)
+}
@@ -191,3 +191,29 @@ func BenchmarkConnectionIDGenerator_Validate(b *testing.B) {
 		}
 	})
 }
+
+func TestRotatingGenerator(t *testing.T) {
+	ring := keyRing{"secret", time.Hour}
+	ip := net.ParseIP("127.0.0.1").To4()
+	skew := 10 * time.Second
+	rotation := time.Unix(0, 0).Add(1000 * time.Hour)
+
+	gen := newRotatingGenerator(ring)
+	before := append([]byte{}, gen.Generate(ip, rotation.Add(-time.Second))...)
+
+	// A fresh generator in the same epoch produces the same ID.
+	require.Equal(t, before, newRotatingGenerator(ring).Generate(ip, rotation.Add(-time.Second)))
+
+	// The previous key is accepted for maxClockSkew after the rotation.
+	require.True(t, gen.Validate(before, ip, rotation.Add(skew/2), skew))
+	require.True(t, newRotatingGenerator(ring).Validate(before, ip, rotation.Add(skew/2), skew))
+	require.False(t, gen.Validate(before, ip, rotation.Add(2*skew), skew))
+
+	// IDs of the new epoch are generated with a different key.
+	after := gen.Generate(ip, rotation.Add(-time.Second+time.Hour))
+	require.False(t, ValidConnectionID(after, ip, rotation, skew, "secret"))
+
+	// Without rotation, the private key is used as is.
+	static := newRotatingGenerator(keyRing{secret: "secret"})
+	require.Equal(t, NewConnectionID(ip, rotation, "secret"), static.Generate(ip, rotation))
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"runtime"
 	"sync"
 	"time"
 
@@ -17,29 +18,83 @@ import (
 	"github.com/chihaya/chihaya/frontend"
 	"github.com/chihaya/chihaya/frontend/udp/bytepool"
 	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/proxyproto"
 	"github.com/chihaya/chihaya/pkg/stop"
 	"github.com/chihaya/chihaya/pkg/timecache"
 )
 
+// Default socket and worker pool config constants.
+const (
+	defaultBatchSize = 32
+	defaultQueueSize = 4096
+)
+
 var allowedGeneratedPrivateKeyRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890")
 
 // Config represents all of the configurable options for a UDP BitTorrent
 // Tracker.
 type Config struct {
 	Addr                string        `yaml:"addr"`
+	Addrs               []string      `yaml:"addrs"`
 	PrivateKey          string        `yaml:"private_key"`
 	MaxClockSkew        time.Duration `yaml:"max_clock_skew"`
 	EnableRequestTiming bool          `yaml:"enable_request_timing"`
-	ParseOptions        `yaml:",inline"`
+
+	// KeyRotationInterval is the interval in which the key for connection IDs
+	// is derived anew from PrivateKey. Zero disables rotation.
+	KeyRotationInterval time.Duration `yaml:"key_rotation_interval"`
+
+	// ConnectRateLimit and AnnounceRateLimit are the number of connects and
+	// announces per second accepted from a single IP, with bursts of up to
+	// ConnectBurst and AnnounceBurst. Excess packets are dropped silently.
+	// Zero disables the limit.
+	ConnectRateLimit  float64 `yaml:"connect_rate_limit"`
+	ConnectBurst      int     `yaml:"connect_burst"`
+	AnnounceRateLimit float64 `yaml:"announce_rate_limit"`
+	AnnounceBurst     int     `yaml:"announce_burst"`
+
+	// Readers is the number of goroutines reading packets. With ReusePort,
+	// each reader gets its own socket.
+	Readers   int  `yaml:"readers"`
+	ReusePort bool `yaml:"reuse_port"`
+
+	// BatchSize is the maximum number of packets read or written with a
+	// single syscall.
+	BatchSize int `yaml:"batch_size"`
+
+	// Workers is the number of goroutines handling requests and QueueSize
+	// the number of packets that may wait for a worker. Packets that do not
+	// fit into the queue are dropped.
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+
+	// ProxyProtocolTrusted is a list of CIDRs of proxies allowed to prefix
+	// packets with a PROXY protocol version 2 header. Packets from other
+	// addresses are taken as is.
+	ProxyProtocolTrusted []string `yaml:"proxy_protocol_trusted"`
+
+	ParseOptions `yaml:",inline"`
 }
 
 // LogFields renders the current config as a set of Logrus fields.
 func (cfg Config) LogFields() log.Fields {
 	return log.Fields{
 		"addr":                cfg.Addr,
+		"addrs":               cfg.Addrs,
 		"privateKey":          cfg.PrivateKey,
 		"maxClockSkew":        cfg.MaxClockSkew,
 		"enableRequestTiming": cfg.EnableRequestTiming,
+		"keyRotationInterval": cfg.KeyRotationInterval,
+		"connectRateLimit":    cfg.ConnectRateLimit,
+		"connectBurst":        cfg.ConnectBurst,
+		"announceRateLimit":   cfg.AnnounceRateLimit,
+		"announceBurst":       cfg.AnnounceBurst,
+		"readers":             cfg.Readers,
+		"reusePort":           cfg.ReusePort,
+		"batchSize":           cfg.BatchSize,
+		"workers":             cfg.Workers,
+		"queueSize":           cfg.QueueSize,
+		"proxyProtocol":       cfg.ProxyProtocolTrusted,
 		"allowIPSpoofing":     cfg.AllowIPSpoofing,
 		"maxNumWant":          cfg.MaxNumWant,
 		"defaultNumWant":      cfg.DefaultNumWant,
@@ -66,6 +121,45 @@ func (cfg Config) Validate() Config {
 		log.Warn("UDP private key was not provided, using generated key", log.Fields{"key": validcfg.PrivateKey})
 	}
 
+	if cfg.Readers <= 0 {
+		validcfg.Readers = 1
+		if cfg.ReusePort {
+			validcfg.Readers = runtime.NumCPU()
+		}
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "udp.Readers",
+			"provided": cfg.Readers,
+			"default":  validcfg.Readers,
+		})
+	}
+
+	if cfg.BatchSize <= 0 {
+		validcfg.BatchSize = defaultBatchSize
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "udp.BatchSize",
+			"provided": cfg.BatchSize,
+			"default":  validcfg.BatchSize,
+		})
+	}
+
+	if cfg.Workers <= 0 {
+		validcfg.Workers = runtime.NumCPU() * 4
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "udp.Workers",
+			"provided": cfg.Workers,
+			"default":  validcfg.Workers,
+		})
+	}
+
+	if cfg.QueueSize <= 0 {
+		validcfg.QueueSize = defaultQueueSize
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "udp.QueueSize",
+			"provided": cfg.QueueSize,
+			"default":  validcfg.QueueSize,
+		})
+	}
+
 	if cfg.MaxNumWant <= 0 {
 		validcfg.MaxNumWant = defaultMaxNumWant
 		log.Warn("falling back to default configuration", log.Fields{
@@ -98,12 +192,19 @@ func (cfg Config) Validate() Config {
 
 // Frontend holds the state of a UDP BitTorrent Frontend.
 type Frontend struct {
-	socket  *net.UDPConn
+	sockets socketSet
+	queue   chan packet
 	closing chan struct{}
-	wg      sync.WaitGroup
+	readers sync.WaitGroup
+	workers sync.WaitGroup
 
+	pool    *bytepool.BytePool
 	genPool *sync.Pool
 
+	connectLimiter  *rateLimiter
+	announceLimiter *rateLimiter
+	trustedProxies  proxyproto.Trusted
+
 	logic frontend.TrackerLogic
 	Config
 }
@@ -112,27 +213,36 @@ type Frontend struct {
 // serves requests.
 func NewFrontend(logic frontend.TrackerLogic, provided Config) (*Frontend, error) {
 	cfg := provided.Validate()
+	if !cfg.Enabled() {
+		return nil, errors.New("must specify addr or addrs")
+	}
+
+	trusted, err := proxyproto.ParseTrusted(cfg.ProxyProtocolTrusted)
+	if err != nil {
+		return nil, err
+	}
 
 	f := &Frontend{
-		closing: make(chan struct{}),
-		logic:   logic,
-		Config:  cfg,
+		trustedProxies: trusted,
+		queue:          make(chan packet, cfg.QueueSize),
+		closing:        make(chan struct{}),
+		pool:           bytepool.New(maxPacketSize),
+		logic:          logic,
+		Config:         cfg,
 		genPool: &sync.Pool{
 			New: func() interface{} {
-				return NewConnectionIDGenerator(cfg.PrivateKey)
+				return newRotatingGenerator(keyRing{cfg.PrivateKey, cfg.KeyRotationInterval})
 			},
 		},
+		connectLimiter:  newRateLimiter(cfg.ConnectRateLimit, cfg.ConnectBurst),
+		announceLimiter: newRateLimiter(cfg.AnnounceRateLimit, cfg.AnnounceBurst),
 	}
 
 	if err := f.listen(); err != nil {
 		return nil, err
 	}
 
-	go func() {
-		if err := f.serve(); err != nil {
-			log.Fatal("failed while serving udp", log.Err(err))
-		}
-	}()
+	f.serve()
 
 	return f, nil
 }
@@ -148,86 +258,259 @@ func (t *Frontend) Stop() stop.Result {
 	c := make(stop.Channel)
 	go func() {
 		close(t.closing)
-		_ = t.socket.SetReadDeadline(time.Now())
-		t.wg.Wait()
-		c.Done(t.socket.Close())
+		for _, s := range t.sockets {
+			_ = s.conn.SetReadDeadline(time.Now())
+		}
+		t.readers.Wait()
+
+		// Handle what has been read so far, then flush the responses.
+		close(t.queue)
+		t.workers.Wait()
+		t.sockets.closeWrites()
+
+		var err error
+		for _, s := range t.sockets {
+			if cerr := s.conn.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		c.Done(err)
 	}()
 
 	return c.Result()
 }
 
-// listen resolves the address and binds the server socket.
+// ListenAddrs returns all addresses to listen on.
+func (cfg Config) ListenAddrs() []string {
+	var addrs []string
+	if cfg.Addr != "" {
+		addrs = append(addrs, cfg.Addr)
+	}
+	for _, addr := range cfg.Addrs {
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Enabled reports whether the config specifies an address to listen on.
+func (cfg Config) Enabled() bool {
+	return len(cfg.ListenAddrs()) != 0
+}
+
+// LocalAddr returns the first address the Frontend is listening on.
+func (t *Frontend) LocalAddr() net.Addr {
+	return t.sockets[0].conn.LocalAddr()
+}
+
+// LocalAddrs returns all addresses the Frontend is listening on, in the order
+// they were configured.
+func (t *Frontend) LocalAddrs() []net.Addr {
+	var addrs []net.Addr
+	for i := 0; i < len(t.sockets); i += t.socketsPerAddr() {
+		addrs = append(addrs, t.sockets[i].conn.LocalAddr())
+	}
+	return addrs
+}
+
+// socketsPerAddr returns the number of sockets bound to each address.
+func (t *Frontend) socketsPerAddr() int {
+	if t.ReusePort {
+		return t.Readers
+	}
+	return 1
+}
+
+// listen resolves the addresses and binds the server sockets.
+//
+// With ReusePort, one socket per reader is bound to each address and the
+// kernel distributes packets among them.
 func (t *Frontend) listen() error {
-	udpAddr, err := net.ResolveUDPAddr("udp", t.Addr)
-	if err != nil {
-		return err
+	for _, addr := range t.ListenAddrs() {
+		for i := 0; i < t.socketsPerAddr(); i++ {
+			conn, err := listenSocket(addr, t.ReusePort)
+			if err != nil {
+				for _, s := range t.sockets {
+					_ = s.conn.Close()
+				}
+				t.sockets = nil
+				return err
+			}
+
+			// Bind all further sockets to the port chosen for the first
+			// one, in case an ephemeral port was requested.
+			addr = conn.LocalAddr().String()
+			t.sockets = append(t.sockets, newSocket(conn, t.BatchSize))
+		}
 	}
-	t.socket, err = net.ListenUDP("udp", udpAddr)
-	return err
+
+	return nil
 }
 
-// serve blocks while listening and serving UDP BitTorrent requests
-// until Stop() is called or an error is returned.
-func (t *Frontend) serve() error {
-	pool := bytepool.New(2048)
+// serve starts the goroutines that read, handle and answer UDP BitTorrent
+// requests until Stop() is called.
+func (t *Frontend) serve() {
+	for _, s := range t.sockets {
+		go s.writeLoop(t.BatchSize)
+	}
 
-	t.wg.Add(1)
-	defer t.wg.Done()
+	t.workers.Add(t.Workers)
+	for i := 0; i < t.Workers; i++ {
+		go t.work()
+	}
+
+	if t.connectLimiter != nil || t.announceLimiter != nil {
+		t.readers.Add(1)
+		go t.collectRateLimiters()
+	}
+
+	// Every address gets Readers readers, spread over its sockets.
+	n := len(t.sockets) / t.socketsPerAddr() * t.Readers
+	t.readers.Add(n)
+	for i := 0; i < n; i++ {
+		s := t.sockets[i%len(t.sockets)]
+		go func() {
+			defer t.readers.Done()
+			if err := t.read(s); err != nil {
+				log.Fatal("failed while serving udp", log.Err(err))
+			}
+		}()
+	}
+}
+
+// collectRateLimiters periodically frees the memory of idle rate limits until
+// Stop() is called.
+func (t *Frontend) collectRateLimiters() {
+	defer t.readers.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closing:
+			return
+		case now := <-ticker.C:
+			t.connectLimiter.collect(now)
+			t.announceLimiter.collect(now)
+		}
+	}
+}
+
+// read reads batches of packets from a socket into the queue until Stop() is
+// called or an error is returned.
+func (t *Frontend) read(s *socket) error {
+	r := newReaderState(t.pool, t.BatchSize)
+	defer r.release(t.pool)
+
+	enqueue := func(p packet) {
+		// The backlog is raised before sending, so that a worker taking the
+		// packet cannot lower it first.
+		promBacklog.Inc()
+		select {
+		case t.queue <- p:
+		default:
+			// Dropping is what the kernel would do if we didn't read.
+			promBacklog.Dec()
+			promDroppedPackets.Inc()
+			t.pool.Put(p.buffer)
+		}
+	}
 
 	for {
 		// Check to see if we need to shutdown.
 		select {
 		case <-t.closing:
-			log.Debug("udp serve() received shutdown signal")
+			log.Debug("udp read() received shutdown signal")
 			return nil
 		default:
 		}
 
-		// Read a UDP packet into a reusable buffer.
-		buffer := pool.Get()
-		n, addr, err := t.socket.ReadFromUDP(*buffer)
-		if err != nil {
-			pool.Put(buffer)
+		if err := r.read(t.pool, s, enqueue); err != nil {
+			select {
+			case <-t.closing:
+				return nil
+			default:
+			}
+
 			var netErr net.Error
-			if errors.As(err, &netErr); netErr.Temporary() {
+			if errors.As(err, &netErr) && netErr.Temporary() {
 				// A temporary failure is not fatal; just pretend it never happened.
 				continue
 			}
 			return err
 		}
+	}
+}
+
+// work handles packets from the queue until it is closed.
+func (t *Frontend) work() {
+	defer t.workers.Done()
+
+	for p := range t.queue {
+		promBacklog.Dec()
+
+		addr := p.addr
+		if ip := addr.IP.To4(); ip != nil {
+			addr.IP = ip
+		}
 
-		// We got nothin'
-		if n == 0 {
-			pool.Put(buffer)
+		// Responses always go back to the sender, which may be a proxy
+		// acting on behalf of the client.
+		payload, clientIP, ok := t.unwrapProxied((*p.buffer)[:p.n], addr.IP)
+		if !ok {
+			t.pool.Put(p.buffer)
 			continue
 		}
 
-		t.wg.Add(1)
-		go func() {
-			defer t.wg.Done()
-			defer pool.Put(buffer)
+		// Handle the request.
+		var start time.Time
+		if t.EnableRequestTiming {
+			start = time.Now()
+		}
+		action, af, err := t.handleRequest(
+			// Make sure the IP is copied, not referenced.
+			Request{payload, append([]byte{}, clientIP...)},
+			ResponseWriter{p.socket, addr},
+		)
+		if t.EnableRequestTiming {
+			recordResponseDuration(action, af, err, time.Since(start))
+		} else {
+			recordResponseDuration(action, af, err, time.Duration(0))
+		}
 
-			if ip := addr.IP.To4(); ip != nil {
-				addr.IP = ip
-			}
+		t.pool.Put(p.buffer)
+	}
+}
 
-			// Handle the request.
-			var start time.Time
-			if t.EnableRequestTiming {
-				start = time.Now()
-			}
-			action, af, err := t.handleRequest(
-				// Make sure the IP is copied, not referenced.
-				Request{(*buffer)[:n], append([]byte{}, addr.IP...)},
-				ResponseWriter{t.socket, addr},
-			)
-			if t.EnableRequestTiming {
-				recordResponseDuration(action, af, err, time.Since(start))
-			} else {
-				recordResponseDuration(action, af, err, time.Duration(0))
-			}
-		}()
+// unwrapProxied strips a PROXY protocol header sent by a trusted proxy from a
+// packet and returns the payload along with the IP of the client.
+// It reports false for packets that should be dropped.
+func (t *Frontend) unwrapProxied(packet []byte, ip net.IP) ([]byte, net.IP, bool) {
+	if !t.trustedProxies.Contains(ip) {
+		return packet, ip, true
 	}
+
+	hdr, n, err := proxyproto.ParseV2(packet)
+	switch {
+	case errors.Is(err, proxyproto.ErrNoHeader):
+		return packet, ip, true
+	case err != nil:
+		log.Debug("udp: dropping packet with invalid PROXY header", log.Fields{"proxy": ip, "error": err})
+		return nil, nil, false
+	}
+
+	src, ok := hdr.Source.(*net.UDPAddr)
+	if hdr.Local || !ok {
+		// The proxy did not tell us about a client.
+		return packet[n:], ip, true
+	}
+
+	clientIP := src.IP
+	if ip4 := clientIP.To4(); ip4 != nil {
+		clientIP = ip4
+	}
+	return packet[n:], clientIP, true
 }
 
 // Request represents a UDP payload received by a Tracker.
@@ -239,13 +522,15 @@ type Request struct {
 // ResponseWriter implements the ability to respond to a Request via the
 // io.Writer interface.
 type ResponseWriter struct {
-	socket *net.UDPConn
+	socket *socket
 	addr   *net.UDPAddr
 }
 
 // Write implements the io.Writer interface for a ResponseWriter.
+//
+// The response is copied and sent asynchronously.
 func (w ResponseWriter) Write(b []byte) (int, error) {
-	_, _ = w.socket.WriteToUDP(b, w.addr)
+	w.socket.write(b, w.addr)
 	return len(b), nil
 }
 
@@ -263,8 +548,25 @@ func (t *Frontend) handleRequest(r Request, w ResponseWriter) (actionName string
 	actionID := binary.BigEndian.Uint32(r.Packet[8:12])
 	txID := r.Packet[12:16]
 
+	// Drop packets exceeding the rate limits without answering, so we can't
+	// be used to amplify traffic towards spoofed addresses. This happens
+	// before the connection ID is validated, so that floods with invalid
+	// connection IDs are limited as well.
+	var limiter *rateLimiter
+	switch actionID {
+	case connectActionID:
+		actionName, limiter = "connect", t.connectLimiter
+	case announceActionID, announceV6ActionID:
+		actionName, limiter = "announce", t.announceLimiter
+	}
+	if !limiter.allow(r.IP, timecache.Now()) {
+		promRateLimitedPackets.WithLabelValues(actionName).Inc()
+		err = errRateLimited
+		return
+	}
+
 	// get a connection ID generator/validator from the pool.
-	gen := t.genPool.Get().(*ConnectionIDGenerator)
+	gen := t.genPool.Get().(*rotatingGenerator)
 	defer t.genPool.Put(gen)
 
 	// If this isn't requesting a new connection ID and the connection ID is
@@ -319,7 +621,7 @@ func (t *Frontend) handleRequest(r Request, w ResponseWriter) (actionName string
 
 		WriteAnnounce(w, txID, resp, actionID == announceV6ActionID, req.IP.AddressFamily == bittorrent.IPv6)
 
-		go t.logic.AfterAnnounce(ctx, req, resp)
+		t.logic.AfterAnnounce(ctx, req, resp)
 
 	case scrapeActionID:
 		actionName = "scrape"
@@ -352,7 +654,7 @@ func (t *Frontend) handleRequest(r Request, w ResponseWriter) (actionName string
 
 		WriteScrape(w, txID, resp)
 
-		go t.logic.AfterScrape(ctx, req, resp)
+		t.logic.AfterScrape(ctx, req, resp)
 
 	default:
 		err = errUnknownAction
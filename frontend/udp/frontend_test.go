@@ -1,7 +1,10 @@
 package udp_test
 
 import (
+	"encoding/binary"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/chihaya/chihaya/frontend/udp"
 	"github.com/chihaya/chihaya/middleware"
@@ -15,7 +18,10 @@ func TestStartStopRaceIssue437(t *testing.T) {
 		t.Fatal(err)
 	}
 	var responseConfig middleware.ResponseConfig
-	lgc := middleware.NewLogic(responseConfig, ps, nil, nil)
+	lgc, err := middleware.NewLogic(responseConfig, ps, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fe, err := udp.NewFrontend(lgc, udp.Config{Addr: "127.0.0.1:0"})
 	if err != nil {
 		t.Fatal(err)
@@ -25,3 +31,114 @@ func TestStartStopRaceIssue437(t *testing.T) {
 		t.Fatal(errs[0])
 	}
 }
+
+func TestConnectRoundTrip(t *testing.T) {
+	ps, err := storage.NewPeerStore("memory", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lgc, err := middleware.NewLogic(middleware.ResponseConfig{}, ps, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cfg := range []udp.Config{
+		{Addr: "127.0.0.1:0", Readers: 2, Workers: 2, BatchSize: 4},
+		{Addr: "127.0.0.1:0", Readers: 2, ReusePort: true, Workers: 2},
+		{Addr: "127.0.0.1:0", Addrs: []string{"127.0.0.1:0"}, Readers: 2, ReusePort: true, Workers: 2},
+	} {
+		fe, err := udp.NewFrontend(lgc, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fe.LocalAddrs()) != len(cfg.ListenAddrs()) {
+			t.Fatalf("listening on %v, expected %d addresses", fe.LocalAddrs(), len(cfg.ListenAddrs()))
+		}
+
+		for _, addr := range fe.LocalAddrs() {
+			conn, err := net.Dial("udp", addr.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Connect request: protocol ID, action 0, transaction ID.
+			packet := make([]byte, 16)
+			binary.BigEndian.PutUint64(packet[0:8], 0x41727101980)
+			binary.BigEndian.PutUint32(packet[12:16], 0xdeadbeef)
+			for i := 0; i < 10; i++ {
+				if _, err := conn.Write(packet); err != nil {
+					t.Fatal(err)
+				}
+
+				resp := make([]byte, 64)
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := conn.Read(resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 16 || binary.BigEndian.Uint32(resp[0:4]) != 0 || binary.BigEndian.Uint32(resp[4:8]) != 0xdeadbeef {
+					t.Fatalf("unexpected connect response %x", resp[:n])
+				}
+			}
+			conn.Close()
+		}
+
+		if errs := <-fe.Stop(); len(errs) != 0 {
+			t.Fatal(errs[0])
+		}
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	ps, err := storage.NewPeerStore("memory", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lgc, err := middleware.NewLogic(middleware.ResponseConfig{}, ps, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fe, err := udp.NewFrontend(lgc, udp.Config{
+		Addr:                 "127.0.0.1:0",
+		PrivateKey:           "key",
+		ProxyProtocolTrusted: []string{"127.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { <-fe.Stop() }()
+
+	conn, err := net.Dial("udp", fe.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A PROXY v2 header for a UDP4 packet from 1.2.3.4:6881, followed by a
+	// connect request.
+	packet := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x12\x00\x0c")
+	packet = append(packet, 1, 2, 3, 4, 127, 0, 0, 1, 0x1a, 0xe1, 0x1a, 0xe1)
+	connect := make([]byte, 16)
+	binary.BigEndian.PutUint64(connect[0:8], 0x41727101980)
+	packet = append(packet, connect...)
+
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 16 {
+		t.Fatalf("unexpected connect response %x", resp[:n])
+	}
+
+	// The connection ID is bound to the IP of the client, not the proxy.
+	connID := resp[8:16]
+	if !udp.ValidConnectionID(connID, net.IP{1, 2, 3, 4}, time.Now(), time.Minute, "key") {
+		t.Fatal("connection ID not generated for the proxied client")
+	}
+}
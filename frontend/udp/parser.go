@@ -32,12 +32,13 @@ var (
 	// initialConnectionID is the magic initial connection ID specified by BEP 15.
 	initialConnectionID = []byte{0, 0, 0x04, 0x17, 0x27, 0x10, 0x19, 0x80}
 
-	// eventIDs map values described in BEP 15 to Events.
+	// eventIDs map values described in BEP 15 and BEP 21 to Events.
 	eventIDs = []bittorrent.Event{
 		bittorrent.None,
 		bittorrent.Completed,
 		bittorrent.Started,
 		bittorrent.Stopped,
+		bittorrent.Paused,
 	}
 
 	errMalformedPacket   = bittorrent.ClientError("malformed packet")
@@ -45,6 +46,7 @@ var (
 	errMalformedEvent    = bittorrent.ClientError("malformed event ID")
 	errUnknownAction     = bittorrent.ClientError("unknown action ID")
 	errBadConnectionID   = bittorrent.ClientError("bad connection ID")
+	errRateLimited       = bittorrent.ClientError("rate limited")
 	errUnknownOptionType = bittorrent.ClientError("unknown option type")
 )
 
@@ -96,7 +98,8 @@ func ParseAnnounce(r Request, v6Action bool, opts ParseOptions) (*bittorrent.Ann
 	ipbytes := r.Packet[84:ipEnd]
 	if opts.AllowIPSpoofing {
 		// Make sure the bytes are copied to a new slice.
-		copy(ip, net.IP(ipbytes))
+		ip = make(net.IP, len(ipbytes))
+		copy(ip, ipbytes)
 		ipProvided = true
 	}
 	if !opts.AllowIPSpoofing && r.IP == nil {
@@ -104,6 +107,7 @@ func ParseAnnounce(r Request, v6Action bool, opts ParseOptions) (*bittorrent.Ann
 		return nil, errMalformedIP
 	}
 
+	key := binary.BigEndian.Uint32(r.Packet[ipEnd : ipEnd+4])
 	numWant := binary.BigEndian.Uint32(r.Packet[ipEnd+4 : ipEnd+8])
 	port := binary.BigEndian.Uint16(r.Packet[ipEnd+8 : ipEnd+10])
 
@@ -122,6 +126,8 @@ func ParseAnnounce(r Request, v6Action bool, opts ParseOptions) (*bittorrent.Ann
 		IPProvided:      ipProvided,
 		NumWantProvided: true,
 		EventProvided:   true,
+		Key:             fmt.Sprintf("%08x", key),
+		SourceIP:        r.IP,
 		Peer: bittorrent.Peer{
 			ID:   bittorrent.PeerIDFromBytes(peerID),
 			IP:   bittorrent.IP{IP: ip},
@@ -1,9 +1,13 @@
 package udp
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"net"
 	"testing"
+
+	"github.com/chihaya/chihaya/bittorrent"
 )
 
 var table = []struct {
@@ -75,3 +79,43 @@ func TestHandleOptionalParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAnnounceKey(t *testing.T) {
+	packet := make([]byte, 98)
+	copy(packet[16:36], "aaaaaaaaaaaaaaaaaaaa")
+	copy(packet[36:56], "-XX1000-abcdefghijkl")
+	packet[83] = 4 // paused
+	binary.BigEndian.PutUint32(packet[88:92], 0xdeadbeef)
+	binary.BigEndian.PutUint16(packet[96:98], 6881)
+
+	req, err := ParseAnnounce(Request{Packet: packet, IP: net.ParseIP("192.0.2.1").To4()}, false, ParseOptions{MaxNumWant: 50, DefaultNumWant: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Key != "deadbeef" {
+		t.Errorf("expected key deadbeef, got %q", req.Key)
+	}
+	if req.Event != bittorrent.Paused {
+		t.Errorf("expected event paused, got %s", req.Event)
+	}
+}
+
+func TestParseAnnounceSourceIP(t *testing.T) {
+	packet := make([]byte, 98)
+	copy(packet[16:36], "aaaaaaaaaaaaaaaaaaaa")
+	copy(packet[36:56], "-XX1000-abcdefghijkl")
+	copy(packet[84:88], net.ParseIP("192.168.1.10").To4())
+	binary.BigEndian.PutUint16(packet[96:98], 6881)
+
+	source := net.ParseIP("192.0.2.1").To4()
+	req, err := ParseAnnounce(Request{Packet: packet, IP: source}, false, ParseOptions{AllowIPSpoofing: true, MaxNumWant: 50, DefaultNumWant: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.IP.Equal(net.ParseIP("192.168.1.10")) {
+		t.Errorf("expected IP 192.168.1.10, got %s", req.IP)
+	}
+	if !req.SourceIP.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("expected source IP 192.0.2.1, got %s", req.SourceIP)
+	}
+}
@@ -10,9 +10,36 @@ import (
 )
 
 func init() {
-	prometheus.MustRegister(promResponseDurationMilliseconds)
+	prometheus.MustRegister(
+		promResponseDurationMilliseconds,
+		promBacklog,
+		promDroppedPackets,
+		promRateLimitedPackets,
+	)
 }
 
+var (
+	// promBacklog is a gauge of the number of packets waiting for a worker.
+	promBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chihaya_udp_backlog",
+		Help: "The number of UDP packets waiting to be handled",
+	})
+
+	// promDroppedPackets is a counter of packets dropped because the backlog
+	// was full.
+	promDroppedPackets = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chihaya_udp_dropped_packets_total",
+		Help: "The number of UDP packets dropped because the backlog was full",
+	})
+
+	// promRateLimitedPackets is a counter of packets dropped because their
+	// source exceeded a rate limit.
+	promRateLimitedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chihaya_udp_rate_limited_packets_total",
+		Help: "The number of UDP packets dropped because their source exceeded a rate limit",
+	}, []string{"action"})
+)
+
 var promResponseDurationMilliseconds = prometheus.NewHistogramVec(
 	prometheus.HistogramOpts{
 		Name:    "chihaya_udp_response_duration_milliseconds",
@@ -0,0 +1,104 @@
+package udp
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiterShards is the number of independently locked parts of a
+// rateLimiter.
+const rateLimiterShards = 64
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiterShard struct {
+	sync.Mutex
+	buckets map[string]*bucket
+}
+
+// rateLimiter is a set of token buckets keyed by source IP.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	shards [rateLimiterShards]rateLimiterShard
+}
+
+// newRateLimiter creates a rateLimiter that refills rate tokens per second up
+// to burst. It returns nil if rate is not positive, which allows everything.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	rl := &rateLimiter{rate: rate, burst: float64(burst)}
+	for i := range rl.shards {
+		rl.shards[i].buckets = make(map[string]*bucket)
+	}
+	return rl
+}
+
+func (rl *rateLimiter) shard(key string) *rateLimiterShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &rl.shards[h.Sum32()%rateLimiterShards]
+}
+
+// allow takes a token from the bucket of ip and reports whether there was one.
+func (rl *rateLimiter) allow(ip net.IP, now time.Time) bool {
+	if rl == nil {
+		return true
+	}
+
+	key := string(ip)
+	s := rl.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		s.buckets[key] = b
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rl.rate
+		if b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// collect removes buckets that would have refilled completely by now.
+func (rl *rateLimiter) collect(now time.Time) {
+	if rl == nil {
+		return
+	}
+
+	// A bucket is full again after this long, so forgetting it changes
+	// nothing.
+	refill := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for i := range rl.shards {
+		s := &rl.shards[i]
+		s.Lock()
+		for key, b := range s.buckets {
+			if now.Sub(b.last) > refill {
+				delete(s.buckets, key)
+			}
+		}
+		s.Unlock()
+	}
+}
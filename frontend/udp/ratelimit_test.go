@@ -0,0 +1,61 @@
+package udp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(1, 2)
+	a, b := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	now := time.Unix(0, 0)
+
+	require.True(t, rl.allow(a, now))
+	require.True(t, rl.allow(a, now))
+	require.False(t, rl.allow(a, now))
+
+	// Other IPs have their own bucket.
+	require.True(t, rl.allow(b, now))
+
+	// Tokens are refilled over time.
+	require.True(t, rl.allow(a, now.Add(time.Second)))
+	require.False(t, rl.allow(a, now.Add(time.Second)))
+
+	rl.collect(now.Add(time.Minute))
+	for i := range rl.shards {
+		require.Empty(t, rl.shards[i].buckets)
+	}
+
+	// A nil limiter allows everything.
+	require.True(t, newRateLimiter(0, 0).allow(a, now))
+}
+
+func TestRateLimitBeforeConnectionID(t *testing.T) {
+	f := &Frontend{
+		genPool: &sync.Pool{
+			New: func() interface{} {
+				return newRotatingGenerator(keyRing{"key", time.Hour})
+			},
+		},
+		announceLimiter: newRateLimiter(0.001, 1),
+	}
+	w := ResponseWriter{socket: &socket{writes: make(chan outgoing, 2)}}
+
+	// An announce with an invalid connection ID.
+	packet := make([]byte, 98)
+	binary.BigEndian.PutUint32(packet[8:12], announceActionID)
+	r := Request{Packet: packet, IP: net.ParseIP("10.0.0.1").To4()}
+
+	_, _, err := f.handleRequest(r, w)
+	require.Equal(t, errBadConnectionID, err)
+
+	// Further packets with invalid connection IDs are limited as well.
+	_, _, err = f.handleRequest(r, w)
+	require.Equal(t, errRateLimited, err)
+	require.Len(t, w.socket.writes, 1)
+}
@@ -0,0 +1,15 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package udp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl fails, because SO_REUSEPORT is not supported on this
+// platform.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("udp: reuse_port is not supported on this platform")
+}
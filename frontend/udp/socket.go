@@ -0,0 +1,220 @@
+package udp
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/chihaya/chihaya/frontend/udp/bytepool"
+	"github.com/chihaya/chihaya/pkg/listenfd"
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+// maxPacketSize is the size of the buffers packets are read into.
+const maxPacketSize = 2048
+
+// batchConn is the subset of ipv4.PacketConn and ipv6.PacketConn used to
+// read and write packets in batches.
+//
+// On Linux, these are implemented using recvmmsg and sendmmsg; elsewhere they
+// fall back to one syscall per packet.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// socket is a bound UDP socket along with the writer that batches responses
+// sent through it.
+type socket struct {
+	conn  *net.UDPConn
+	batch batchConn
+
+	writes chan outgoing
+	done   chan struct{}
+}
+
+// outgoing is a response waiting to be written.
+type outgoing struct {
+	buffer *[]byte
+	addr   *net.UDPAddr
+}
+
+// writeBufferFree holds the buffers of written responses for reuse.
+// Unlike request buffers, they grow to the size of the largest response.
+var writeBufferFree = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, maxPacketSize)
+		return &b
+	},
+}
+
+// listenSocket binds a UDP socket to addr, setting SO_REUSEPORT if reusePort
+// is set, unless an inherited socket is bound to addr already.
+func listenSocket(addr string, reusePort bool) (*net.UDPConn, error) {
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+
+	conn, err := listenfd.ListenPacket("udp", addr, func() (net.PacketConn, error) {
+		return lc.ListenPacket(context.Background(), "udp", addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+func newSocket(conn *net.UDPConn, batchSize int) *socket {
+	s := &socket{
+		conn:   conn,
+		writes: make(chan outgoing, batchSize*4),
+		done:   make(chan struct{}),
+	}
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		s.batch = ipv4.NewPacketConn(conn)
+	} else {
+		s.batch = ipv6.NewPacketConn(conn)
+	}
+
+	return s
+}
+
+// write copies b and queues it to be sent to addr.
+func (s *socket) write(b []byte, addr *net.UDPAddr) {
+	buffer := writeBufferFree.Get().(*[]byte)
+	*buffer = append((*buffer)[:0], b...)
+	s.writes <- outgoing{buffer, addr}
+}
+
+// writeLoop sends queued responses in batches of up to batchSize until the
+// write queue is closed.
+func (s *socket) writeLoop(batchSize int) {
+	defer close(s.done)
+
+	pending := make([]outgoing, 0, batchSize)
+	msgs := make([]ipv4.Message, batchSize)
+	for out := range s.writes {
+		pending = append(pending[:0], out)
+
+		// Collect whatever else is ready without waiting.
+	collect:
+		for len(pending) < batchSize {
+			select {
+			case out, ok := <-s.writes:
+				if !ok {
+					break collect
+				}
+				pending = append(pending, out)
+			default:
+				break collect
+			}
+		}
+
+		for i, out := range pending {
+			msgs[i].Buffers = [][]byte{*out.buffer}
+			msgs[i].Addr = out.addr
+		}
+
+		for sent := 0; sent < len(pending); {
+			n, err := s.batch.WriteBatch(msgs[sent:len(pending)], 0)
+			if n > 0 {
+				sent += n
+			}
+			if err != nil {
+				// The first n packets were sent. Skip the packet that
+				// failed, the client will retry.
+				log.Debug("udp: failed to write response", log.Err(err))
+				sent++
+			}
+		}
+
+		for i, out := range pending {
+			writeBufferFree.Put(out.buffer)
+			msgs[i] = ipv4.Message{}
+		}
+	}
+}
+
+// closeWrites stops the writer after it has sent all queued responses.
+func (s *socket) closeWrites() {
+	close(s.writes)
+	<-s.done
+}
+
+// packet is a request read from a socket.
+type packet struct {
+	buffer *[]byte
+	n      int
+	addr   *net.UDPAddr
+	socket *socket
+}
+
+// readerState holds the buffers a single reader reuses between batches.
+type readerState struct {
+	msgs    []ipv4.Message
+	buffers []*[]byte
+}
+
+func newReaderState(pool *bytepool.BytePool, batchSize int) *readerState {
+	r := &readerState{
+		msgs:    make([]ipv4.Message, batchSize),
+		buffers: make([]*[]byte, batchSize),
+	}
+	for i := range r.msgs {
+		r.buffers[i] = pool.Get()
+		r.msgs[i].Buffers = [][]byte{*r.buffers[i]}
+	}
+	return r
+}
+
+// release returns all buffers of the reader to the pool.
+func (r *readerState) release(pool *bytepool.BytePool) {
+	for _, b := range r.buffers {
+		pool.Put(b)
+	}
+}
+
+// read reads a batch of packets from s and hands them to emit, replacing the
+// buffers that were handed off.
+func (r *readerState) read(pool *bytepool.BytePool, s *socket, emit func(packet)) error {
+	n, err := s.batch.ReadBatch(r.msgs, 0)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		msg := &r.msgs[i]
+		addr, ok := msg.Addr.(*net.UDPAddr)
+		if msg.N == 0 || !ok {
+			// We got nothin'
+			continue
+		}
+
+		emit(packet{buffer: r.buffers[i], n: msg.N, addr: addr, socket: s})
+
+		r.buffers[i] = pool.Get()
+		msg.Buffers[0] = *r.buffers[i]
+	}
+
+	return nil
+}
+
+// socketSet is the set of sockets a Frontend serves on.
+type socketSet []*socket
+
+func (ss socketSet) closeWrites() {
+	var wg sync.WaitGroup
+	for _, s := range ss {
+		wg.Add(1)
+		go func(s *socket) {
+			defer wg.Done()
+			s.closeWrites()
+		}(s)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,50 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+)
+
+// recordingBatchConn records written packets and fails once at the packet
+// failOn, reporting the packets before it in the batch as sent.
+type recordingBatchConn struct {
+	failOn string
+	failed bool
+	sent   []string
+}
+
+func (c *recordingBatchConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (c *recordingBatchConn) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	for i, m := range ms {
+		b := string(m.Buffers[0])
+		if b == c.failOn && !c.failed {
+			c.failed = true
+			return i, errors.New("write failed")
+		}
+		c.sent = append(c.sent, b)
+	}
+	return len(ms), nil
+}
+
+func TestWriteLoopPartialBatch(t *testing.T) {
+	c := &recordingBatchConn{failOn: "c"}
+	s := &socket{batch: c, writes: make(chan outgoing, 4), done: make(chan struct{})}
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881}
+	for _, b := range []string{"a", "b", "c", "d"} {
+		s.write([]byte(b), addr)
+	}
+	go s.writeLoop(4)
+	s.closeWrites()
+
+	// The packets before the failed one are not sent again and the failed
+	// one is skipped.
+	require.Equal(t, []string{"a", "b", "d"}, c.sent)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"time"
 
 	"github.com/chihaya/chihaya/bittorrent"
@@ -29,6 +30,8 @@ func WriteError(w io.Writer, txID []byte, err error) {
 // WriteAnnounce encodes an announce response according to BEP 15.
 // The peers returned will be resp.IPv6Peers or resp.IPv4Peers, depending on
 // whether v6Peers is set.
+// Peers of the other address family cannot be encoded in the fixed-size
+// slots of the packet and are skipped.
 // If v6Action is set, the action will be 4, according to
 // https://web.archive.org/web/20170503181830/http://opentracker.blog.h3q.com/2007/12/28/the-ipv6-situation/
 func WriteAnnounce(w io.Writer, txID []byte, resp *bittorrent.AnnounceResponse, v6Action, v6Peers bool) {
@@ -49,7 +52,17 @@ func WriteAnnounce(w io.Writer, txID []byte, resp *bittorrent.AnnounceResponse,
 	}
 
 	for _, peer := range peers {
-		buf.Write(peer.IP.IP)
+		ip := peer.IP.To4()
+		if v6Peers {
+			if ip != nil || len(peer.IP.IP) != net.IPv6len {
+				continue
+			}
+			ip = peer.IP.IP
+		} else if ip == nil {
+			continue
+		}
+
+		buf.Write(ip)
 		_ = binary.Write(buf, binary.BigEndian, peer.Port)
 	}
 
@@ -0,0 +1,30 @@
+package udp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func TestWriteAnnounceSkipsOtherFamily(t *testing.T) {
+	v4 := bittorrent.Peer{IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}, Port: 6881}
+	v6 := bittorrent.Peer{IP: bittorrent.IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: bittorrent.IPv6}, Port: 6882}
+	resp := &bittorrent.AnnounceResponse{
+		IPv4Peers: []bittorrent.Peer{v4, v6},
+		IPv6Peers: []bittorrent.Peer{v6, v4},
+	}
+	txID := []byte{1, 2, 3, 4}
+
+	// The header is 8 bytes, followed by interval, leechers and seeders.
+	var buf bytes.Buffer
+	WriteAnnounce(&buf, txID, resp, false, false)
+	require.Equal(t, append([]byte(net.ParseIP("192.0.2.1").To4()), 0x1a, 0xe1), buf.Bytes()[20:])
+
+	buf.Reset()
+	WriteAnnounce(&buf, txID, resp, false, true)
+	require.Equal(t, append([]byte(net.ParseIP("2001:db8::1")), 0x1a, 0xe2), buf.Bytes()[20:])
+}
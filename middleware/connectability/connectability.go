@@ -0,0 +1,342 @@
+// Package connectability implements a Hook that checks in the background
+// whether announcing peers accept incoming connections, which clients behind
+// a NAT or firewall often do not.
+//
+// A check dials the announced endpoint and performs the BitTorrent handshake
+// for the infohash of the announce. Results are cached per endpoint. The hook
+// stores the cached result of the announcing peer under
+// middleware.ConnectableKey; peers without one are checked in the background
+// and get a result on one of their next announces.
+package connectability
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/stop"
+)
+
+// Name is the name by which this middleware is registered with Chihaya.
+const Name = "connectability"
+
+// Default config constants.
+const (
+	defaultTimeout   = 5 * time.Second
+	defaultCacheTTL  = 30 * time.Minute
+	defaultRate      = 10
+	defaultWorkers   = 8
+	defaultQueueSize = 1024
+
+	// maxRate is the highest Rate, at which checks are started every
+	// microsecond.
+	maxRate = int(time.Second / time.Microsecond)
+)
+
+func init() {
+	middleware.RegisterDriver(Name, driver{})
+}
+
+var _ middleware.Driver = driver{}
+
+type driver struct{}
+
+func (d driver) NewHook(optionBytes []byte) (middleware.Hook, error) {
+	var cfg Config
+	err := yaml.Unmarshal(optionBytes, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for middleware %s: %w", Name, err)
+	}
+
+	return NewHook(cfg)
+}
+
+// Config represents all the values required by this middleware.
+type Config struct {
+	// Timeout is the time a check may take to connect to a peer and to
+	// complete the handshake.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// CacheTTL is the time the result of a check is used for. Endpoints are
+	// checked again once their result is older than half of it.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// Rate is the maximum number of checks started per second, at most one
+	// million.
+	Rate int `yaml:"rate"`
+
+	// Workers is the number of checks run concurrently.
+	Workers int `yaml:"workers"`
+
+	// QueueSize is the number of endpoints that may wait for a check.
+	// Endpoints that do not fit into the queue are checked on a later
+	// announce.
+	QueueSize int `yaml:"queue_size"`
+
+	// CheckPrivate also checks peers with private IP addresses (see
+	// bittorrent.IP.IsPrivate). They are not checked by default, so that
+	// clients cannot make the tracker connect into its own network.
+	CheckPrivate bool `yaml:"check_private"`
+}
+
+// LogFields renders the current config as a set of Logrus fields.
+func (cfg Config) LogFields() log.Fields {
+	return log.Fields{
+		"name":         Name,
+		"timeout":      cfg.Timeout,
+		"cacheTTL":     cfg.CacheTTL,
+		"rate":         cfg.Rate,
+		"workers":      cfg.Workers,
+		"queueSize":    cfg.QueueSize,
+		"checkPrivate": cfg.CheckPrivate,
+	}
+}
+
+// ErrUnexpectedHandshake is returned by Check for a peer that does not answer
+// with a BitTorrent handshake for the infohash.
+var ErrUnexpectedHandshake = errors.New("unexpected handshake")
+
+// protocol is the start of a BitTorrent handshake.
+const protocol = "\x13BitTorrent protocol"
+
+// Check connects to addr and performs the BitTorrent handshake for an
+// infohash, identifying as peerID. It returns nil if the peer answers with a
+// handshake for the same infohash.
+func Check(ctx context.Context, addr string, ih bittorrent.InfoHash, peerID bittorrent.PeerID) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	// Reserved bytes are left zero, no extensions are used.
+	handshake := make([]byte, 0, 68)
+	handshake = append(handshake, protocol...)
+	handshake = append(handshake, make([]byte, 8)...)
+	handshake = append(handshake, ih[:]...)
+	handshake = append(handshake, peerID[:]...)
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+
+	// The peer ID of the answer is not checked, some clients send a random
+	// one or none at all.
+	answer := make([]byte, 48)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if string(answer[:20]) != protocol || !bytes.Equal(answer[28:48], ih[:]) {
+		return ErrUnexpectedHandshake
+	}
+	return nil
+}
+
+// result is the cached result of a check.
+type result struct {
+	connectable bool
+	checked     time.Time
+}
+
+// task is a pending check.
+type task struct {
+	addr string
+	ih   bittorrent.InfoHash
+}
+
+type hook struct {
+	cfg    Config
+	peerID bittorrent.PeerID
+
+	mu      sync.Mutex
+	results map[string]result
+	pending map[string]struct{}
+
+	queue   chan task
+	ticker  *time.Ticker
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewHook returns an instance of the connectability middleware.
+func NewHook(cfg Config) (middleware.Hook, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultCacheTTL
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = defaultRate
+	}
+	if cfg.Rate > maxRate {
+		cfg.Rate = maxRate
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+
+	h := &hook{
+		cfg:     cfg,
+		results: make(map[string]result),
+		pending: make(map[string]struct{}),
+		queue:   make(chan task, cfg.QueueSize),
+		ticker:  time.NewTicker(time.Second / time.Duration(cfg.Rate)),
+		closing: make(chan struct{}),
+	}
+
+	// The tracker identifies with an Azureus-style peer ID.
+	copy(h.peerID[:], "-CY0000-")
+	if _, err := rand.Read(h.peerID[8:]); err != nil {
+		return nil, err
+	}
+
+	h.wg.Add(cfg.Workers + 1)
+	for i := 0; i < cfg.Workers; i++ {
+		go h.work()
+	}
+	go h.sweep()
+
+	log.Info("connectability middleware enabled", h.cfg)
+	return h, nil
+}
+
+// work runs checks of the queue at the configured rate.
+func (h *hook) work() {
+	defer h.wg.Done()
+	for {
+		select {
+		case <-h.closing:
+			return
+		case t := <-h.queue:
+			select {
+			case <-h.closing:
+				return
+			case <-h.ticker.C:
+			}
+			h.check(t)
+		}
+	}
+}
+
+func (h *hook) check(t task) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+	defer cancel()
+
+	err := Check(ctx, t.addr, t.ih, h.peerID)
+	log.Debug("connectability: checked peer", log.Fields{
+		"addr":        t.addr,
+		"infoHash":    t.ih,
+		"connectable": err == nil,
+		"error":       err,
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.pending, t.addr)
+	h.results[t.addr] = result{connectable: err == nil, checked: time.Now()}
+}
+
+// sweep removes expired results periodically.
+func (h *hook) sweep() {
+	defer h.wg.Done()
+	t := time.NewTicker(h.cfg.CacheTTL)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.closing:
+			return
+		case now := <-t.C:
+			h.mu.Lock()
+			for addr, r := range h.results {
+				if now.Sub(r.checked) >= h.cfg.CacheTTL {
+					delete(h.results, addr)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// lookup returns the result of an endpoint, if it has a current one, and
+// queues a check if it has none or an aging one.
+func (h *hook) lookup(addr string, ih bittorrent.InfoHash) (r result, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok = h.results[addr]
+	age := time.Since(r.checked)
+	if ok && age >= h.cfg.CacheTTL {
+		ok = false
+	}
+	if ok && age < h.cfg.CacheTTL/2 {
+		return r, ok
+	}
+
+	if _, queued := h.pending[addr]; queued {
+		return r, ok
+	}
+	select {
+	case h.queue <- task{addr: addr, ih: ih}:
+		h.pending[addr] = struct{}{}
+	default:
+		log.Debug("connectability: queue full, skipping check", log.Fields{"addr": addr})
+	}
+	return r, ok
+}
+
+func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	if req.Event == bittorrent.Stopped || (!h.cfg.CheckPrivate && req.Peer.IP.IsPrivate()) {
+		return ctx, nil
+	}
+
+	addr := net.JoinHostPort(req.Peer.IP.String(), strconv.Itoa(int(req.Peer.Port)))
+	if r, ok := h.lookup(addr, req.InfoHash); ok {
+		return context.WithValue(ctx, middleware.ConnectableKey, r.connectable), nil
+	}
+	return ctx, nil
+}
+
+func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
+	// Scrapes have no peer to check.
+	return ctx, nil
+}
+
+// Stop stops checking peers.
+func (h *hook) Stop() stop.Result {
+	select {
+	case <-h.closing:
+		return stop.AlreadyStopped
+	default:
+	}
+
+	c := make(stop.Channel)
+	go func() {
+		close(h.closing)
+		h.wg.Wait()
+		h.ticker.Stop()
+		c.Done()
+	}()
+	return c.Result()
+}
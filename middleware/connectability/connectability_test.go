@@ -0,0 +1,136 @@
+package connectability
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+)
+
+var (
+	ih     = bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	peerID = bittorrent.PeerIDFromString("-XX1000-abcdefghijkl")
+)
+
+// listen starts a loopback listener that answers handshakes with one for
+// answerIH and returns its address.
+func listen(t *testing.T, answerIH bittorrent.InfoHash) *net.TCPAddr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handshake := make([]byte, 68)
+				if _, err := io.ReadFull(conn, handshake); err != nil {
+					return
+				}
+				copy(handshake[28:48], answerIH[:])
+				copy(handshake[48:], peerID[:])
+				_, _ = conn.Write(handshake)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+func TestCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := listen(t, ih)
+	require.Nil(t, Check(ctx, addr.String(), ih, peerID))
+
+	// Peers must answer for the same infohash.
+	other := listen(t, bittorrent.InfoHashFromString("bbbbbbbbbbbbbbbbbbbb"))
+	require.Equal(t, ErrUnexpectedHandshake, Check(ctx, other.String(), ih, peerID))
+
+	// Nothing listens on a closed listener.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	require.Nil(t, l.Close())
+	require.NotNil(t, Check(ctx, l.Addr().String(), ih, peerID))
+}
+
+func announce(t *testing.T, h middleware.Hook, addr *net.TCPAddr) (connectable, ok bool) {
+	req := &bittorrent.AnnounceRequest{
+		InfoHash: ih,
+		Peer: bittorrent.Peer{
+			ID:   peerID,
+			IP:   bittorrent.IP{IP: addr.IP.To4(), AddressFamily: bittorrent.IPv4},
+			Port: uint16(addr.Port),
+		},
+	}
+	ctx, err := h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	connectable, ok = ctx.Value(middleware.ConnectableKey).(bool)
+	return connectable, ok
+}
+
+func TestHook(t *testing.T) {
+	h, err := NewHook(Config{Timeout: time.Second, Rate: 100, CheckPrivate: true})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-h.(*hook).Stop()) }()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	require.Nil(t, l.Close())
+
+	for _, tt := range []struct {
+		addr        *net.TCPAddr
+		connectable bool
+	}{
+		{listen(t, ih), true},
+		{l.Addr().(*net.TCPAddr), false},
+	} {
+		// The result is unknown until the background check completes.
+		_, ok := announce(t, h, tt.addr)
+		require.False(t, ok)
+
+		require.Eventually(t, func() bool {
+			_, ok := announce(t, h, tt.addr)
+			return ok
+		}, 5*time.Second, 10*time.Millisecond)
+
+		connectable, _ := announce(t, h, tt.addr)
+		require.Equal(t, tt.connectable, connectable)
+	}
+}
+
+func TestHookMaxRate(t *testing.T) {
+	h, err := NewHook(Config{Rate: math.MaxInt32})
+	require.Nil(t, err)
+	require.Equal(t, maxRate, h.(*hook).cfg.Rate)
+	require.Nil(t, <-h.(*hook).Stop())
+}
+
+func TestHookSkipsPrivatePeers(t *testing.T) {
+	h, err := NewHook(Config{Timeout: time.Second, Rate: 100})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-h.(*hook).Stop()) }()
+
+	addr := listen(t, ih)
+	_, ok := announce(t, h, addr)
+	require.False(t, ok)
+
+	time.Sleep(50 * time.Millisecond)
+	_, ok = announce(t, h, addr)
+	require.False(t, ok)
+
+	h.(*hook).mu.Lock()
+	defer h.(*hook).mu.Unlock()
+	require.Empty(t, h.(*hook).pending)
+}
@@ -0,0 +1,497 @@
+// Package external implements a Hook that delegates policy decisions to an
+// external service.
+//
+// Every Announce and Scrape is sent as a JSON document via HTTP POST to the
+// configured URL, optionally over a Unix domain socket. The service answers
+// with a Decision that allows or rejects the request, may override the
+// announce interval and may attach attributes to the identity of the client.
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/middleware/passkeyapproval"
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+// Name is the name by which this middleware is registered with Chihaya.
+const Name = "external"
+
+// Default config constants.
+const (
+	defaultTimeout      = 100 * time.Millisecond
+	defaultMaxIdleConns = 64
+	maxDecisionSize     = 64 << 10
+)
+
+// Failure policies applied when the service cannot be reached or answers
+// with something that is not a valid Decision.
+const (
+	FailureAllow = "allow"
+	FailureDeny  = "deny"
+)
+
+// Actions a Decision can carry.
+const (
+	ActionAllow  = "allow"
+	ActionReject = "reject"
+)
+
+type attributesKey struct{}
+
+// AttributesKey is the key under which the identity attributes returned by
+// the service are stored in the context.
+var AttributesKey = attributesKey{}
+
+// Attributes are free-form identity attributes of a client.
+type Attributes map[string]string
+
+func init() {
+	middleware.RegisterDriver(Name, driver{})
+}
+
+var _ middleware.Driver = driver{}
+
+type driver struct{}
+
+func (d driver) NewHook(optionBytes []byte) (middleware.Hook, error) {
+	var cfg Config
+	err := yaml.Unmarshal(optionBytes, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for middleware %s: %w", Name, err)
+	}
+
+	return NewHook(cfg)
+}
+
+var (
+	// ErrMissingURL is returned for a config without a URL.
+	ErrMissingURL = errors.New("missing url")
+
+	// ErrInvalidFailurePolicy is returned for a config with an unknown
+	// failure policy.
+	ErrInvalidFailurePolicy = errors.New("invalid failure_policy")
+
+	// ErrPolicyUnavailable is returned to clients if the service could not
+	// be consulted and the failure policy is deny.
+	ErrPolicyUnavailable = bittorrent.ClientError("policy service unavailable")
+
+	// ErrRejected is returned to clients if the service rejected a request
+	// without giving a reason.
+	ErrRejected = bittorrent.ClientError("request rejected")
+)
+
+// Config represents all the values required by this middleware.
+type Config struct {
+	// URL is the endpoint requests are POSTed to.
+	// If SocketPath is set, only the path of the URL is used.
+	URL string `yaml:"url"`
+
+	// SocketPath is the path of a Unix domain socket to connect to instead
+	// of the host in URL.
+	SocketPath string `yaml:"socket_path"`
+
+	// Timeout is the deadline for a single decision, including connecting.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// FailurePolicy decides what happens to a request if no decision could
+	// be obtained: "allow" (the default) or "deny".
+	FailurePolicy string `yaml:"failure_policy"`
+
+	// MaxIdleConns is the number of idle connections kept open to the
+	// service.
+	MaxIdleConns int `yaml:"max_idle_conns"`
+
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string `yaml:"headers"`
+
+	// Scrape specifies whether scrapes are sent to the service as well.
+	Scrape bool `yaml:"scrape"`
+}
+
+// LogFields renders the current config as a set of Logrus fields.
+func (cfg Config) LogFields() log.Fields {
+	return log.Fields{
+		"name":          Name,
+		"url":           cfg.URL,
+		"socketPath":    cfg.SocketPath,
+		"timeout":       cfg.Timeout,
+		"failurePolicy": cfg.FailurePolicy,
+		"maxIdleConns":  cfg.MaxIdleConns,
+		"scrape":        cfg.Scrape,
+	}
+}
+
+// Validate sanity checks values set in a config and returns a new config with
+// default values replacing anything that is invalid.
+//
+// This function warns to the logger when a value is changed.
+func (cfg Config) Validate() (Config, error) {
+	validcfg := cfg
+
+	if cfg.URL == "" {
+		return cfg, ErrMissingURL
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return cfg, fmt.Errorf("invalid url: %w", err)
+	}
+
+	switch cfg.FailurePolicy {
+	case "":
+		validcfg.FailurePolicy = FailureAllow
+	case FailureAllow, FailureDeny:
+	default:
+		return cfg, ErrInvalidFailurePolicy
+	}
+
+	if cfg.Timeout <= 0 {
+		validcfg.Timeout = defaultTimeout
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     Name + ".Timeout",
+			"provided": cfg.Timeout,
+			"default":  validcfg.Timeout,
+		})
+	}
+
+	if cfg.MaxIdleConns <= 0 {
+		validcfg.MaxIdleConns = defaultMaxIdleConns
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     Name + ".MaxIdleConns",
+			"provided": cfg.MaxIdleConns,
+			"default":  validcfg.MaxIdleConns,
+		})
+	}
+
+	return validcfg, nil
+}
+
+// Identity describes who sent a request, as far as the tracker knows.
+type Identity struct {
+	Passkey     string            `json:"passkey,omitempty"`
+	UserID      string            `json:"user_id,omitempty"`
+	RouteParams map[string]string `json:"route_params,omitempty"`
+	Attributes  Attributes        `json:"attributes,omitempty"`
+}
+
+// AnnounceQuery is the document sent to the service for an Announce.
+type AnnounceQuery struct {
+	Type          string            `json:"type"`
+	InfoHash      string            `json:"info_hash"`
+	PeerID        string            `json:"peer_id"`
+	IP            string            `json:"ip"`
+	AddressFamily string            `json:"address_family"`
+	Port          uint16            `json:"port"`
+	Event         string            `json:"event"`
+	Left          uint64            `json:"left"`
+	Downloaded    uint64            `json:"downloaded"`
+	Uploaded      uint64            `json:"uploaded"`
+	NumWant       uint32            `json:"numwant"`
+	Params        map[string]string `json:"params,omitempty"`
+	Identity      Identity          `json:"identity"`
+}
+
+// ScrapeQuery is the document sent to the service for a Scrape.
+type ScrapeQuery struct {
+	Type          string            `json:"type"`
+	InfoHashes    []string          `json:"info_hashes"`
+	AddressFamily string            `json:"address_family"`
+	Params        map[string]string `json:"params,omitempty"`
+	Identity      Identity          `json:"identity"`
+}
+
+// Decision is the answer of the service.
+type Decision struct {
+	// Action is either "allow" or "reject".
+	Action string `json:"action"`
+
+	// Reason is the failure reason sent to the client on rejection.
+	Reason string `json:"reason,omitempty"`
+
+	// Code is a machine-readable code and RetryIn the number of seconds to
+	// wait before retrying, sent to the client on rejection. A RetryIn of -1
+	// tells the client not to retry.
+	Code    string `json:"code,omitempty"`
+	RetryIn *int64 `json:"retry_in,omitempty"`
+
+	// Interval and MinInterval override the announce intervals, in seconds.
+	Interval    *uint32 `json:"interval,omitempty"`
+	MinInterval *uint32 `json:"min_interval,omitempty"`
+
+	// Warning is sent to the client as warning message of an allowed
+	// announce.
+	Warning string `json:"warning,omitempty"`
+
+	// Attributes are merged into the identity attributes of the client.
+	Attributes Attributes `json:"attributes,omitempty"`
+
+	// UserID is the ID of the user of the client, stored with its peer.
+	UserID string `json:"user_id,omitempty"`
+}
+
+type hook struct {
+	cfg    Config
+	client *http.Client
+	url    string
+}
+
+// NewHook returns an instance of the external policy middleware.
+func NewHook(provided Config) (middleware.Hook, error) {
+	cfg, err := provided.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	endpoint := cfg.URL
+	if cfg.SocketPath != "" {
+		var d net.Dialer
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", cfg.SocketPath)
+		}
+
+		// The host is irrelevant when dialing a socket, but required by
+		// net/http.
+		u, _ := url.Parse(cfg.URL)
+		u.Scheme, u.Host = "http", "unix"
+		endpoint = u.String()
+	}
+
+	h := &hook{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		url:    endpoint,
+	}
+	log.Info("external policy middleware enabled", cfg)
+	return h, nil
+}
+
+func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	q := AnnounceQuery{
+		Type:          "announce",
+		InfoHash:      req.InfoHash.String(),
+		PeerID:        req.Peer.ID.String(),
+		IP:            req.Peer.IP.String(),
+		AddressFamily: req.Peer.IP.AddressFamily.String(),
+		Port:          req.Peer.Port,
+		Event:         req.Event.String(),
+		Left:          req.Left,
+		Downloaded:    req.Downloaded,
+		Uploaded:      req.Uploaded,
+		NumWant:       req.NumWant,
+		Params:        params(req.Params),
+		Identity:      identity(ctx),
+	}
+
+	d, err := h.decide(ctx, q)
+	if err != nil {
+		return ctx, err
+	}
+
+	if d.Interval != nil {
+		resp.Interval = time.Duration(*d.Interval) * time.Second
+	}
+	if d.MinInterval != nil {
+		resp.MinInterval = time.Duration(*d.MinInterval) * time.Second
+	}
+	if d.Warning != "" {
+		resp.WarningMessage = d.Warning
+	}
+	if d.UserID != "" {
+		ctx = context.WithValue(ctx, middleware.UserIDKey, d.UserID)
+	}
+
+	return withAttributes(ctx, d.Attributes), nil
+}
+
+func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
+	if !h.cfg.Scrape {
+		return ctx, nil
+	}
+
+	infoHashes := make([]string, 0, len(req.InfoHashes))
+	for _, ih := range req.InfoHashes {
+		infoHashes = append(infoHashes, ih.String())
+	}
+
+	q := ScrapeQuery{
+		Type:          "scrape",
+		InfoHashes:    infoHashes,
+		AddressFamily: req.AddressFamily.String(),
+		Params:        params(req.Params),
+		Identity:      identity(ctx),
+	}
+
+	d, err := h.decide(ctx, q)
+	if err != nil {
+		return ctx, err
+	}
+
+	return withAttributes(ctx, d.Attributes), nil
+}
+
+// decide consults the service and turns its Decision, or the failure policy,
+// into an error for the client.
+func (h *hook) decide(ctx context.Context, query interface{}) (Decision, error) {
+	d, err := h.query(ctx, query)
+	if err != nil {
+		log.Warn("external policy: no decision", log.Fields{
+			"url":           h.cfg.URL,
+			"failurePolicy": h.cfg.FailurePolicy,
+			"error":         err,
+		})
+		if h.cfg.FailurePolicy == FailureDeny {
+			return Decision{}, ErrPolicyUnavailable
+		}
+		return Decision{Action: ActionAllow}, nil
+	}
+
+	if d.Action == ActionReject {
+		return d, d.err()
+	}
+
+	return d, nil
+}
+
+// err returns the error a rejection is reported to the client with.
+func (d Decision) err() error {
+	clientErr := ErrRejected
+	if d.Reason != "" {
+		clientErr = bittorrent.ClientError(d.Reason)
+	}
+	if d.Code == "" && d.RetryIn == nil {
+		return clientErr
+	}
+
+	detailed := clientErr.WithCode(d.Code)
+	if d.RetryIn != nil {
+		if *d.RetryIn < 0 {
+			detailed.RetryIn = bittorrent.RetryNever
+		} else {
+			detailed.RetryIn = time.Duration(*d.RetryIn) * time.Second
+		}
+	}
+	return detailed
+}
+
+func (h *hook) query(ctx context.Context, query interface{}) (Decision, error) {
+	var d Decision
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return d, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return d, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range h.cfg.Headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(r)
+	if err != nil {
+		return d, err
+	}
+	defer func() {
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDecisionSize))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		return d, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDecisionSize)).Decode(&d); err != nil {
+		return d, fmt.Errorf("invalid decision: %w", err)
+	}
+
+	switch d.Action {
+	case ActionAllow, ActionReject:
+	default:
+		return d, fmt.Errorf("invalid decision: unknown action %q", d.Action)
+	}
+
+	return d, nil
+}
+
+func params(p bittorrent.Params) map[string]string {
+	if p == nil {
+		return nil
+	}
+
+	values, err := url.ParseQuery(p.RawQuery())
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			m[k] = v[len(v)-1]
+		}
+	}
+	return m
+}
+
+func identity(ctx context.Context) Identity {
+	var id Identity
+
+	if rp, ok := ctx.Value(bittorrent.RouteParamsKey).(bittorrent.RouteParams); ok && len(rp) > 0 {
+		id.RouteParams = make(map[string]string, len(rp))
+		for _, p := range rp {
+			id.RouteParams[p.Key] = p.Value
+		}
+	}
+
+	if payload, ok := ctx.Value(passkeyapproval.PasskeyPayloadKey).(*passkeyapproval.Payload); ok && payload != nil {
+		id.Passkey = payload.Passkey
+	}
+
+	id.UserID, _ = ctx.Value(middleware.UserIDKey).(string)
+	id.Attributes, _ = ctx.Value(AttributesKey).(Attributes)
+
+	return id
+}
+
+// withAttributes merges attrs into the identity attributes stored in ctx.
+func withAttributes(ctx context.Context, attrs Attributes) context.Context {
+	if len(attrs) == 0 {
+		return ctx
+	}
+
+	existing, _ := ctx.Value(AttributesKey).(Attributes)
+	merged := make(Attributes, len(existing)+len(attrs))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range attrs {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, AttributesKey, merged)
+}
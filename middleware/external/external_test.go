@@ -0,0 +1,114 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+)
+
+func policyHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var q AnnounceQuery
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&q))
+
+		interval := uint32(1800)
+		retryIn := int64(3600)
+		var d Decision
+		switch q.PeerID {
+		case bittorrent.PeerIDFromString("-XX0001-000000000000").String():
+			d = Decision{Action: ActionReject, Reason: "banned client"}
+		case bittorrent.PeerIDFromString("-XX0002-000000000000").String():
+			time.Sleep(100 * time.Millisecond)
+			d = Decision{Action: ActionAllow}
+		case bittorrent.PeerIDFromString("-XX0003-000000000000").String():
+			d = Decision{Action: ActionReject, Reason: "banned user", Code: "banned", RetryIn: &retryIn}
+		default:
+			d = Decision{Action: ActionAllow, Interval: &interval, Warning: "client will be banned", Attributes: Attributes{"class": "vip"}, UserID: "42"}
+		}
+		require.Nil(t, json.NewEncoder(w).Encode(d))
+	}
+}
+
+func announce(t *testing.T, h interface {
+	HandleAnnounce(context.Context, *bittorrent.AnnounceRequest, *bittorrent.AnnounceResponse) (context.Context, error)
+}, peerID string) (context.Context, *bittorrent.AnnounceResponse, error) {
+	req := &bittorrent.AnnounceRequest{
+		InfoHash: bittorrent.InfoHashFromString("00000000000000000001"),
+		Peer:     bittorrent.Peer{ID: bittorrent.PeerIDFromString(peerID)},
+	}
+	resp := &bittorrent.AnnounceResponse{Interval: time.Minute}
+	ctx, err := h.HandleAnnounce(context.Background(), req, resp)
+	return ctx, resp, err
+}
+
+func TestHandleAnnounce(t *testing.T) {
+	srv := httptest.NewServer(policyHandler(t))
+	defer srv.Close()
+
+	h, err := NewHook(Config{URL: srv.URL, Timeout: 50 * time.Millisecond})
+	require.Nil(t, err)
+
+	ctx, resp, err := announce(t, h, "-TR2940-000000000000")
+	require.Nil(t, err)
+	require.Equal(t, 30*time.Minute, resp.Interval)
+	require.Equal(t, "client will be banned", resp.WarningMessage)
+	require.Equal(t, Attributes{"class": "vip"}, ctx.Value(AttributesKey))
+	require.Equal(t, "42", ctx.Value(middleware.UserIDKey))
+
+	_, _, err = announce(t, h, "-XX0001-000000000000")
+	require.Equal(t, bittorrent.ClientError("banned client"), err)
+
+	_, _, err = announce(t, h, "-XX0003-000000000000")
+	require.Equal(t, bittorrent.ClientError("banned user").WithCode("banned").WithRetryIn(time.Hour), err)
+
+	// The deadline is exceeded, so the default failure policy allows.
+	_, resp, err = announce(t, h, "-XX0002-000000000000")
+	require.Nil(t, err)
+	require.Equal(t, time.Minute, resp.Interval)
+}
+
+func TestFailurePolicyDeny(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	h, err := NewHook(Config{URL: srv.URL, FailurePolicy: FailureDeny})
+	require.Nil(t, err)
+
+	_, _, err = announce(t, h, "-TR2940-000000000000")
+	require.Equal(t, ErrPolicyUnavailable, err)
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.sock")
+	l, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: policyHandler(t)}}
+	srv.Start()
+	defer srv.Close()
+
+	h, err := NewHook(Config{URL: "http://policy/decide", SocketPath: path})
+	require.Nil(t, err)
+
+	_, _, err = announce(t, h, "-XX0001-000000000000")
+	require.Equal(t, bittorrent.ClientError("banned client"), err)
+}
+
+func TestValidate(t *testing.T) {
+	_, err := NewHook(Config{})
+	require.Equal(t, ErrMissingURL, err)
+
+	_, err = NewHook(Config{URL: "http://localhost", FailurePolicy: "maybe"})
+	require.Equal(t, ErrInvalidFailurePolicy, err)
+}
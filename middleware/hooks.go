@@ -3,6 +3,8 @@ package middleware
 import (
 	"context"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/storage"
@@ -26,6 +28,53 @@ type skipSwarmInteraction struct{}
 // middleware to skip.
 var SkipSwarmInteractionKey = skipSwarmInteraction{}
 
+type userID struct{}
+
+// UserIDKey is a key for the context of an Announce under which middleware
+// that identifies users stores the ID of the user of the announcing client.
+// The value is expected to be of type string.
+// It is stored with the peer by peer stores that implement
+// storage.PeerMetadataStore.
+var UserIDKey = userID{}
+
+type connectable struct{}
+
+// ConnectableKey is a key for the context of an Announce under which
+// middleware that checks the connectability of peers stores whether the
+// announcing peer accepts incoming connections.
+// The value is expected to be of type bool and is missing if it is unknown.
+// It is stored with the peer by peer stores that implement
+// storage.PeerMetadataStore.
+var ConnectableKey = connectable{}
+
+type infoHashAliases struct{}
+
+// InfoHashAliasesKey is a key for the context of an Announce or Scrape under
+// which the infohashes of swarms known under multiple infohashes are stored,
+// such as the v1 and truncated v2 infohashes of hybrid torrents.
+// The value is expected to be of type map[bittorrent.InfoHash][]bittorrent.InfoHash,
+// mapping every infohash of a swarm to all infohashes of that swarm, the
+// canonical one first.
+var InfoHashAliasesKey = infoHashAliases{}
+
+// SwarmInfoHashes returns all infohashes of the swarm of an infohash as stored
+// under InfoHashAliasesKey, the canonical one first.
+// An infohash without aliases is the only infohash of its swarm.
+func SwarmInfoHashes(ctx context.Context, ih bittorrent.InfoHash) []bittorrent.InfoHash {
+	if aliases, ok := ctx.Value(InfoHashAliasesKey).(map[bittorrent.InfoHash][]bittorrent.InfoHash); ok {
+		if hashes := aliases[ih]; len(hashes) > 0 {
+			return hashes
+		}
+	}
+	return []bittorrent.InfoHash{ih}
+}
+
+// swarmInfoHash returns the canonical infohash of the swarm of an infohash,
+// under which the swarm is stored.
+func swarmInfoHash(ctx context.Context, ih bittorrent.InfoHash) bittorrent.InfoHash {
+	return SwarmInfoHashes(ctx, ih)[0]
+}
+
 type swarmInteractionHook struct {
 	store storage.PeerStore
 }
@@ -35,36 +84,241 @@ func (h *swarmInteractionHook) HandleAnnounce(ctx context.Context, req *bittorre
 		return ctx, nil
 	}
 
-	switch {
-	case req.Event == bittorrent.Stopped:
-		err = h.store.DeleteSeeder(req.InfoHash, req.Peer)
-		if err != nil && !errors.Is(err, storage.ErrResourceDoesNotExist) {
-			return ctx, err
+	// Dual-stack clients are put into the swarms of all their address
+	// families. All endpoints are updated even if one of them fails, so that
+	// they do not end up in different states.
+	ih := swarmInfoHash(ctx, req.InfoHash)
+	for _, p := range req.Peers() {
+		if perr := h.handlePeer(req, ih, p); perr != nil {
+			if err == nil {
+				err = perr
+			}
+			continue
 		}
-
-		err = h.store.DeleteLeecher(req.InfoHash, req.Peer)
-		if err != nil && !errors.Is(err, storage.ErrResourceDoesNotExist) {
-			return ctx, err
+		if perr := h.putMetadata(ctx, req, ih, p); perr != nil && err == nil {
+			err = perr
 		}
+	}
+
+	return ctx, err
+}
+
+// putMetadata stores the metadata of a peer, if the store supports it.
+func (h *swarmInteractionHook) putMetadata(ctx context.Context, req *bittorrent.AnnounceRequest, ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	ms, ok := h.store.(storage.PeerMetadataStore)
+	if !ok || req.Event == bittorrent.Stopped {
+		return nil
+	}
+
+	err := ms.PutPeerMetadata(ih, p, peerMetadata(ctx, req, p))
+	if err != nil && !errors.Is(err, storage.ErrResourceDoesNotExist) {
+		return err
+	}
+	return nil
+}
+
+// peerMetadata returns the metadata of a peer of an announce.
+func peerMetadata(ctx context.Context, req *bittorrent.AnnounceRequest, p bittorrent.Peer) storage.PeerMetadata {
+	userID, _ := ctx.Value(UserIDKey).(string)
+	md := storage.PeerMetadata{
+		ClientID:   bittorrent.NewClientID(p.ID),
+		UserID:     userID,
+		Left:       req.Left,
+		Uploaded:   req.Uploaded,
+		Downloaded: req.Downloaded,
+		SourceIP:   req.SourceIP,
+		FirstSeen:  time.Now(),
+	}
+
+	// Only the endpoint of Peer is checked, not the additional ones.
+	if p.EqualEndpoint(req.Peer) {
+		md.Connectable, md.ConnectableKnown = ctx.Value(ConnectableKey).(bool)
+	}
+	return md
+}
+
+func (h *swarmInteractionHook) handlePeer(req *bittorrent.AnnounceRequest, ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	switch {
+	case req.Event == bittorrent.Stopped:
+		return deletePeer(h.store, ih, p)
 	case req.Event == bittorrent.Completed:
-		err = h.store.GraduateLeecher(req.InfoHash, req.Peer)
-		return ctx, err
+		return h.store.GraduateLeecher(ih, p)
 	case req.Left == 0:
 		// Completed events will also have Left == 0, but by making this
 		// an extra case we can treat "old" seeders differently from
 		// graduating leechers. (Calling PutSeeder is probably faster
 		// than calling GraduateLeecher.)
-		err = h.store.PutSeeder(req.InfoHash, req.Peer)
-		return ctx, err
+		return h.store.PutSeeder(ih, p)
+	case req.Event == bittorrent.Paused:
+		// Partial seeds (BEP 21) announce paused as long as they do not
+		// download.
+		return h.store.PutPartialSeed(ih, p)
 	default:
-		err = h.store.PutLeecher(req.InfoHash, req.Peer)
-		return ctx, err
+		return h.store.PutLeecher(ih, p)
 	}
+}
 
+func (h *swarmInteractionHook) HandleScrape(ctx context.Context, _ *bittorrent.ScrapeRequest, _ *bittorrent.ScrapeResponse) (context.Context, error) {
+	// Scrapes have no effect on the swarm.
 	return ctx, nil
 }
 
-func (h *swarmInteractionHook) HandleScrape(ctx context.Context, _ *bittorrent.ScrapeRequest, _ *bittorrent.ScrapeResponse) (context.Context, error) {
+// additionalPeersHook drops the AdditionalPeers of an announce that are not
+// verified by the frontend, unless the client announced from their address
+// with the same key (BEP 3) before. Keys are only stored with
+// EnforcePeerKeys.
+type additionalPeersHook struct {
+	store storage.PeerStore
+}
+
+func (h *additionalPeersHook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	if req.AdditionalPeersVerified || len(req.AdditionalPeers) == 0 {
+		return ctx, nil
+	}
+
+	ih := swarmInfoHash(ctx, req.InfoHash)
+	verified := req.AdditionalPeers[:0]
+	for _, p := range req.AdditionalPeers {
+		ok, err := h.observed(req, ih, p)
+		if err != nil {
+			return ctx, err
+		}
+		if ok {
+			verified = append(verified, p)
+		}
+	}
+	req.AdditionalPeers = verified
+
+	return ctx, nil
+}
+
+// observed reports whether the peer ID of an announce is stored with the key
+// of the announce and the IP address of p.
+func (h *additionalPeersHook) observed(req *bittorrent.AnnounceRequest, ih bittorrent.InfoHash, p bittorrent.Peer) (bool, error) {
+	ks, ok := h.store.(storage.PeerKeyStore)
+	if !ok || req.Key == "" {
+		return false, nil
+	}
+
+	key, stored, err := ks.PeerKey(ih, p)
+	if errors.Is(err, storage.ErrResourceDoesNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return sameKey(key, req.Key) && stored.IP.Equal(p.IP.IP), nil
+}
+
+func (h *additionalPeersHook) HandleScrape(ctx context.Context, _ *bittorrent.ScrapeRequest, _ *bittorrent.ScrapeResponse) (context.Context, error) {
+	// Scrapes have no peers.
+	return ctx, nil
+}
+
+// sameKey reports whether two keys (BEP 3) are the same. Keys are compared
+// case-insensitively, because the UDP frontend formats them as lower case hex
+// while clients send them in either case over HTTP.
+func sameKey(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// ErrInvalidPeerKey is returned for an announce whose key does not match the
+// key its peer announced with before.
+var ErrInvalidPeerKey = bittorrent.ClientError("invalid key")
+
+// peerKeyHook protects peers against announces of other clients using their
+// peer ID, such as stops removing them from a swarm.
+//
+// The first announce of a peer ID binds it to the endpoint it came from and
+// its key (BEP 3), if any, until the peer stops or expires. Later announces of
+// a peer with a key must send the same key, but may change the IP address or
+// port of the peer, which removes the previous endpoint from the swarm.
+// Peers without a key are not protected, but announces from other endpoints
+// cannot bind a key to their peer ID either.
+type peerKeyHook struct {
+	store storage.PeerKeyStore
+}
+
+// storedKey is a key stored with the peer it was bound to.
+type storedKey struct {
+	key  string
+	peer bittorrent.Peer
+}
+
+func (h *peerKeyHook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	if ctx.Value(SkipSwarmInteractionKey) != nil {
+		return ctx, nil
+	}
+
+	// All endpoints are checked before any of them is updated.
+	ih := swarmInfoHash(ctx, req.InfoHash)
+	peers := req.Peers()
+	stored := make([]*storedKey, len(peers))
+	for i, p := range peers {
+		key, s, err := h.store.PeerKey(ih, p)
+		if errors.Is(err, storage.ErrResourceDoesNotExist) {
+			continue
+		} else if err != nil {
+			return ctx, err
+		}
+		if key != "" && !sameKey(key, req.Key) {
+			return ctx, ErrInvalidPeerKey
+		}
+		stored[i] = &storedKey{key: key, peer: s}
+	}
+
+	for i, p := range peers {
+		if err := h.updatePeer(req, ih, p, stored[i]); err != nil {
+			return ctx, err
+		}
+	}
+
+	return ctx, nil
+}
+
+// updatePeer updates the key of a peer, given the key stored for its peer ID,
+// if any.
+func (h *peerKeyHook) updatePeer(req *bittorrent.AnnounceRequest, ih bittorrent.InfoHash, p bittorrent.Peer, stored *storedKey) error {
+	switch {
+	case stored == nil && req.Event == bittorrent.Stopped:
+		return nil
+	case stored == nil:
+		return h.store.PutPeerKey(ih, p, req.Key)
+	case stored.key == "" && !stored.peer.EqualEndpoint(p):
+		// Only the endpoint that first used the peer ID may bind a key
+		// to it.
+		return nil
+	case req.Event == bittorrent.Stopped:
+		err := h.store.DeletePeerKey(ih, p)
+		if err != nil && !errors.Is(err, storage.ErrResourceDoesNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if !stored.peer.EqualEndpoint(p) {
+		if err := deletePeer(h.store, ih, stored.peer); err != nil {
+			return err
+		}
+	}
+	return h.store.PutPeerKey(ih, p, req.Key)
+}
+
+// deletePeer removes a peer from a swarm, whether it is a seeder, leecher or
+// partial seed.
+func deletePeer(store storage.PeerStore, ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	for _, del := range []func(bittorrent.InfoHash, bittorrent.Peer) error{
+		store.DeleteSeeder,
+		store.DeleteLeecher,
+		store.DeletePartialSeed,
+	} {
+		if err := del(ih, p); err != nil && !errors.Is(err, storage.ErrResourceDoesNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *peerKeyHook) HandleScrape(ctx context.Context, _ *bittorrent.ScrapeRequest, _ *bittorrent.ScrapeResponse) (context.Context, error) {
 	// Scrapes have no effect on the swarm.
 	return ctx, nil
 }
@@ -96,25 +350,42 @@ func (h *responseHook) HandleAnnounce(ctx context.Context, req *bittorrent.Annou
 	}
 
 	// Add the Scrape data to the response.
-	s := h.store.ScrapeSwarm(req.InfoHash, req.IP.AddressFamily)
+	ih := swarmInfoHash(ctx, req.InfoHash)
+	s := h.store.ScrapeSwarm(ih, req.IP.AddressFamily)
 	resp.Incomplete = s.Incomplete
 	resp.Complete = s.Complete
 
-	err = h.appendPeers(req, resp)
+	err = h.appendPeers(ctx, req, ih, resp)
 	return ctx, err
 }
 
-func (h *responseHook) appendPeers(req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) error {
-	seeding := req.Left == 0
-	peers, err := h.store.AnnouncePeers(req.InfoHash, seeding, int(req.NumWant), req.Peer)
+func (h *responseHook) appendPeers(ctx context.Context, req *bittorrent.AnnounceRequest, ih bittorrent.InfoHash, resp *bittorrent.AnnounceResponse) error {
+	// Partial seeds only upload, so they get the same peers as seeders.
+	partialSeed := req.Left != 0 && req.Event == bittorrent.Paused
+	seeding := req.Left == 0 || partialSeed
+
+	var peers []bittorrent.Peer
+	var err error
+	if ms, ok := h.store.(storage.PeerMetadataStore); ok {
+		// The stored metadata of the announcer is missing on its first
+		// announce and may be outdated.
+		peers, err = ms.AnnouncePeersWithMetadata(ih, seeding, int(req.NumWant), req.Peer, peerMetadata(ctx, req, req.Peer))
+	} else {
+		peers, err = h.store.AnnouncePeers(ih, seeding, int(req.NumWant), req.Peer)
+	}
 	if err != nil && !errors.Is(err, storage.ErrResourceDoesNotExist) {
 		return err
 	}
 
+	// The other endpoints of a dual-stack announcer are not useful to it.
+	if len(req.AdditionalPeers) > 0 {
+		peers = withoutPeerID(peers, req.Peer.ID)
+	}
+
 	// Some clients expect a minimum of their own peer representation returned to
 	// them if they are the only peer in a swarm.
 	if len(peers) == 0 {
-		if seeding {
+		if seeding && !partialSeed {
 			resp.Complete++
 		} else {
 			resp.Incomplete++
@@ -122,25 +393,41 @@ func (h *responseHook) appendPeers(req *bittorrent.AnnounceRequest, resp *bittor
 		peers = append(peers, req.Peer)
 	}
 
-	switch req.IP.AddressFamily {
-	case bittorrent.IPv4:
-		resp.IPv4Peers = peers
-	case bittorrent.IPv6:
-		resp.IPv6Peers = peers
-	default:
-		panic("attempted to append peer that is neither IPv4 nor IPv6")
+	// Stores with dual-stack peers return peers of both families, so they
+	// are split by their own family rather than the one of the announcer.
+	for _, p := range peers {
+		if p.IP.To4() != nil {
+			resp.IPv4Peers = append(resp.IPv4Peers, p)
+		} else {
+			resp.IPv6Peers = append(resp.IPv6Peers, p)
+		}
 	}
 
 	return nil
 }
 
+// withoutPeerID removes the peers with the given ID from peers in place.
+func withoutPeerID(peers []bittorrent.Peer, id bittorrent.PeerID) []bittorrent.Peer {
+	filtered := peers[:0]
+	for _, p := range peers {
+		if p.ID != id {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
 func (h *responseHook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
 	if ctx.Value(SkipResponseHookKey) != nil {
 		return ctx, nil
 	}
 
+	// Swarms with aliases are scraped under their canonical infohash, but
+	// reported under the requested one.
 	for _, infoHash := range req.InfoHashes {
-		resp.Files = append(resp.Files, h.store.ScrapeSwarm(infoHash, req.AddressFamily))
+		s := h.store.ScrapeSwarm(swarmInfoHash(ctx, infoHash), req.AddressFamily)
+		s.InfoHash = infoHash
+		resp.Files = append(resp.Files, s)
 	}
 
 	return ctx, nil
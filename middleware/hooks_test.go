@@ -0,0 +1,380 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/storage"
+	"github.com/chihaya/chihaya/storage/memory"
+)
+
+// recordingStore is a PeerStore that records the peers of the swarm
+// interactions.
+type recordingStore struct {
+	storage.PeerStore
+	leechers     map[string]bool
+	partialSeeds map[string]bool
+	peers        []bittorrent.Peer
+	swarms       []bittorrent.InfoHash
+
+	// failing is the peer the store fails to put, if any.
+	failing string
+}
+
+func (s *recordingStore) ScrapeSwarm(ih bittorrent.InfoHash, _ bittorrent.AddressFamily) bittorrent.Scrape {
+	s.swarms = append(s.swarms, ih)
+	return bittorrent.Scrape{InfoHash: ih, Complete: 1}
+}
+
+func (s *recordingStore) AnnouncePeers(bittorrent.InfoHash, bool, int, bittorrent.Peer) ([]bittorrent.Peer, error) {
+	return append([]bittorrent.Peer{}, s.peers...), nil
+}
+
+func (s *recordingStore) PutLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	if p.String() == s.failing {
+		return errors.New("failed to put leecher")
+	}
+	s.swarms = append(s.swarms, ih)
+	s.leechers[p.String()] = true
+	return nil
+}
+
+func (s *recordingStore) DeleteSeeder(bittorrent.InfoHash, bittorrent.Peer) error {
+	return storage.ErrResourceDoesNotExist
+}
+
+func (s *recordingStore) DeleteLeecher(_ bittorrent.InfoHash, p bittorrent.Peer) error {
+	delete(s.leechers, p.String())
+	return nil
+}
+
+func (s *recordingStore) PutPartialSeed(_ bittorrent.InfoHash, p bittorrent.Peer) error {
+	s.partialSeeds[p.String()] = true
+	return nil
+}
+
+func (s *recordingStore) DeletePartialSeed(_ bittorrent.InfoHash, p bittorrent.Peer) error {
+	if !s.partialSeeds[p.String()] {
+		return storage.ErrResourceDoesNotExist
+	}
+	delete(s.partialSeeds, p.String())
+	return nil
+}
+
+func TestSwarmInteractionDualStack(t *testing.T) {
+	ps := &recordingStore{leechers: make(map[string]bool), partialSeeds: make(map[string]bool)}
+	h := &swarmInteractionHook{store: ps}
+
+	id := bittorrent.PeerIDFromString("-XX1000-abcdefghijkl")
+	v4 := bittorrent.Peer{ID: id, IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}, Port: 6881}
+	v6 := bittorrent.Peer{ID: id, IP: bittorrent.IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: bittorrent.IPv6}, Port: 6881}
+	req := &bittorrent.AnnounceRequest{
+		InfoHash:        bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa"),
+		Left:            1,
+		Peer:            v4,
+		AdditionalPeers: []bittorrent.Peer{v6},
+	}
+
+	_, err := h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Equal(t, map[string]bool{v4.String(): true, v6.String(): true}, ps.leechers)
+
+	req.Event = bittorrent.Stopped
+	_, err = h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Empty(t, ps.leechers)
+
+	// A failing endpoint does not keep the others from being updated.
+	ps.failing = v4.String()
+	req.Event = bittorrent.Started
+	_, err = h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.NotNil(t, err)
+	require.Equal(t, map[string]bool{v6.String(): true}, ps.leechers)
+}
+
+func TestAdditionalPeersHook(t *testing.T) {
+	ps, err := memory.New(memory.Config{})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+	h := &additionalPeersHook{store: ps}
+	ks := ps.(storage.PeerKeyStore)
+
+	ih := bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	id := bittorrent.PeerIDFromString("-XX1000-abcdefghijkl")
+	v4 := bittorrent.Peer{ID: id, IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}, Port: 6881}
+	v6 := bittorrent.Peer{ID: id, IP: bittorrent.IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: bittorrent.IPv6}, Port: 6881}
+	announce := func(key string, verified bool) []bittorrent.Peer {
+		req := &bittorrent.AnnounceRequest{
+			InfoHash:                ih,
+			Key:                     key,
+			Peer:                    v4,
+			AdditionalPeers:         []bittorrent.Peer{v6},
+			AdditionalPeersVerified: verified,
+		}
+		_, err := h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+		require.Nil(t, err)
+		return req.AdditionalPeers
+	}
+
+	// Addresses the client never announced from are dropped, unless the
+	// frontend verified them.
+	require.Empty(t, announce("secret", false))
+	require.Equal(t, []bittorrent.Peer{v6}, announce("", true))
+
+	// Once the client announced from the address, it needs the same key.
+	require.Nil(t, ks.PutPeerKey(ih, v6, "secret"))
+	require.Equal(t, []bittorrent.Peer{v6}, announce("secret", false))
+	require.Empty(t, announce("guess", false))
+	require.Empty(t, announce("", false))
+
+	// The key is bound to another address.
+	moved := v6
+	moved.IP.IP = net.ParseIP("2001:db8::2")
+	require.Nil(t, ks.PutPeerKey(ih, moved, "secret"))
+	require.Empty(t, announce("secret", false))
+}
+
+func TestSwarmInteractionPaused(t *testing.T) {
+	ps := &recordingStore{leechers: make(map[string]bool), partialSeeds: make(map[string]bool)}
+	h := &swarmInteractionHook{store: ps}
+
+	p := bittorrent.Peer{
+		ID:   bittorrent.PeerIDFromString("-XX1000-abcdefghijkl"),
+		IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4},
+		Port: 6881,
+	}
+	req := &bittorrent.AnnounceRequest{
+		InfoHash: bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa"),
+		Event:    bittorrent.Paused,
+		Left:     1,
+		Peer:     p,
+	}
+
+	_, err := h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Equal(t, map[string]bool{p.String(): true}, ps.partialSeeds)
+	require.Empty(t, ps.leechers)
+
+	req.Event = bittorrent.Stopped
+	_, err = h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Empty(t, ps.partialSeeds)
+}
+
+func TestResponseHookPartialSeedAlone(t *testing.T) {
+	h := &responseHook{store: &recordingStore{}}
+	req := &bittorrent.AnnounceRequest{
+		InfoHash: bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa"),
+		Event:    bittorrent.Paused,
+		Left:     1,
+		Peer: bittorrent.Peer{
+			IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4},
+			Port: 6881,
+		},
+	}
+
+	resp := &bittorrent.AnnounceResponse{}
+	_, err := h.HandleAnnounce(context.Background(), req, resp)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1), resp.Complete)
+	require.Equal(t, uint32(1), resp.Incomplete)
+	require.Equal(t, []bittorrent.Peer{req.Peer}, resp.IPv4Peers)
+}
+
+func TestResponseHookSplitsFamilies(t *testing.T) {
+	v4 := bittorrent.Peer{IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}, Port: 6881}
+	v6 := bittorrent.Peer{IP: bittorrent.IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: bittorrent.IPv6}, Port: 6881}
+	own := bittorrent.Peer{
+		ID:   bittorrent.PeerIDFromString("-XX1000-abcdefghijkl"),
+		IP:   bittorrent.IP{IP: net.ParseIP("2001:db8::2"), AddressFamily: bittorrent.IPv6},
+		Port: 6881,
+	}
+	h := &responseHook{store: &recordingStore{peers: []bittorrent.Peer{v4, v6, own}}}
+
+	req := &bittorrent.AnnounceRequest{
+		Peer: bittorrent.Peer{
+			ID:   own.ID,
+			IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.2").To4(), AddressFamily: bittorrent.IPv4},
+			Port: 6881,
+		},
+		AdditionalPeers: []bittorrent.Peer{own},
+	}
+	resp := &bittorrent.AnnounceResponse{}
+	_, err := h.HandleAnnounce(context.Background(), req, resp)
+	require.Nil(t, err)
+	require.Equal(t, []bittorrent.Peer{v4}, resp.IPv4Peers)
+	require.Equal(t, []bittorrent.Peer{v6}, resp.IPv6Peers)
+}
+
+func TestHooksUseCanonicalInfoHash(t *testing.T) {
+	v1 := bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	v2 := bittorrent.InfoHashFromString("bbbbbbbbbbbbbbbbbbbb")
+	other := bittorrent.InfoHashFromString("cccccccccccccccccccc")
+	ctx := context.WithValue(context.Background(), InfoHashAliasesKey, map[bittorrent.InfoHash][]bittorrent.InfoHash{
+		v2: {v1, v2},
+	})
+	ps := &recordingStore{leechers: make(map[string]bool)}
+
+	req := &bittorrent.AnnounceRequest{
+		InfoHash: v2,
+		Left:     1,
+		Peer:     bittorrent.Peer{IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}, Port: 6881},
+	}
+	_, err := (&swarmInteractionHook{store: ps}).HandleAnnounce(ctx, req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Equal(t, []bittorrent.InfoHash{v1}, ps.swarms)
+
+	// Scrapes are reported under the requested infohashes.
+	ps.swarms = nil
+	resp := &bittorrent.ScrapeResponse{}
+	_, err = (&responseHook{store: ps}).HandleScrape(ctx, &bittorrent.ScrapeRequest{InfoHashes: []bittorrent.InfoHash{v2, other}}, resp)
+	require.Nil(t, err)
+	require.Equal(t, []bittorrent.InfoHash{v1, other}, ps.swarms)
+	require.Equal(t, []bittorrent.Scrape{{InfoHash: v2, Complete: 1}, {InfoHash: other, Complete: 1}}, resp.Files)
+}
+
+func TestPeerKeyHook(t *testing.T) {
+	ps, err := memory.New(memory.Config{})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+	ks := ps.(storage.PeerKeyStore)
+	h := &peerKeyHook{store: ks}
+
+	ih := bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	victim := bittorrent.Peer{
+		ID:   bittorrent.PeerIDFromString("-XX1000-abcdefghijkl"),
+		IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4},
+		Port: 6881,
+	}
+	announce := func(p bittorrent.Peer, event bittorrent.Event, key string) error {
+		req := &bittorrent.AnnounceRequest{InfoHash: ih, Event: event, Left: 1, Peer: p, Key: key}
+		_, err := h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+		return err
+	}
+
+	require.Nil(t, announce(victim, bittorrent.Started, "secret"))
+	require.Nil(t, ps.PutLeecher(ih, victim))
+
+	// Other clients using the peer ID are rejected, with or without a key.
+	attacker := victim
+	attacker.IP.IP = net.ParseIP("198.51.100.1").To4()
+	require.Equal(t, ErrInvalidPeerKey, announce(attacker, bittorrent.Stopped, ""))
+	require.Equal(t, ErrInvalidPeerKey, announce(attacker, bittorrent.Stopped, "guess"))
+
+	// Keys are compared case-insensitively.
+	require.Nil(t, announce(victim, bittorrent.None, "SECRET"))
+
+	// The client itself may change its address, removing the old one.
+	moved := victim
+	moved.IP.IP = net.ParseIP("192.0.2.2").To4()
+	require.Nil(t, announce(moved, bittorrent.None, "secret"))
+	require.Equal(t, storage.ErrResourceDoesNotExist, ps.DeleteLeecher(ih, victim))
+
+	_, stored, err := ks.PeerKey(ih, victim)
+	require.Nil(t, err)
+	require.True(t, stored.Equal(moved))
+
+	// Stopping releases the key.
+	require.Nil(t, announce(moved, bittorrent.Stopped, "secret"))
+	_, _, err = ks.PeerKey(ih, victim)
+	require.Equal(t, storage.ErrResourceDoesNotExist, err)
+	require.Nil(t, announce(attacker, bittorrent.Started, ""))
+}
+
+func TestPeerKeyHookWithoutKey(t *testing.T) {
+	ps, err := memory.New(memory.Config{})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+	ks := ps.(storage.PeerKeyStore)
+	h := &peerKeyHook{store: ks}
+
+	ih := bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	victim := bittorrent.Peer{
+		ID:   bittorrent.PeerIDFromString("-XX1000-abcdefghijkl"),
+		IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4},
+		Port: 6881,
+	}
+	attacker := victim
+	attacker.IP.IP = net.ParseIP("198.51.100.1").To4()
+	announce := func(p bittorrent.Peer, key string) error {
+		req := &bittorrent.AnnounceRequest{InfoHash: ih, Left: 1, Peer: p, Key: key}
+		_, err := h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+		return err
+	}
+
+	// A client without a key cannot be locked out by another client binding
+	// a key to its peer ID.
+	require.Nil(t, announce(victim, ""))
+	require.Nil(t, announce(attacker, "guess"))
+	require.Nil(t, announce(victim, ""))
+
+	key, stored, err := ks.PeerKey(ih, victim)
+	require.Nil(t, err)
+	require.Equal(t, "", key)
+	require.True(t, stored.Equal(victim))
+
+	// It can start using a key itself.
+	require.Nil(t, announce(victim, "secret"))
+	require.Equal(t, ErrInvalidPeerKey, announce(attacker, "guess"))
+}
+
+func TestNewLogicPeerKeysUnsupported(t *testing.T) {
+	_, err := NewLogic(ResponseConfig{EnforcePeerKeys: true}, &recordingStore{}, nil, nil)
+	require.Equal(t, ErrPeerKeysUnsupported, err)
+}
+
+func TestSwarmInteractionMetadata(t *testing.T) {
+	ps, err := memory.New(memory.Config{StorePeerMetadata: true})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+	h := &swarmInteractionHook{store: ps}
+
+	req := &bittorrent.AnnounceRequest{
+		InfoHash:   bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa"),
+		Left:       1,
+		Uploaded:   2,
+		Downloaded: 3,
+		SourceIP:   net.ParseIP("198.51.100.1"),
+		Peer: bittorrent.Peer{
+			ID:   bittorrent.PeerIDFromString("-XX1000-abcdefghijkl"),
+			IP:   bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4},
+			Port: 6881,
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), UserIDKey, "42")
+	ctx = context.WithValue(ctx, ConnectableKey, true)
+	_, err = h.HandleAnnounce(ctx, req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+
+	md, err := ps.(storage.PeerMetadataStore).PeerMetadata(req.InfoHash, req.Peer)
+	require.Nil(t, err)
+	require.Equal(t, bittorrent.NewClientID(req.Peer.ID), md.ClientID)
+	require.Equal(t, "42", md.UserID)
+	require.Equal(t, uint64(1), md.Left)
+	require.Equal(t, uint64(2), md.Uploaded)
+	require.Equal(t, uint64(3), md.Downloaded)
+	require.Equal(t, req.SourceIP, md.SourceIP)
+	require.True(t, md.Connectable)
+	require.False(t, md.FirstSeen.IsZero())
+
+	// Announces of unknown connectability keep the stored one.
+	_, err = h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+
+	md, err = ps.(storage.PeerMetadataStore).PeerMetadata(req.InfoHash, req.Peer)
+	require.Nil(t, err)
+	require.True(t, md.Connectable)
+
+	req.Event = bittorrent.Stopped
+	_, err = h.HandleAnnounce(ctx, req, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+
+	_, err = ps.(storage.PeerMetadataStore).PeerMetadata(req.InfoHash, req.Peer)
+	require.Equal(t, storage.ErrResourceDoesNotExist, err)
+}
@@ -0,0 +1,370 @@
+// Package infohashalias implements a Hook that makes swarms known under
+// multiple infohashes, such as the v1 and truncated v2 infohashes of hybrid
+// torrents, one swarm.
+//
+// Aliases are loaded from a file or a Redis hash and reloaded periodically.
+// The hook stores the infohashes of each swarm of a request under
+// middleware.InfoHashAliasesKey, so it must run before any hook that relies
+// on them, such as torrent approval.
+package infohashalias
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/redisurl"
+	"github.com/chihaya/chihaya/pkg/stop"
+)
+
+// Name is the name by which this middleware is registered with Chihaya.
+const Name = "infohash alias"
+
+// Default config constants.
+const (
+	defaultHashKey        = "pt:infohash_aliases"
+	defaultReloadInterval = time.Minute
+)
+
+func init() {
+	middleware.RegisterDriver(Name, driver{})
+}
+
+var _ middleware.Driver = driver{}
+
+type driver struct{}
+
+func (d driver) NewHook(optionBytes []byte) (middleware.Hook, error) {
+	var cfg Config
+	err := yaml.Unmarshal(optionBytes, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for middleware %s: %w", Name, err)
+	}
+
+	return NewHook(cfg)
+}
+
+// ErrMissingSource is returned for a config with neither a file nor a Redis
+// broker.
+var ErrMissingSource = errors.New("must specify file or redis_broker")
+
+// Config represents all the values required by this middleware.
+type Config struct {
+	// File is the path of a file of aliases, one per line as the hex-encoded
+	// alias followed by the hex-encoded canonical infohash. Empty lines and
+	// lines starting with # are ignored.
+	File string `yaml:"file"`
+
+	// RedisBroker is the URL of a Redis server with a hash under HashKey,
+	// mapping hex-encoded aliases to hex-encoded canonical infohashes.
+	RedisBroker string `yaml:"redis_broker"`
+	HashKey     string `yaml:"hash_key"`
+
+	// ReloadInterval is the interval in which aliases are reloaded.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	RedisReadTimeout    time.Duration `yaml:"redis_read_timeout"`
+	RedisWriteTimeout   time.Duration `yaml:"redis_write_timeout"`
+	RedisConnectTimeout time.Duration `yaml:"redis_connect_timeout"`
+}
+
+// LogFields renders the current config as a set of Logrus fields.
+func (cfg Config) LogFields() log.Fields {
+	return log.Fields{
+		"name":           Name,
+		"file":           cfg.File,
+		"redisBroker":    cfg.RedisBroker,
+		"hashKey":        cfg.HashKey,
+		"reloadInterval": cfg.ReloadInterval,
+	}
+}
+
+// aliasTable maps every infohash of a swarm with aliases to all infohashes of
+// the swarm, the canonical one first.
+type aliasTable map[bittorrent.InfoHash][]bittorrent.InfoHash
+
+// newAliasTable creates an aliasTable from pairs of an alias and its
+// canonical infohash.
+//
+// Chains of aliases are resolved to the infohash at their end, so that all of
+// their members share one swarm. Aliases with more than one canonical infohash
+// and cycles are rejected.
+func newAliasTable(pairs [][2]bittorrent.InfoHash) (aliasTable, error) {
+	canonicals := make(map[bittorrent.InfoHash]bittorrent.InfoHash, len(pairs))
+	for _, pair := range pairs {
+		alias, canonical := pair[0], pair[1]
+		if alias == canonical {
+			continue
+		}
+		if c, ok := canonicals[alias]; ok && c != canonical {
+			return nil, fmt.Errorf("alias %s has multiple canonical infohashes: %s and %s", alias, c, canonical)
+		}
+		canonicals[alias] = canonical
+	}
+
+	t := make(aliasTable)
+	for alias, canonical := range canonicals {
+		// A chain cannot be longer than the number of aliases.
+		for i := 0; ; i++ {
+			next, ok := canonicals[canonical]
+			if !ok {
+				break
+			}
+			if i == len(canonicals) {
+				return nil, fmt.Errorf("alias %s is part of a cycle", alias)
+			}
+			canonical = next
+		}
+
+		if _, ok := t[canonical]; !ok {
+			t[canonical] = []bittorrent.InfoHash{canonical}
+		}
+		t[canonical] = append(t[canonical], alias)
+	}
+
+	// All members of a swarm share its list of infohashes, with the aliases
+	// in a stable order.
+	for canonical, hashes := range t {
+		if hashes[0] != canonical {
+			continue
+		}
+		aliases := hashes[1:]
+		sort.Slice(aliases, func(i, j int) bool {
+			return bytes.Compare(aliases[i][:], aliases[j][:]) < 0
+		})
+		for _, alias := range aliases {
+			t[alias] = hashes
+		}
+	}
+	return t, nil
+}
+
+// parseInfoHash parses a hex-encoded 20 byte infohash.
+func parseInfoHash(s string) (bittorrent.InfoHash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 20 {
+		return bittorrent.InfoHash{}, fmt.Errorf("invalid infohash %q", s)
+	}
+	return bittorrent.InfoHashFromBytes(b), nil
+}
+
+// parsePair parses an alias and its canonical infohash.
+func parsePair(alias, canonical string) (pair [2]bittorrent.InfoHash, err error) {
+	if pair[0], err = parseInfoHash(alias); err != nil {
+		return pair, err
+	}
+	pair[1], err = parseInfoHash(canonical)
+	return pair, err
+}
+
+// readAliases reads the aliases of a file.
+func readAliases(r io.Reader) ([][2]bittorrent.InfoHash, error) {
+	var pairs [][2]bittorrent.InfoHash
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected alias and canonical infohash", line)
+		}
+		pair, err := parsePair(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, s.Err()
+}
+
+type hook struct {
+	cfg     Config
+	pool    *redis.Pool
+	table   atomic.Value
+	closing chan struct{}
+}
+
+// NewHook returns an instance of the infohash alias middleware.
+//
+// Aliases are loaded once before it returns, failing to load them is an
+// error. Later failures keep the previously loaded aliases.
+func NewHook(cfg Config) (middleware.Hook, error) {
+	if cfg.File == "" && cfg.RedisBroker == "" {
+		return nil, ErrMissingSource
+	}
+	if cfg.HashKey == "" {
+		cfg.HashKey = defaultHashKey
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultReloadInterval
+	}
+
+	h := &hook{
+		cfg:     cfg,
+		closing: make(chan struct{}),
+	}
+
+	if cfg.RedisBroker != "" {
+		ru, err := redisurl.Parse(cfg.RedisBroker)
+		if err != nil {
+			return nil, err
+		}
+		h.pool = &redis.Pool{
+			MaxIdle:     1,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				opts := []redis.DialOption{
+					redis.DialDatabase(ru.DB),
+					redis.DialReadTimeout(cfg.RedisReadTimeout),
+					redis.DialWriteTimeout(cfg.RedisWriteTimeout),
+					redis.DialConnectTimeout(cfg.RedisConnectTimeout),
+				}
+				if ru.Password != "" {
+					opts = append(opts, redis.DialPassword(ru.Password))
+				}
+				return redis.Dial("tcp", ru.Host, opts...)
+			},
+		}
+	}
+
+	t, err := h.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load infohash aliases: %w", err)
+	}
+	h.table.Store(t)
+
+	go func() {
+		t := time.NewTicker(cfg.ReloadInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-h.closing:
+				return
+			case <-t.C:
+				h.reload()
+			}
+		}
+	}()
+
+	log.Info("infohash alias middleware enabled", cfg)
+	return h, nil
+}
+
+// load loads the aliases of the file and the Redis hash.
+func (h *hook) load() (aliasTable, error) {
+	var pairs [][2]bittorrent.InfoHash
+
+	if h.cfg.File != "" {
+		f, err := os.Open(h.cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if pairs, err = readAliases(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", h.cfg.File, err)
+		}
+	}
+
+	if h.pool != nil {
+		conn := h.pool.Get()
+		defer conn.Close()
+
+		m, err := redis.StringMap(conn.Do("HGETALL", h.cfg.HashKey))
+		if err != nil {
+			return nil, err
+		}
+		for alias, canonical := range m {
+			pair, err := parsePair(alias, canonical)
+			if err != nil {
+				log.Warn("infohash alias: ignoring invalid alias", log.Fields{"alias": alias, "canonical": canonical, "error": err})
+				continue
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return newAliasTable(pairs)
+}
+
+// reload reloads the aliases, keeping the previous ones on failure.
+func (h *hook) reload() {
+	t, err := h.load()
+	if err != nil {
+		log.Error("infohash alias: failed to reload aliases, keeping previous ones", log.Err(err))
+		return
+	}
+	h.table.Store(t)
+	log.Debug("infohash alias: reloaded aliases", log.Fields{"infohashes": len(t)})
+}
+
+func (h *hook) current() aliasTable {
+	return h.table.Load().(aliasTable)
+}
+
+// withAliases stores the swarms with aliases among the given infohashes in
+// the context.
+func (h *hook) withAliases(ctx context.Context, infoHashes ...bittorrent.InfoHash) context.Context {
+	t := h.current()
+
+	var aliases map[bittorrent.InfoHash][]bittorrent.InfoHash
+	for _, ih := range infoHashes {
+		if hashes, ok := t[ih]; ok {
+			if aliases == nil {
+				aliases = make(map[bittorrent.InfoHash][]bittorrent.InfoHash)
+			}
+			aliases[ih] = hashes
+		}
+	}
+
+	if aliases == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, middleware.InfoHashAliasesKey, aliases)
+}
+
+func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	return h.withAliases(ctx, req.InfoHash), nil
+}
+
+func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
+	return h.withAliases(ctx, req.InfoHashes...), nil
+}
+
+// Stop stops reloading aliases.
+func (h *hook) Stop() stop.Result {
+	select {
+	case <-h.closing:
+		return stop.AlreadyStopped
+	default:
+	}
+
+	c := make(stop.Channel)
+	go func() {
+		close(h.closing)
+		if h.pool != nil {
+			c.Done(h.pool.Close())
+			return
+		}
+		c.Done()
+	}()
+	return c.Result()
+}
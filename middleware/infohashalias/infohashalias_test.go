@@ -0,0 +1,105 @@
+package infohashalias
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+)
+
+var (
+	v1    = bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	v2    = bittorrent.InfoHashFromString("bbbbbbbbbbbbbbbbbbbb")
+	other = bittorrent.InfoHashFromString("cccccccccccccccccccc")
+)
+
+func TestReadAliases(t *testing.T) {
+	pairs, err := readAliases(strings.NewReader(fmt.Sprintf("# hybrid torrents\n\n%s %s\n", v2, v1)))
+	require.Nil(t, err)
+	require.Equal(t, [][2]bittorrent.InfoHash{{v2, v1}}, pairs)
+
+	_, err = readAliases(strings.NewReader(v2.String()))
+	require.NotNil(t, err)
+
+	_, err = readAliases(strings.NewReader("nothex " + v1.String()))
+	require.NotNil(t, err)
+}
+
+func TestNewAliasTable(t *testing.T) {
+	table, err := newAliasTable([][2]bittorrent.InfoHash{{v2, v1}, {other, other}})
+	require.Nil(t, err)
+	require.Equal(t, aliasTable{
+		v1: {v1, v2},
+		v2: {v1, v2},
+	}, table)
+
+	// Chains end up in the swarm of their last infohash.
+	table, err = newAliasTable([][2]bittorrent.InfoHash{{other, v2}, {v2, v1}})
+	require.Nil(t, err)
+	require.Equal(t, aliasTable{
+		v1:    {v1, v2, other},
+		v2:    {v1, v2, other},
+		other: {v1, v2, other},
+	}, table)
+
+	_, err = newAliasTable([][2]bittorrent.InfoHash{{v2, v1}, {v2, other}})
+	require.NotNil(t, err)
+
+	_, err = newAliasTable([][2]bittorrent.InfoHash{{v2, v1}, {v1, v2}})
+	require.NotNil(t, err)
+}
+
+func TestHookFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases")
+	require.Nil(t, os.WriteFile(path, []byte(v2.String()+" "+v1.String()+"\n"), 0o600))
+
+	h, err := NewHook(Config{File: path})
+	require.Nil(t, err)
+	defer func() { <-h.(*hook).Stop() }()
+
+	ctx, err := h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{InfoHash: v2}, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Equal(t, []bittorrent.InfoHash{v1, v2}, middleware.SwarmInfoHashes(ctx, v2))
+
+	ctx, err = h.HandleScrape(context.Background(), &bittorrent.ScrapeRequest{InfoHashes: []bittorrent.InfoHash{other, v1}}, &bittorrent.ScrapeResponse{})
+	require.Nil(t, err)
+	require.Equal(t, []bittorrent.InfoHash{v1, v2}, middleware.SwarmInfoHashes(ctx, v1))
+	require.Equal(t, []bittorrent.InfoHash{other}, middleware.SwarmInfoHashes(ctx, other))
+
+	// Announces without aliases leave the context alone.
+	ctx = context.Background()
+	nctx, err := h.HandleAnnounce(ctx, &bittorrent.AnnounceRequest{InfoHash: other}, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+	require.Equal(t, ctx, nctx)
+}
+
+func TestHookFromRedis(t *testing.T) {
+	rs, err := miniredis.Run()
+	require.Nil(t, err)
+	defer rs.Close()
+
+	h, err := NewHook(Config{RedisBroker: fmt.Sprintf("redis://@%s/0", rs.Addr()), ReloadInterval: 10 * time.Millisecond})
+	require.Nil(t, err)
+	defer func() { <-h.(*hook).Stop() }()
+	require.Empty(t, h.(*hook).current())
+
+	// Aliases added by the site are picked up on reload.
+	rs.HSet(defaultHashKey, v2.String(), v1.String())
+	require.Eventually(t, func() bool {
+		return len(h.(*hook).current()) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMissingSource(t *testing.T) {
+	_, err := NewHook(Config{})
+	require.Equal(t, ErrMissingSource, err)
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/chihaya/chihaya/bittorrent"
@@ -20,20 +21,55 @@ import (
 type ResponseConfig struct {
 	AnnounceInterval    time.Duration `yaml:"announce_interval"`
 	MinAnnounceInterval time.Duration `yaml:"min_announce_interval"`
+
+	// TrackerID is sent as tracker id to clients that did not send one.
+	// Clients that send a tracker id get their own back.
+	TrackerID string `yaml:"tracker_id"`
+
+	// EnforcePeerKeys rejects announces of peers whose key (BEP 3) does not
+	// match the key they first announced with. It requires a peer store that
+	// implements storage.PeerKeyStore.
+	EnforcePeerKeys bool `yaml:"enforce_peer_keys"`
+
+	// PostHookWorkers is the number of goroutines running post-hooks.
+	PostHookWorkers int `yaml:"posthook_workers"`
+
+	// PostHookQueueSize is the number of requests that may wait for a
+	// post-hook worker.
+	PostHookQueueSize int `yaml:"posthook_queue_size"`
+
+	// PostHookOverflow is the policy for requests that do not fit into the
+	// post-hook queue: "block" (the default), "drop" or "inline". Dropped
+	// requests lose their peer and traffic updates.
+	PostHookOverflow string `yaml:"posthook_overflow"`
 }
 
 var _ frontend.TrackerLogic = &Logic{}
 
+// ErrPeerKeysUnsupported is returned by NewLogic if EnforcePeerKeys is set for
+// a peer store that does not store peer keys.
+var ErrPeerKeysUnsupported = errors.New("enforce_peer_keys requires a peer store that stores peer keys")
+
 // NewLogic creates a new instance of a TrackerLogic that executes the provided
 // middleware hooks.
-func NewLogic(cfg ResponseConfig, peerStore storage.PeerStore, preHooks, postHooks []Hook) *Logic {
+func NewLogic(cfg ResponseConfig, peerStore storage.PeerStore, preHooks, postHooks []Hook) (*Logic, error) {
+	preHooks = append(preHooks, &additionalPeersHook{store: peerStore})
+	if cfg.EnforcePeerKeys {
+		ks, ok := peerStore.(storage.PeerKeyStore)
+		if !ok {
+			return nil, ErrPeerKeysUnsupported
+		}
+		preHooks = append(preHooks, &peerKeyHook{store: ks})
+	}
 	return &Logic{
 		announceInterval:    cfg.AnnounceInterval,
 		minAnnounceInterval: cfg.MinAnnounceInterval,
+		trackerID:           cfg.TrackerID,
 		peerStore:           peerStore,
 		preHooks:            append(preHooks, &responseHook{store: peerStore}),
 		postHooks:           append(postHooks, &swarmInteractionHook{store: peerStore}),
-	}
+		postHookPool:        newPostHookPool(cfg),
+	}, nil
 }
 
 // Logic is an implementation of the TrackerLogic that functions by
@@ -41,9 +77,11 @@ func NewLogic(cfg ResponseConfig, peerStore storage.PeerStore, preHooks, postHoo
 type Logic struct {
 	announceInterval    time.Duration
 	minAnnounceInterval time.Duration
+	trackerID           string
 	peerStore           storage.PeerStore
 	preHooks            []Hook
 	postHooks           []Hook
+	postHookPool        *postHookPool
 }
 
 // HandleAnnounce generates a response for an Announce.
@@ -52,6 +90,10 @@ func (l *Logic) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequ
 		Interval:    l.announceInterval,
 		MinInterval: l.minAnnounceInterval,
 		Compact:     req.Compact,
+		TrackerID:   req.TrackerID,
+	}
+	if resp.TrackerID == "" {
+		resp.TrackerID = l.trackerID
 	}
 	for _, h := range l.preHooks {
 		if ctx, err = h.HandleAnnounce(ctx, req, resp); err != nil {
@@ -65,7 +107,14 @@ func (l *Logic) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequ
 
 // AfterAnnounce does something with the results of an Announce after it has
 // been completed.
+//
+// The post-hooks are run by a worker pool; depending on the configured
+// overflow policy, this may block or drop them if the pool is saturated.
 func (l *Logic) AfterAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) {
+	l.postHookPool.submit("announce", func() { l.afterAnnounce(ctx, req, resp) })
+}
+
+func (l *Logic) afterAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) {
 	var err error
 	for _, h := range l.postHooks {
 		if ctx, err = h.HandleAnnounce(ctx, req, resp); err != nil {
@@ -92,7 +141,13 @@ func (l *Logic) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest)
 
 // AfterScrape does something with the results of a Scrape after it has been
 // completed.
+//
+// Like AfterAnnounce, the post-hooks are run by a worker pool.
 func (l *Logic) AfterScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) {
+	l.postHookPool.submit("scrape", func() { l.afterScrape(ctx, req, resp) })
+}
+
+func (l *Logic) afterScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) {
 	var err error
 	for _, h := range l.postHooks {
 		if ctx, err = h.HandleScrape(ctx, req, resp); err != nil {
@@ -104,8 +159,11 @@ func (l *Logic) AfterScrape(ctx context.Context, req *bittorrent.ScrapeRequest,
 
 // Stop stops the Logic.
 //
-// This stops any hooks that implement stop.Stopper.
+// This waits for queued post-hooks to finish and then stops any hooks that
+// implement stop.Stopper.
 func (l *Logic) Stop() stop.Result {
+	l.postHookPool.stop()
+
 	stopGroup := stop.NewGroup()
 	for _, hook := range l.preHooks {
 		stoppable, ok := hook.(stop.Stopper)
@@ -0,0 +1,522 @@
+// Package lua implements a Hook that runs user-supplied Lua scripts for every
+// Announce and Scrape.
+//
+// A script may define the global functions handle_announce and handle_scrape.
+// Both are called with three tables: the request, the response and the
+// identity of the client. Changes to writable fields of these tables are
+// applied after the function returns. If the function returns a string, the
+// request is rejected with that string as the failure reason.
+//
+// Scripts are reloaded when the file changes and whenever the tracker reloads
+// its configuration, such as on SIGUSR1 or SIGHUP.
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	glua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/middleware/passkeyapproval"
+	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/stop"
+)
+
+// Name is the name by which this middleware is registered with Chihaya.
+const Name = "lua"
+
+// Default config constants.
+const (
+	defaultTimeout        = 50 * time.Millisecond
+	defaultReloadInterval = 10 * time.Second
+)
+
+// Names of the functions a script can define.
+const (
+	announceFunc = "handle_announce"
+	scrapeFunc   = "handle_scrape"
+)
+
+func init() {
+	middleware.RegisterDriver(Name, driver{})
+}
+
+var _ middleware.Driver = driver{}
+
+type driver struct{}
+
+func (d driver) NewHook(optionBytes []byte) (middleware.Hook, error) {
+	var cfg Config
+	err := yaml.Unmarshal(optionBytes, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for middleware %s: %w", Name, err)
+	}
+
+	return NewHook(cfg)
+}
+
+// ErrMissingScript is returned for a config without a script path.
+var ErrMissingScript = errors.New("missing script path")
+
+// Config represents all the values required by this middleware.
+type Config struct {
+	// ScriptPath is the path of the Lua script to run.
+	ScriptPath string `yaml:"script_path"`
+
+	// Timeout is the maximum duration a single call into the script may take.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// ReloadInterval is the interval in which the script file is checked for
+	// changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// LogFields renders the current config as a set of Logrus fields.
+func (cfg Config) LogFields() log.Fields {
+	return log.Fields{
+		"name":           Name,
+		"scriptPath":     cfg.ScriptPath,
+		"timeout":        cfg.Timeout,
+		"reloadInterval": cfg.ReloadInterval,
+	}
+}
+
+// script is a compiled script along with a pool of interpreters that have
+// loaded it.
+type script struct {
+	modTime time.Time
+	proto   *glua.FunctionProto
+	states  sync.Pool
+}
+
+// newScript compiles the script at the given path.
+func newScript(path string) (*script, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chunk, err := parse.Parse(f, path)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := glua.Compile(chunk, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the script once to catch runtime errors in the top-level chunk.
+	L, err := newState(proto)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &script{modTime: fi.ModTime(), proto: proto}
+	s.states.Put(L)
+
+	return s, nil
+}
+
+// state returns an interpreter from the pool or creates a new one.
+func (s *script) state() (*glua.LState, error) {
+	if L, ok := s.states.Get().(*glua.LState); ok {
+		return L, nil
+	}
+	return newState(s.proto)
+}
+
+// newState creates an interpreter and runs the compiled script in it.
+func newState(proto *glua.FunctionProto) (*glua.LState, error) {
+	L := glua.NewState()
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, glua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+type hook struct {
+	cfg     Config
+	script  atomic.Value
+	closing chan struct{}
+}
+
+// NewHook returns an instance of the Lua middleware.
+func NewHook(cfg Config) (middleware.Hook, error) {
+	if cfg.ScriptPath == "" {
+		return nil, ErrMissingScript
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultReloadInterval
+	}
+
+	s, err := newScript(cfg.ScriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lua script: %w", err)
+	}
+
+	h := &hook{
+		cfg:     cfg,
+		closing: make(chan struct{}),
+	}
+	h.script.Store(s)
+
+	go func() {
+		t := time.NewTicker(cfg.ReloadInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-h.closing:
+				return
+			case <-t.C:
+				h.reload(false)
+			}
+		}
+	}()
+
+	log.Info("lua middleware enabled", cfg)
+	return h, nil
+}
+
+// reload reloads the script if it was modified or force is true.
+// If the new script fails to load, the previous script is kept.
+func (h *hook) reload(force bool) {
+	fi, err := os.Stat(h.cfg.ScriptPath)
+	if err != nil {
+		log.Error("lua: failed to stat script", log.Err(err))
+		return
+	}
+	if !force && fi.ModTime().Equal(h.current().modTime) {
+		return
+	}
+
+	s, err := newScript(h.cfg.ScriptPath)
+	if err != nil {
+		log.Error("lua: failed to reload script, keeping previous version", log.Err(err))
+		return
+	}
+	h.script.Store(s)
+	log.Info("lua: reloaded script", log.Fields{"scriptPath": h.cfg.ScriptPath})
+}
+
+func (h *hook) current() *script {
+	return h.script.Load().(*script)
+}
+
+// Stop stops watching the script for changes.
+func (h *hook) Stop() stop.Result {
+	select {
+	case <-h.closing:
+		return stop.AlreadyStopped
+	default:
+	}
+
+	c := make(stop.Channel)
+	go func() {
+		close(h.closing)
+		c.Done()
+	}()
+	return c.Result()
+}
+
+// call calls the named function of the script with the arguments created by
+// args and applies the modified arguments using results.
+//
+// If the function does not exist, nothing happens. If the function returns a
+// string, it is returned as a ClientError.
+func (h *hook) call(ctx context.Context, name string, args func(L *glua.LState) []glua.LValue, results func(args []glua.LValue)) error {
+	s := h.current()
+	L, err := s.state()
+	if err != nil {
+		return fmt.Errorf("lua: failed to run script: %w", err)
+	}
+
+	fn, ok := L.GetGlobal(name).(*glua.LFunction)
+	if !ok {
+		s.states.Put(L)
+		return nil
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+	L.SetContext(callCtx)
+
+	argv := args(L)
+	err = L.CallByParam(glua.P{Fn: fn, NRet: 1, Protect: true}, argv...)
+	if callCtx.Err() != nil {
+		// An interrupted interpreter may be left in an inconsistent state.
+		defer L.Close()
+	} else {
+		L.RemoveContext()
+		defer s.states.Put(L)
+	}
+	if err != nil {
+		return fmt.Errorf("lua: %s failed: %w", name, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	results(argv)
+
+	if reason, ok := ret.(glua.LString); ok {
+		return bittorrent.ClientError(reason)
+	}
+	return nil
+}
+
+func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	err := h.call(ctx, announceFunc,
+		func(L *glua.LState) []glua.LValue {
+			return []glua.LValue{announceRequestTable(L, req), announceResponseTable(L, resp), identityTable(L, ctx)}
+		},
+		func(argv []glua.LValue) {
+			applyAnnounceRequest(argv[0].(*glua.LTable), req)
+			applyAnnounceResponse(argv[1].(*glua.LTable), resp)
+			ctx = applyIdentity(argv[2].(*glua.LTable), ctx)
+		},
+	)
+	return ctx, err
+}
+
+func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
+	err := h.call(ctx, scrapeFunc,
+		func(L *glua.LState) []glua.LValue {
+			return []glua.LValue{scrapeRequestTable(L, req), scrapeResponseTable(L, resp), identityTable(L, ctx)}
+		},
+		func(argv []glua.LValue) {
+			applyScrapeResponse(argv[1].(*glua.LTable), resp)
+			ctx = applyIdentity(argv[2].(*glua.LTable), ctx)
+		},
+	)
+	return ctx, err
+}
+
+func paramsTable(L *glua.LState, p bittorrent.Params) *glua.LTable {
+	t := L.NewTable()
+	if p == nil {
+		return t
+	}
+
+	values, err := url.ParseQuery(p.RawQuery())
+	if err != nil {
+		return t
+	}
+	for k, v := range values {
+		if len(v) > 0 {
+			t.RawSetString(k, glua.LString(v[len(v)-1]))
+		}
+	}
+	return t
+}
+
+func announceRequestTable(L *glua.LState, req *bittorrent.AnnounceRequest) *glua.LTable {
+	clientID := bittorrent.NewClientID(req.Peer.ID)
+
+	t := L.NewTable()
+	t.RawSetString("info_hash", glua.LString(req.InfoHash.String()))
+	t.RawSetString("peer_id", glua.LString(req.Peer.ID.String()))
+	t.RawSetString("client_id", glua.LString(clientID[:]))
+	t.RawSetString("ip", glua.LString(req.Peer.IP.String()))
+	t.RawSetString("address_family", glua.LString(req.Peer.IP.AddressFamily.String()))
+	t.RawSetString("port", glua.LNumber(req.Peer.Port))
+	t.RawSetString("event", glua.LString(req.Event.String()))
+	t.RawSetString("left", glua.LNumber(req.Left))
+	t.RawSetString("downloaded", glua.LNumber(req.Downloaded))
+	t.RawSetString("uploaded", glua.LNumber(req.Uploaded))
+	t.RawSetString("numwant", glua.LNumber(req.NumWant))
+	t.RawSetString("compact", glua.LBool(req.Compact))
+	t.RawSetString("params", paramsTable(L, req.Params))
+	return t
+}
+
+func applyAnnounceRequest(t *glua.LTable, req *bittorrent.AnnounceRequest) {
+	if v, ok := t.RawGetString("numwant").(glua.LNumber); ok && v >= 0 {
+		req.NumWant = uint32(v)
+	}
+	if v, ok := t.RawGetString("left").(glua.LNumber); ok && v >= 0 {
+		req.Left = uint64(v)
+	}
+	if v, ok := t.RawGetString("downloaded").(glua.LNumber); ok && v >= 0 {
+		req.Downloaded = uint64(v)
+	}
+	if v, ok := t.RawGetString("uploaded").(glua.LNumber); ok && v >= 0 {
+		req.Uploaded = uint64(v)
+	}
+	if v, ok := t.RawGetString("port").(glua.LNumber); ok && v > 0 && v <= 0xffff {
+		req.Peer.Port = uint16(v)
+	}
+	if v, ok := t.RawGetString("event").(glua.LString); ok {
+		if e, err := bittorrent.NewEvent(string(v)); err == nil {
+			req.Event = e
+		}
+	}
+	if v, ok := t.RawGetString("ip").(glua.LString); ok && string(v) != req.Peer.IP.String() {
+		ip := net.ParseIP(string(v))
+		if ip4 := ip.To4(); ip4 != nil {
+			req.Peer.IP = bittorrent.IP{IP: ip4, AddressFamily: bittorrent.IPv4}
+		} else if ip != nil {
+			req.Peer.IP = bittorrent.IP{IP: ip, AddressFamily: bittorrent.IPv6}
+		}
+	}
+}
+
+func announceResponseTable(L *glua.LState, resp *bittorrent.AnnounceResponse) *glua.LTable {
+	t := L.NewTable()
+	t.RawSetString("interval", glua.LNumber(resp.Interval/time.Second))
+	t.RawSetString("min_interval", glua.LNumber(resp.MinInterval/time.Second))
+	t.RawSetString("complete", glua.LNumber(resp.Complete))
+	t.RawSetString("incomplete", glua.LNumber(resp.Incomplete))
+	t.RawSetString("warning_message", glua.LString(resp.WarningMessage))
+	t.RawSetString("tracker_id", glua.LString(resp.TrackerID))
+	return t
+}
+
+func applyAnnounceResponse(t *glua.LTable, resp *bittorrent.AnnounceResponse) {
+	if v, ok := t.RawGetString("interval").(glua.LNumber); ok && v >= 0 {
+		resp.Interval = time.Duration(v) * time.Second
+	}
+	if v, ok := t.RawGetString("min_interval").(glua.LNumber); ok && v >= 0 {
+		resp.MinInterval = time.Duration(v) * time.Second
+	}
+	if v, ok := t.RawGetString("complete").(glua.LNumber); ok && v >= 0 {
+		resp.Complete = uint32(v)
+	}
+	if v, ok := t.RawGetString("incomplete").(glua.LNumber); ok && v >= 0 {
+		resp.Incomplete = uint32(v)
+	}
+	if v, ok := t.RawGetString("warning_message").(glua.LString); ok {
+		resp.WarningMessage = string(v)
+	}
+	if v, ok := t.RawGetString("tracker_id").(glua.LString); ok {
+		resp.TrackerID = string(v)
+	}
+}
+
+func scrapeRequestTable(L *glua.LState, req *bittorrent.ScrapeRequest) *glua.LTable {
+	infoHashes := L.NewTable()
+	for _, ih := range req.InfoHashes {
+		infoHashes.Append(glua.LString(ih.String()))
+	}
+
+	t := L.NewTable()
+	t.RawSetString("info_hashes", infoHashes)
+	t.RawSetString("address_family", glua.LString(req.AddressFamily.String()))
+	t.RawSetString("params", paramsTable(L, req.Params))
+	return t
+}
+
+func scrapeResponseTable(L *glua.LState, resp *bittorrent.ScrapeResponse) *glua.LTable {
+	files := L.NewTable()
+	for _, s := range resp.Files {
+		f := L.NewTable()
+		f.RawSetString("info_hash", glua.LString(s.InfoHash.String()))
+		f.RawSetString("complete", glua.LNumber(s.Complete))
+		f.RawSetString("incomplete", glua.LNumber(s.Incomplete))
+		f.RawSetString("downloaders", glua.LNumber(s.Downloaders))
+		f.RawSetString("snatches", glua.LNumber(s.Snatches))
+		files.Append(f)
+	}
+
+	t := L.NewTable()
+	t.RawSetString("files", files)
+	return t
+}
+
+func applyScrapeResponse(t *glua.LTable, resp *bittorrent.ScrapeResponse) {
+	files, ok := t.RawGetString("files").(*glua.LTable)
+	if !ok {
+		return
+	}
+
+	for i := range resp.Files {
+		f, ok := files.RawGetInt(i + 1).(*glua.LTable)
+		if !ok {
+			continue
+		}
+		if v, ok := f.RawGetString("complete").(glua.LNumber); ok && v >= 0 {
+			resp.Files[i].Complete = uint32(v)
+		}
+		if v, ok := f.RawGetString("incomplete").(glua.LNumber); ok && v >= 0 {
+			resp.Files[i].Incomplete = uint32(v)
+		}
+		if v, ok := f.RawGetString("downloaders").(glua.LNumber); ok && v >= 0 {
+			resp.Files[i].Downloaders = uint32(v)
+		}
+		if v, ok := f.RawGetString("snatches").(glua.LNumber); ok && v >= 0 {
+			resp.Files[i].Snatches = uint32(v)
+		}
+	}
+}
+
+func identityTable(L *glua.LState, ctx context.Context) *glua.LTable {
+	t := L.NewTable()
+
+	rp, _ := ctx.Value(bittorrent.RouteParamsKey).(bittorrent.RouteParams)
+	routeParams := L.NewTable()
+	for _, p := range rp {
+		routeParams.RawSetString(p.Key, glua.LString(p.Value))
+	}
+	t.RawSetString("route_params", routeParams)
+
+	if userID, ok := ctx.Value(middleware.UserIDKey).(string); ok {
+		t.RawSetString("user_id", glua.LString(userID))
+	}
+
+	if payload, ok := ctx.Value(passkeyapproval.PasskeyPayloadKey).(*passkeyapproval.Payload); ok && payload != nil {
+		t.RawSetString("passkey", glua.LString(payload.Passkey))
+		if payload.Fd != nil {
+			t.RawSetString("fd", glua.LString(fmt.Sprintf("%v", payload.Fd)))
+		}
+		if payload.Pd != nil {
+			t.RawSetString("pd", glua.LString(fmt.Sprintf("%v", payload.Pd)))
+		}
+	}
+
+	return t
+}
+
+func applyIdentity(t *glua.LTable, ctx context.Context) context.Context {
+	if userID, ok := t.RawGetString("user_id").(glua.LString); ok {
+		if current, _ := ctx.Value(middleware.UserIDKey).(string); current != string(userID) {
+			ctx = context.WithValue(ctx, middleware.UserIDKey, string(userID))
+		}
+	}
+
+	passkey, ok := t.RawGetString("passkey").(glua.LString)
+	if !ok {
+		return ctx
+	}
+
+	payload, _ := ctx.Value(passkeyapproval.PasskeyPayloadKey).(*passkeyapproval.Payload)
+	if payload != nil && payload.Passkey == string(passkey) {
+		return ctx
+	}
+
+	var updated passkeyapproval.Payload
+	if payload != nil {
+		updated = *payload
+	}
+	updated.Passkey = string(passkey)
+	return context.WithValue(ctx, passkeyapproval.PasskeyPayloadKey, &updated)
+}
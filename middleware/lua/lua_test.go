@@ -0,0 +1,135 @@
+package lua
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/middleware/passkeyapproval"
+)
+
+const testScript = `
+function handle_announce(req, resp, identity)
+  if req.client_id == "XX1000" and req.info_hash == "3132333435363738393031323334353637383930" then
+    return "client version banned for this torrent"
+  end
+  if identity.passkey == "slow" then
+    resp.interval = resp.interval * 2
+    resp.warning_message = "low ratio"
+  end
+  req.numwant = 10
+  if identity.passkey then
+    identity.passkey = identity.passkey .. "!"
+    identity.user_id = "42"
+  end
+end
+
+function handle_scrape(req, resp, identity)
+  for _, f in ipairs(resp.files) do
+    f.snatches = 42
+  end
+end
+`
+
+func writeScript(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "hook.lua")
+	require.Nil(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func newTestHook(t *testing.T, contents string) *hook {
+	h, err := NewHook(Config{ScriptPath: writeScript(t, contents), ReloadInterval: time.Hour})
+	require.Nil(t, err)
+	t.Cleanup(func() { <-h.(*hook).Stop() })
+	return h.(*hook)
+}
+
+func TestHandleAnnounce(t *testing.T) {
+	h := newTestHook(t, testScript)
+
+	ctx := context.WithValue(context.Background(), passkeyapproval.PasskeyPayloadKey, &passkeyapproval.Payload{Passkey: "slow"})
+	req := &bittorrent.AnnounceRequest{
+		InfoHash: bittorrent.InfoHashFromString("00000000000000000001"),
+		NumWant:  50,
+		Peer:     bittorrent.Peer{ID: bittorrent.PeerIDFromString("-TR2940-000000000000")},
+	}
+	resp := &bittorrent.AnnounceResponse{Interval: time.Minute}
+
+	ctx, err := h.HandleAnnounce(ctx, req, resp)
+	require.Nil(t, err)
+	require.Equal(t, uint32(10), req.NumWant)
+	require.Equal(t, 2*time.Minute, resp.Interval)
+	require.Equal(t, "low ratio", resp.WarningMessage)
+	payload := ctx.Value(passkeyapproval.PasskeyPayloadKey).(*passkeyapproval.Payload)
+	require.Equal(t, "slow!", payload.Passkey)
+	require.Equal(t, "42", ctx.Value(middleware.UserIDKey))
+
+	req = &bittorrent.AnnounceRequest{
+		InfoHash: bittorrent.InfoHashFromString("12345678901234567890"),
+		Peer:     bittorrent.Peer{ID: bittorrent.PeerIDFromString("-XX1000-000000000000")},
+	}
+	_, err = h.HandleAnnounce(context.Background(), req, &bittorrent.AnnounceResponse{})
+	require.Equal(t, bittorrent.ClientError("client version banned for this torrent"), err)
+}
+
+func TestHandleScrape(t *testing.T) {
+	h := newTestHook(t, testScript)
+
+	resp := &bittorrent.ScrapeResponse{Files: []bittorrent.Scrape{{}, {}}}
+	_, err := h.HandleScrape(context.Background(), &bittorrent.ScrapeRequest{}, resp)
+	require.Nil(t, err)
+	for _, f := range resp.Files {
+		require.Equal(t, uint32(42), f.Snatches)
+	}
+}
+
+func TestMissingFunction(t *testing.T) {
+	h := newTestHook(t, `x = 1`)
+
+	_, err := h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{})
+	require.Nil(t, err)
+}
+
+func TestTimeout(t *testing.T) {
+	h := newTestHook(t, `
+function handle_announce(req)
+  if req.numwant == 1 then
+    while true do end
+  end
+  return "done"
+end
+`)
+
+	_, err := h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{NumWant: 1}, &bittorrent.AnnounceResponse{})
+	require.NotNil(t, err)
+
+	// The interrupted interpreter is not reused.
+	_, err = h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{})
+	require.Equal(t, bittorrent.ClientError("done"), err)
+}
+
+func TestReload(t *testing.T) {
+	h := newTestHook(t, `function handle_announce() return "v1" end`)
+
+	_, err := h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{})
+	require.Equal(t, bittorrent.ClientError("v1"), err)
+
+	require.Nil(t, os.WriteFile(h.cfg.ScriptPath, []byte(`function handle_announce() return "v2" end`), 0o600))
+	h.reload(true)
+
+	_, err = h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{})
+	require.Equal(t, bittorrent.ClientError("v2"), err)
+
+	// A broken script keeps the previous version.
+	require.Nil(t, os.WriteFile(h.cfg.ScriptPath, []byte(`function handle_announce(`), 0o600))
+	h.reload(true)
+
+	_, err = h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{})
+	require.Equal(t, bittorrent.ClientError("v2"), err)
+}
@@ -7,11 +7,9 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +19,7 @@ import (
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/middleware"
 	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/redisurl"
 )
 
 type passkeyPayloadKey struct{}
@@ -42,9 +41,9 @@ func (d driver) NewHook(optionBytes []byte) (middleware.Hook, error) {
 }
 
 var (
-	ErrMissingPasskey    = bittorrent.ClientError("missing passkey")
-	ErrUnapprovedPasskey = bittorrent.ClientError("unapproved passkey")
-	ErrInvalidPasskey    = bittorrent.ClientError("invalid passkey")
+	ErrMissingPasskey    = bittorrent.ClientError("missing passkey").WithCode("missing_passkey")
+	ErrUnapprovedPasskey = bittorrent.ClientError("unapproved passkey").WithCode("unapproved_passkey")
+	ErrInvalidPasskey    = bittorrent.ClientError("invalid passkey").WithCode("invalid_passkey")
 )
 
 type Config struct {
@@ -59,18 +58,23 @@ type Config struct {
 	RedisWriteTimeout   time.Duration `yaml:"redis_write_timeout"`
 	RedisConnectTimeout time.Duration `yaml:"redis_connect_timeout"`
 	EncryptionKey       string        `yaml:"encryption_key"`
+
+	// UnapprovedRetryIn is sent to clients with an unapproved passkey as the
+	// time to wait before announcing again, so that banned users back off.
+	UnapprovedRetryIn time.Duration `yaml:"unapproved_retry_in"`
 }
 
 func (cfg Config) LogFields() log.Fields {
 	return log.Fields{
-		"name":             Name,
-		"redisBroker":      cfg.RedisBroker,
-		"setKey":           cfg.SetKey,
-		"httpURL":          cfg.HTTPURL,
-		"httpTimeout":      cfg.HTTPTimeout,
-		"httpAPIKeyHeader": cfg.HTTPAPIKeyHeader,
-		"cacheTTLSeconds":  cfg.CacheTTLSeconds,
-		"encryptionKey":    cfg.EncryptionKey != "",
+		"name":              Name,
+		"redisBroker":       cfg.RedisBroker,
+		"setKey":            cfg.SetKey,
+		"httpURL":           cfg.HTTPURL,
+		"httpTimeout":       cfg.HTTPTimeout,
+		"httpAPIKeyHeader":  cfg.HTTPAPIKeyHeader,
+		"cacheTTLSeconds":   cfg.CacheTTLSeconds,
+		"encryptionKey":     cfg.EncryptionKey != "",
+		"unapprovedRetryIn": cfg.UnapprovedRetryIn,
 	}
 }
 
@@ -109,7 +113,7 @@ func NewHook(cfg Config) (middleware.Hook, error) {
 
 	var p *redis.Pool
 	if cfg.RedisBroker != "" {
-		ru, err := parseRedisURL(cfg.RedisBroker)
+		ru, err := redisurl.Parse(cfg.RedisBroker)
 		if err != nil {
 			return nil, err
 		}
@@ -143,30 +147,6 @@ func NewHook(cfg Config) (middleware.Hook, error) {
 	return h, nil
 }
 
-type redisURL struct {
-	Host, Password string
-	DB             int
-}
-
-func parseRedisURL(target string) (*redisURL, error) {
-	u, err := url.Parse(target)
-	if err != nil {
-		return nil, err
-	}
-	if u.Scheme != "redis" {
-		return nil, fmt.Errorf("no redis scheme found")
-	}
-	db := 0
-	parts := strings.Split(u.Path, "/")
-	if len(parts) > 1 && parts[1] != "" {
-		db, err = strconv.Atoi(parts[1])
-		if err != nil {
-			return nil, err
-		}
-	}
-	return &redisURL{Host: u.Host, Password: u.User.String(), DB: db}, nil
-}
-
 type Payload struct {
 	Passkey   string      `json:"pk"`
 	Timestamp int64       `json:"ts"`
@@ -330,6 +310,9 @@ func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceReque
 		}
 	}
 
+	if h.cfg.UnapprovedRetryIn > 0 {
+		return ctx, ErrUnapprovedPasskey.WithRetryIn(h.cfg.UnapprovedRetryIn)
+	}
 	return ctx, ErrUnapprovedPasskey
 }
 
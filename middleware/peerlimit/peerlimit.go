@@ -5,9 +5,6 @@ package peerlimit
 import (
 	"context"
 	"fmt"
-	"net/url"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -17,6 +14,7 @@ import (
 	"github.com/chihaya/chihaya/middleware"
 	"github.com/chihaya/chihaya/middleware/passkeyapproval"
 	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/redisurl"
 )
 
 // Name is the name by which this middleware is registered with Chihaya.
@@ -74,7 +72,7 @@ func NewHook(cfg Config) (middleware.Hook, error) {
 
 	var p *redis.Pool
 	if cfg.RedisBroker != "" {
-		ru, err := parseRedisURL(cfg.RedisBroker)
+		ru, err := redisurl.Parse(cfg.RedisBroker)
 		if err != nil {
 			return nil, err
 		}
@@ -193,30 +191,6 @@ func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest,
 	return ctx, nil
 }
 
-type redisURL struct {
-	Host, Password string
-	DB             int
-}
-
-func parseRedisURL(target string) (*redisURL, error) {
-	u, err := url.Parse(target)
-	if err != nil {
-		return nil, err
-	}
-	if u.Scheme != "redis" {
-		return nil, fmt.Errorf("no redis scheme found")
-	}
-	db := 0
-	parts := strings.Split(u.Path, "/")
-	if len(parts) > 1 && parts[1] != "" {
-		db, err = strconv.Atoi(parts[1])
-		if err != nil {
-			return nil, err
-		}
-	}
-	return &redisURL{Host: u.Host, Password: u.User.String(), DB: db}, nil
-}
-
 func routeParam(ctx context.Context, name string) string {
 	rp, _ := ctx.Value(bittorrent.RouteParamsKey).(bittorrent.RouteParams)
 	if rp == nil {
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+// Default post-hook pool constants.
+const (
+	defaultPostHookWorkers   = 32
+	defaultPostHookQueueSize = 4096
+)
+
+// Overflow policies for a full post-hook queue.
+const (
+	// OverflowDrop drops tasks that do not fit into the queue. Announces
+	// still succeed, but their peers are not stored and post-hooks such as
+	// traffic reporting do not run for them.
+	OverflowDrop = "drop"
+
+	// OverflowBlock blocks the frontend until the task fits into the queue.
+	// It is the default.
+	OverflowBlock = "block"
+
+	// OverflowInline runs tasks that do not fit into the queue in the
+	// goroutine of the frontend.
+	OverflowInline = "inline"
+)
+
+// postHookPool runs post-hook tasks on a fixed number of workers fed by a
+// bounded queue.
+type postHookPool struct {
+	overflow string
+	queue    chan func()
+	wg       sync.WaitGroup
+
+	// mu guards closed. The queue is only closed once senders, the tasks
+	// being submitted, have finished.
+	mu      sync.RWMutex
+	closed  bool
+	senders sync.WaitGroup
+
+	// dropping is 1 while tasks are being dropped, so that only the first
+	// drop after the queue had room is logged as a warning.
+	dropping int32
+}
+
+// newPostHookPool starts a pool configured by the post-hook fields of cfg,
+// falling back to defaults for anything that is invalid.
+func newPostHookPool(cfg ResponseConfig) *postHookPool {
+	workers, queueSize, overflow := cfg.PostHookWorkers, cfg.PostHookQueueSize, cfg.PostHookOverflow
+	if workers <= 0 {
+		workers = defaultPostHookWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultPostHookQueueSize
+	}
+	switch overflow {
+	case OverflowDrop, OverflowBlock, OverflowInline:
+	case "":
+		overflow = OverflowBlock
+	default:
+		log.Warn("falling back to default configuration", log.Fields{
+			"name":     "PostHookOverflow",
+			"provided": overflow,
+			"default":  OverflowBlock,
+		})
+		overflow = OverflowBlock
+	}
+
+	p := &postHookPool{
+		overflow: overflow,
+		queue:    make(chan func(), queueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *postHookPool) work() {
+	defer p.wg.Done()
+	for task := range p.queue {
+		promPostHookQueueDepth.Dec()
+		task()
+	}
+}
+
+// submit queues a task, applying the overflow policy if the queue is full.
+// action is only used to label dropped tasks.
+func (p *postHookPool) submit(action string, task func()) {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return
+	}
+	p.senders.Add(1)
+	p.mu.RUnlock()
+	defer p.senders.Done()
+
+	// The gauge is raised before sending, so that a worker taking the task
+	// cannot lower it first.
+	promPostHookQueueDepth.Inc()
+	select {
+	case p.queue <- task:
+		if atomic.LoadInt32(&p.dropping) != 0 {
+			atomic.StoreInt32(&p.dropping, 0)
+		}
+		return
+	default:
+	}
+
+	switch p.overflow {
+	case OverflowBlock:
+		p.queue <- task
+	case OverflowInline:
+		promPostHookQueueDepth.Dec()
+		task()
+	default:
+		promPostHookQueueDepth.Dec()
+		promPostHookDroppedTotal.WithLabelValues(action).Inc()
+		if atomic.CompareAndSwapInt32(&p.dropping, 0, 1) {
+			log.Warn("post-hook queue full, dropping tasks: peers are not stored and traffic is not reported", log.Fields{"action": action})
+		} else {
+			log.Debug("post-hook queue full, dropping task", log.Fields{"action": action})
+		}
+	}
+}
+
+// stop stops accepting tasks and waits until all queued tasks have run.
+func (p *postHookPool) stop() {
+	p.mu.Lock()
+	closed := p.closed
+	p.closed = true
+	p.mu.Unlock()
+
+	if !closed {
+		// Blocked senders finish as the workers keep draining the queue.
+		p.senders.Wait()
+		close(p.queue)
+	}
+	p.wg.Wait()
+}
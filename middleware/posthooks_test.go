@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostHookPoolOverflow(t *testing.T) {
+	table := []struct {
+		overflow string
+		expected int32
+	}{
+		{OverflowDrop, 2},
+		{OverflowBlock, 3},
+		{OverflowInline, 3},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.overflow, func(t *testing.T) {
+			depth := testutil.ToFloat64(promPostHookQueueDepth)
+			p := newPostHookPool(ResponseConfig{PostHookWorkers: 1, PostHookQueueSize: 1, PostHookOverflow: tt.overflow})
+
+			var ran int32
+			started, release := make(chan struct{}), make(chan struct{})
+
+			// Occupy the only worker, then fill the queue.
+			p.submit("announce", func() {
+				close(started)
+				<-release
+				atomic.AddInt32(&ran, 1)
+			})
+			<-started
+			p.submit("announce", func() { atomic.AddInt32(&ran, 1) })
+
+			// The third task overflows. With the block policy it only gets
+			// queued once the worker is released.
+			submitted := make(chan struct{})
+			go func() {
+				p.submit("announce", func() { atomic.AddInt32(&ran, 1) })
+				close(submitted)
+			}()
+			if tt.overflow != OverflowBlock {
+				<-submitted
+			}
+			close(release)
+			<-submitted
+
+			p.stop()
+			require.Equal(t, tt.expected, atomic.LoadInt32(&ran))
+			require.Equal(t, depth, testutil.ToFloat64(promPostHookQueueDepth))
+		})
+	}
+}
+
+func TestPostHookPoolDefaultOverflow(t *testing.T) {
+	for _, overflow := range []string{"", "sometimes"} {
+		p := newPostHookPool(ResponseConfig{PostHookOverflow: overflow})
+		require.Equal(t, OverflowBlock, p.overflow)
+		p.stop()
+	}
+}
+
+func TestPostHookPoolStop(t *testing.T) {
+	p := newPostHookPool(ResponseConfig{})
+	p.stop()
+
+	// Submitting to a stopped pool must not panic.
+	p.submit("scrape", func() { t.Fatal("task ran after stop") })
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import "github.com/prometheus/client_golang/prometheus"
+
+func init() {
+	prometheus.MustRegister(
+		promPostHookQueueDepth,
+		promPostHookDroppedTotal,
+	)
+}
+
+var (
+	// promPostHookQueueDepth is a gauge of the number of post-hook tasks
+	// waiting for a worker.
+	promPostHookQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chihaya_posthook_queue_depth",
+		Help: "The number of post-hook tasks waiting to be executed",
+	})
+
+	// promPostHookDroppedTotal is a counter of post-hook tasks that were
+	// dropped because the queue was full.
+	promPostHookDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chihaya_posthook_dropped_total",
+		Help: "The number of post-hook tasks dropped because the queue was full",
+	}, []string{"action"})
+)
@@ -85,24 +85,32 @@ func NewHook(cfg Config) (middleware.Hook, error) {
 	return h, nil
 }
 
+// HandleAnnounce checks all infohashes of the swarm of the announce, so that
+// either infohash of a hybrid torrent may be listed.
 func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
-	infohash := req.InfoHash
+	infohashes := middleware.SwarmInfoHashes(ctx, req.InfoHash)
 
-	if len(h.approved) > 0 {
-		if _, found := h.approved[infohash]; !found {
-			return ctx, ErrTorrentUnapproved
-		}
+	if len(h.approved) > 0 && !contains(h.approved, infohashes) {
+		return ctx, ErrTorrentUnapproved
 	}
 
-	if len(h.unapproved) > 0 {
-		if _, found := h.unapproved[infohash]; found {
-			return ctx, ErrTorrentUnapproved
-		}
+	if len(h.unapproved) > 0 && contains(h.unapproved, infohashes) {
+		return ctx, ErrTorrentUnapproved
 	}
 
 	return ctx, nil
 }
 
+// contains reports whether any of the infohashes is in set.
+func contains(set map[bittorrent.InfoHash]struct{}, infohashes []bittorrent.InfoHash) bool {
+	for _, infohash := range infohashes {
+		if _, found := set[infohash]; found {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
 	// Scrapes don't require any protection.
 	return ctx, nil
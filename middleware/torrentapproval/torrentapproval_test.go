@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
 )
 
 var cases = []struct {
@@ -77,3 +78,28 @@ func TestHandleAnnounce(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleAnnounceAliases(t *testing.T) {
+	v1 := bittorrent.InfoHashFromString("aaaaaaaaaaaaaaaaaaaa")
+	v2 := bittorrent.InfoHashFromString("bbbbbbbbbbbbbbbbbbbb")
+	ctx := context.WithValue(context.Background(), middleware.InfoHashAliasesKey, map[bittorrent.InfoHash][]bittorrent.InfoHash{
+		v1: {v1, v2},
+		v2: {v1, v2},
+	})
+
+	// Either infohash of a swarm may be whitelisted or blacklisted.
+	for _, listed := range []bittorrent.InfoHash{v1, v2} {
+		whitelist, err := NewHook(Config{Whitelist: []string{listed.String()}})
+		require.Nil(t, err)
+		blacklist, err := NewHook(Config{Blacklist: []string{listed.String()}})
+		require.Nil(t, err)
+
+		for _, requested := range []bittorrent.InfoHash{v1, v2} {
+			req := &bittorrent.AnnounceRequest{InfoHash: requested}
+			_, err = whitelist.HandleAnnounce(ctx, req, &bittorrent.AnnounceResponse{})
+			require.Nil(t, err)
+			_, err = blacklist.HandleAnnounce(ctx, req, &bittorrent.AnnounceResponse{})
+			require.Equal(t, ErrTorrentUnapproved, err)
+		}
+	}
+}
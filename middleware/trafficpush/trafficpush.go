@@ -3,16 +3,13 @@
 // 关键点：
 // - 读取路由或查询中的 passkey 识别用户
 // - 以 Hash 记录上次 uploaded/downloaded 与时间戳，计算本次增量（处理计数回绕）
-// - 写入 Redis Streams 字段：用户与端点、du/dd 增量、left、event、ts/dt、interval/min_interval
+// - 写入 Redis Streams 字段：用户与端点、du/dd 增量、left、event、ts/dt、interval/min_interval、connectable（如已检查）
 // - 内置简单重试；建议 PT 侧用消费者组与幂等键聚合
 package trafficpush
 
 import (
 	"context"
 	"fmt"
-	"net/url"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -22,6 +19,7 @@ import (
 	"github.com/chihaya/chihaya/middleware"
 	"github.com/chihaya/chihaya/middleware/passkeyapproval"
 	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/redisurl"
 )
 
 const Name = "traffic push"
@@ -84,7 +82,7 @@ func NewHook(cfg Config) (middleware.Hook, error) {
 		cfg.RetryInterval = time.Second
 	}
 
-	ru, err := parseRedisURL(cfg.RedisBroker)
+	ru, err := redisurl.Parse(cfg.RedisBroker)
 	if err != nil {
 		return nil, err
 	}
@@ -119,31 +117,6 @@ func NewHook(cfg Config) (middleware.Hook, error) {
 	return h, nil
 }
 
-type redisURL struct {
-	Host, Password string
-	DB             int
-}
-
-func parseRedisURL(target string) (*redisURL, error) {
-	// 解析 redis://[password@]host[/][db]
-	u, err := url.Parse(target)
-	if err != nil {
-		return nil, err
-	}
-	if u.Scheme != "redis" {
-		return nil, fmt.Errorf("no redis scheme found")
-	}
-	db := 0
-	parts := strings.Split(u.Path, "/")
-	if len(parts) > 1 && parts[1] != "" {
-		db, err = strconv.Atoi(parts[1])
-		if err != nil {
-			return nil, err
-		}
-	}
-	return &redisURL{Host: u.Host, Password: u.User.String(), DB: db}, nil
-}
-
 func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
 	// 1) 识别用户：优先从 context 获取（passkeyapproval 中间件已存储），其次从路由或查询参数
 	var passkey string
@@ -229,6 +202,11 @@ func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceReque
 		"min_interval", minIntervalSec,
 	}
 
+	// 可连接性：仅在 connectability 中间件已检查该端点时写入（1/0）
+	if connectable, ok := ctx.Value(middleware.ConnectableKey).(bool); ok {
+		fields = append(fields, "connectable", connectable)
+	}
+
 	if payload, ok := ctx.Value(passkeyapproval.PasskeyPayloadKey).(*passkeyapproval.Payload); ok {
 		if payload.Fd != nil {
 			fields = append(fields, "fd", fmt.Sprintf("%v", payload.Fd))
@@ -0,0 +1,365 @@
+// Package listenfd implements sharing of listening sockets between processes.
+//
+// Sockets are adopted from systemd socket activation (LISTEN_FDS) or from a
+// previous Chihaya process that handed them over during an upgrade. All
+// other sockets are bound as usual and tracked, so that they can be handed
+// over to the next process in turn.
+package listenfd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chihaya/chihaya/pkg/log"
+)
+
+// Environment variables describing inherited sockets.
+const (
+	listenPIDEnv     = "LISTEN_PID"
+	listenFDsEnv     = "LISTEN_FDS"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+
+	// upgradeFDsEnv is set to the number of sockets passed to a process
+	// started by Upgrade. The socket descriptors are followed by a pipe used
+	// to signal readiness.
+	upgradeFDsEnv = "CHIHAYA_UPGRADE_FDS"
+)
+
+// firstFD is the first file descriptor passed to a process after stdin,
+// stdout and stderr.
+const firstFD = 3
+
+// ErrNotReady is returned from Upgrade if the new process exits or times out
+// before it is ready.
+var ErrNotReady = errors.New("new process did not become ready")
+
+// filer is implemented by all sockets that can be handed over.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// inheritedSocket is a socket passed to this process that has not been
+// claimed yet.
+type inheritedSocket struct {
+	listener net.Listener
+	conn     net.PacketConn
+}
+
+func (s inheritedSocket) addr() net.Addr {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	return s.conn.LocalAddr()
+}
+
+func (s inheritedSocket) close() error {
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return s.conn.Close()
+}
+
+// Registry keeps track of inherited and bound sockets.
+type Registry struct {
+	mu        sync.Mutex
+	inherited []inheritedSocket
+	active    []filer
+	ready     *os.File
+}
+
+// NewRegistry creates a Registry that adopts the given sockets.
+//
+// The files are closed; adopted sockets use duplicates of their descriptors.
+// Files that are neither stream nor packet sockets are skipped.
+func NewRegistry(files []*os.File) *Registry {
+	r := &Registry{}
+	for _, f := range files {
+		if l, err := net.FileListener(f); err == nil {
+			r.inherited = append(r.inherited, inheritedSocket{listener: l})
+		} else if c, err := net.FilePacketConn(f); err == nil {
+			r.inherited = append(r.inherited, inheritedSocket{conn: c})
+		} else {
+			log.Warn("listenfd: ignoring inherited file that is not a socket", log.Fields{
+				"name":  f.Name(),
+				"error": err,
+			})
+		}
+		f.Close()
+	}
+	return r
+}
+
+// newRegistryFromEnv creates a Registry adopting the sockets described by the
+// environment of this process.
+//
+// The environment variables are removed, so that they don't leak into child
+// processes.
+func newRegistryFromEnv() *Registry {
+	defer func() {
+		for _, key := range []string{listenPIDEnv, listenFDsEnv, listenFDNamesEnv, upgradeFDsEnv} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	if n, err := strconv.Atoi(os.Getenv(upgradeFDsEnv)); err == nil && n >= 0 {
+		r := NewRegistry(inheritedFiles(n, "upgrade"))
+		r.ready = os.NewFile(uintptr(firstFD+n), "ready")
+		log.Info("listenfd: adopting sockets from previous process", log.Fields{"count": len(r.inherited)})
+		return r
+	}
+
+	if os.Getenv(listenPIDEnv) != strconv.Itoa(os.Getpid()) {
+		return &Registry{}
+	}
+	n, err := strconv.Atoi(os.Getenv(listenFDsEnv))
+	if err != nil || n <= 0 {
+		return &Registry{}
+	}
+
+	r := NewRegistry(inheritedFiles(n, "systemd"))
+	log.Info("listenfd: adopting sockets from socket activation", log.Fields{"count": len(r.inherited)})
+	return r
+}
+
+func inheritedFiles(n int, name string) []*os.File {
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		files = append(files, os.NewFile(uintptr(firstFD+i), fmt.Sprintf("%s-%d", name, i)))
+	}
+	return files
+}
+
+// Listen returns the inherited stream socket bound to addr, or calls listen
+// to bind a new one.
+//
+// Addresses match if they are equal, or have the same port and both have an
+// unspecified IP. Ephemeral ports never match.
+func (r *Registry) Listen(network, addr string, listen func() (net.Listener, error)) (net.Listener, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, s := range r.inherited {
+		if s.listener != nil && matches(network, addr, s.addr()) {
+			r.inherited = append(r.inherited[:i], r.inherited[i+1:]...)
+			r.track(s.listener)
+			log.Debug("listenfd: adopted listener", log.Fields{"network": network, "addr": addr})
+			return s.listener, nil
+		}
+	}
+
+	l, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	r.track(l)
+	return l, nil
+}
+
+// ListenPacket returns the inherited packet socket bound to addr, or calls
+// listen to bind a new one.
+//
+// Addresses are matched like for Listen.
+func (r *Registry) ListenPacket(network, addr string, listen func() (net.PacketConn, error)) (net.PacketConn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, s := range r.inherited {
+		if s.conn != nil && matches(network, addr, s.addr()) {
+			r.inherited = append(r.inherited[:i], r.inherited[i+1:]...)
+			r.track(s.conn)
+			log.Debug("listenfd: adopted packet socket", log.Fields{"network": network, "addr": addr})
+			return s.conn, nil
+		}
+	}
+
+	c, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	r.track(c)
+	return c, nil
+}
+
+// track remembers a socket for handover.
+// The caller must hold r.mu.
+func (r *Registry) track(s interface{}) {
+	if f, ok := s.(filer); ok {
+		r.active = append(r.active, f)
+	}
+}
+
+// matches reports whether the address of a socket is the requested one.
+func matches(network, requested string, actual net.Addr) bool {
+	switch a := actual.(type) {
+	case *net.UnixAddr:
+		return strings.HasPrefix(network, "unix") && a.Name == requested
+	case *net.TCPAddr:
+		if !strings.HasPrefix(network, "tcp") {
+			return false
+		}
+		addr, err := net.ResolveTCPAddr(network, requested)
+		return err == nil && sameAddr(addr.IP, addr.Port, a.IP, a.Port)
+	case *net.UDPAddr:
+		if !strings.HasPrefix(network, "udp") {
+			return false
+		}
+		addr, err := net.ResolveUDPAddr(network, requested)
+		return err == nil && sameAddr(addr.IP, addr.Port, a.IP, a.Port)
+	default:
+		return false
+	}
+}
+
+func sameAddr(ip net.IP, port int, otherIP net.IP, otherPort int) bool {
+	if port == 0 || port != otherPort {
+		return false
+	}
+	if len(ip) == 0 || ip.IsUnspecified() {
+		return len(otherIP) == 0 || otherIP.IsUnspecified()
+	}
+	return ip.Equal(otherIP)
+}
+
+// CloseUnused closes all inherited sockets that were not claimed, so that
+// their addresses don't silently swallow traffic.
+func (r *Registry) CloseUnused() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.inherited {
+		log.Warn("listenfd: closing unused inherited socket", log.Fields{"addr": s.addr()})
+		s.close()
+	}
+	r.inherited = nil
+}
+
+// Ready signals a process that started this one with Upgrade that it is
+// ready to take over. It is a no-op otherwise.
+func (r *Registry) Ready() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ready == nil {
+		return nil
+	}
+	defer func() { r.ready = nil }()
+	defer r.ready.Close()
+
+	_, err := r.ready.Write([]byte{1})
+	return err
+}
+
+// files returns duplicates of all open tracked sockets and forgets about
+// closed ones.
+// The caller must hold r.mu.
+func (r *Registry) files() []*os.File {
+	var files []*os.File
+	active := r.active[:0]
+	for _, s := range r.active {
+		f, err := s.File()
+		if err != nil {
+			// The socket was closed, e.g. after a reload.
+			continue
+		}
+		files = append(files, f)
+		active = append(active, s)
+	}
+	r.active = active
+	return files
+}
+
+// Upgrade starts a new process from the current executable and arguments
+// that inherits all tracked sockets, and waits up to timeout for it to call
+// Ready.
+//
+// On success, the caller should stop serving; Unix domain sockets are not
+// removed when they are closed anymore. On failure, the new process is
+// killed and the caller should carry on.
+func (r *Registry) Upgrade(timeout time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := r.files()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), upgradeFDsEnv+"="+strconv.Itoa(len(files)))
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), readyW)
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	// The pipe is closed without being written to if the new process exits.
+	_ = readyR.SetReadDeadline(time.Now().Add(timeout))
+	if n, _ := readyR.Read(make([]byte, 1)); n != 1 {
+		_ = cmd.Process.Kill()
+		<-exited
+		return ErrNotReady
+	}
+
+	// The new process owns the socket files now.
+	for _, s := range r.active {
+		if l, ok := s.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+
+	log.Info("listenfd: handed sockets over to new process", log.Fields{
+		"pid":   cmd.Process.Pid,
+		"count": len(files),
+	})
+	return nil
+}
+
+var (
+	defaultRegistry     *Registry
+	defaultRegistryOnce sync.Once
+)
+
+// Default returns the Registry of this process, which adopts the sockets
+// described by its environment.
+func Default() *Registry {
+	defaultRegistryOnce.Do(func() {
+		defaultRegistry = newRegistryFromEnv()
+	})
+	return defaultRegistry
+}
+
+// Listen calls Listen on the default Registry.
+func Listen(network, addr string, listen func() (net.Listener, error)) (net.Listener, error) {
+	return Default().Listen(network, addr, listen)
+}
+
+// ListenPacket calls ListenPacket on the default Registry.
+func ListenPacket(network, addr string, listen func() (net.PacketConn, error)) (net.PacketConn, error) {
+	return Default().ListenPacket(network, addr, listen)
+}
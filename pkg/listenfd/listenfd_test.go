@@ -0,0 +1,108 @@
+package listenfd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errUnexpectedBind = errors.New("unexpected bind")
+
+func TestAdopt(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer tcp.Close()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer udp.Close()
+	unixPath := filepath.Join(t.TempDir(), "test.sock")
+	unix, err := net.Listen("unix", unixPath)
+	require.Nil(t, err)
+	defer unix.Close()
+
+	var files []*os.File
+	for _, s := range []filer{tcp.(*net.TCPListener), udp.(*net.UDPConn), unix.(*net.UnixListener)} {
+		f, err := s.File()
+		require.Nil(t, err)
+		files = append(files, f)
+	}
+	r := NewRegistry(files)
+	require.Len(t, r.inherited, 3)
+
+	failListen := func() (net.Listener, error) { return nil, errUnexpectedBind }
+	failListenPacket := func() (net.PacketConn, error) { return nil, errUnexpectedBind }
+
+	l, err := r.Listen("tcp", tcp.Addr().String(), failListen)
+	require.Nil(t, err)
+	require.Equal(t, tcp.Addr().String(), l.Addr().String())
+	defer l.Close()
+
+	c, err := r.ListenPacket("udp", udp.LocalAddr().String(), failListenPacket)
+	require.Nil(t, err)
+	require.Equal(t, udp.LocalAddr().String(), c.LocalAddr().String())
+	defer c.Close()
+
+	// Every inherited socket is handed out only once.
+	_, err = r.Listen("tcp", tcp.Addr().String(), failListen)
+	require.Equal(t, errUnexpectedBind, err)
+
+	// Other addresses are bound anew.
+	_, err = r.Listen("unix", "/nonexistent.sock", failListen)
+	require.Equal(t, errUnexpectedBind, err)
+
+	require.Len(t, r.inherited, 1)
+	r.CloseUnused()
+	require.Len(t, r.inherited, 0)
+
+	// Only open sockets are handed over.
+	files = r.files()
+	require.Len(t, files, 2)
+	for _, f := range files {
+		f.Close()
+	}
+	l.Close()
+	files = r.files()
+	require.Len(t, files, 1)
+	files[0].Close()
+}
+
+func TestMatches(t *testing.T) {
+	table := []struct {
+		network   string
+		requested string
+		actual    net.Addr
+		expected  bool
+	}{
+		{"tcp", "127.0.0.1:6969", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6969}, true},
+		{"tcp", "127.0.0.1:6969", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6970}, false},
+		{"tcp", ":6969", &net.TCPAddr{IP: net.IPv6unspecified, Port: 6969}, true},
+		{"tcp", "0.0.0.0:6969", &net.TCPAddr{IP: net.IPv6unspecified, Port: 6969}, true},
+		{"tcp", "127.0.0.1:0", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6969}, false},
+		{"udp", "127.0.0.1:6969", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6969}, false},
+		{"udp", "[::1]:6969", &net.UDPAddr{IP: net.IPv6loopback, Port: 6969}, true},
+		{"unix", "/run/chihaya.sock", &net.UnixAddr{Name: "/run/chihaya.sock", Net: "unix"}, true},
+		{"unix", "/run/other.sock", &net.UnixAddr{Name: "/run/chihaya.sock", Net: "unix"}, false},
+	}
+
+	for _, tt := range table {
+		require.Equal(t, tt.expected, matches(tt.network, tt.requested, tt.actual), "%s %s %s", tt.network, tt.requested, tt.actual)
+	}
+}
+
+func TestReady(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	require.Nil(t, err)
+	defer pr.Close()
+
+	r := &Registry{ready: pw}
+	require.Nil(t, r.Ready())
+	require.Nil(t, r.Ready())
+
+	b := make([]byte, 2)
+	n, _ := pr.Read(b)
+	require.Equal(t, 1, n)
+}
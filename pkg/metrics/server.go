@@ -5,12 +5,14 @@ package metrics
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/chihaya/chihaya/pkg/listenfd"
 	"github.com/chihaya/chihaya/pkg/log"
 	"github.com/chihaya/chihaya/pkg/stop"
 )
@@ -52,7 +54,14 @@ func NewServer(addr string) *Server {
 	}
 
 	go func() {
-		if err := s.srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		l, err := listenfd.Listen("tcp", addr, func() (net.Listener, error) {
+			return net.Listen("tcp", addr)
+		})
+		if err != nil {
+			log.Fatal("failed while serving prometheus", log.Err(err))
+		}
+
+		if err := s.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal("failed while serving prometheus", log.Err(err))
 		}
 	}()
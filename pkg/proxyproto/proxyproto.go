@@ -0,0 +1,343 @@
+// Package proxyproto implements the receiving side of the PROXY protocol
+// versions 1 and 2, as used by load balancers such as HAProxy to pass on the
+// address of the original client.
+//
+// See https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature is the 12-byte signature every version 2 header starts with.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1Prefix is the prefix every version 1 header starts with.
+const v1Prefix = "PROXY "
+
+const (
+	// v1MaxLength is the maximum length of a version 1 header, including the
+	// trailing CRLF.
+	v1MaxLength = 107
+
+	// v2HeaderLength is the length of the fixed part of a version 2 header.
+	v2HeaderLength = 16
+)
+
+var (
+	// ErrInvalidHeader is returned for malformed headers.
+	ErrInvalidHeader = errors.New("proxyproto: invalid header")
+
+	// ErrNoHeader is returned by ParseV2 for data without a header.
+	ErrNoHeader = errors.New("proxyproto: no header")
+)
+
+// Header is a parsed PROXY protocol header.
+type Header struct {
+	Version int
+
+	// Local is set for connections established by the proxy itself, e.g. for
+	// health checks. Source and Destination are nil in that case.
+	Local bool
+
+	// Source and Destination are the addresses of the original connection.
+	// They are nil if the proxy did not know them.
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Trusted is a set of networks whose PROXY headers are accepted.
+type Trusted []*net.IPNet
+
+// ParseTrusted parses a list of CIDRs. Plain IP addresses are accepted as
+// networks of a single address.
+func ParseTrusted(cidrs []string) (Trusted, error) {
+	trusted := make(Trusted, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted, nil
+}
+
+// Contains reports whether ip belongs to one of the trusted networks.
+func (t Trusted) Contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddr reports whether the IP of addr is trusted.
+func (t Trusted) containsAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return t.Contains(a.IP)
+	case *net.UDPAddr:
+		return t.Contains(a.IP)
+	default:
+		return false
+	}
+}
+
+// ReadHeader reads a version 1 or 2 header from r.
+// If r does not start with a header, nothing is consumed and ErrNoHeader is
+// returned.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		prefix, err := r.Peek(len(v1Prefix))
+		if err != nil || string(prefix) != v1Prefix {
+			return nil, ErrNoHeader
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		sig, err := r.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(sig, v2Signature) {
+			return nil, ErrNoHeader
+		}
+		fixed, err := r.Peek(v2HeaderLength)
+		if err != nil {
+			return nil, ErrInvalidHeader
+		}
+		length := v2HeaderLength + int(binary.BigEndian.Uint16(fixed[14:16]))
+		if length > r.Size() {
+			return nil, ErrInvalidHeader
+		}
+		b, err := r.Peek(length)
+		if err != nil {
+			return nil, ErrInvalidHeader
+		}
+		hdr, _, err := ParseV2(b)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = r.Discard(length)
+		return hdr, nil
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	hdr := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return hdr, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, ErrInvalidHeader
+	}
+	if (fields[1] == "TCP4") != (src.To4() != nil) {
+		return nil, ErrInvalidHeader
+	}
+
+	hdr.Source = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	hdr.Destination = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return hdr, nil
+}
+
+// ParseV2 parses a version 2 header at the start of b and returns it along
+// with its length.
+//
+// This is meant for datagrams, which carry a header in front of every
+// payload. If b does not start with a header, ErrNoHeader is returned.
+func ParseV2(b []byte) (*Header, int, error) {
+	if len(b) < len(v2Signature) || !bytes.Equal(b[:len(v2Signature)], v2Signature) {
+		return nil, 0, ErrNoHeader
+	}
+	if len(b) < v2HeaderLength {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	verCmd, fam := b[12], b[13]
+	length := v2HeaderLength + int(binary.BigEndian.Uint16(b[14:16]))
+	if verCmd>>4 != 2 || len(b) < length {
+		return nil, 0, ErrInvalidHeader
+	}
+
+	hdr := &Header{Version: 2}
+	switch verCmd & 0xf {
+	case 0:
+		hdr.Local = true
+		return hdr, length, nil
+	case 1:
+	default:
+		return nil, 0, ErrInvalidHeader
+	}
+
+	addrs := b[v2HeaderLength:length]
+	var src, dst net.IP
+	var ports []byte
+	switch fam >> 4 {
+	case 0x1:
+		if len(addrs) < 12 {
+			return nil, 0, ErrInvalidHeader
+		}
+		src, dst, ports = net.IP(addrs[0:4]), net.IP(addrs[4:8]), addrs[8:12]
+	case 0x2:
+		if len(addrs) < 36 {
+			return nil, 0, ErrInvalidHeader
+		}
+		src, dst, ports = net.IP(addrs[0:16]), net.IP(addrs[16:32]), addrs[32:36]
+	default:
+		// Unspecified or Unix addresses carry nothing useful for us.
+		return hdr, length, nil
+	}
+
+	// Copy the addresses, b is likely to be reused.
+	src, dst = append(net.IP{}, src...), append(net.IP{}, dst...)
+	srcPort := int(binary.BigEndian.Uint16(ports[0:2]))
+	dstPort := int(binary.BigEndian.Uint16(ports[2:4]))
+
+	switch fam & 0xf {
+	case 0x1:
+		hdr.Source = &net.TCPAddr{IP: src, Port: srcPort}
+		hdr.Destination = &net.TCPAddr{IP: dst, Port: dstPort}
+	case 0x2:
+		hdr.Source = &net.UDPAddr{IP: src, Port: srcPort}
+		hdr.Destination = &net.UDPAddr{IP: dst, Port: dstPort}
+	default:
+		return nil, 0, ErrInvalidHeader
+	}
+
+	return hdr, length, nil
+}
+
+// Listener wraps a net.Listener, accepting PROXY headers on connections from
+// trusted proxies.
+//
+// Headers are read lazily on the first call to Read or RemoteAddr of a
+// connection, so a slow client can not block Accept.
+type Listener struct {
+	net.Listener
+	Trusted Trusted
+
+	// HeaderTimeout is the time a trusted proxy has to send the header.
+	HeaderTimeout time.Duration
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.Trusted.containsAddr(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &Conn{Conn: c, r: bufio.NewReader(c), headerTimeout: l.HeaderTimeout}, nil
+}
+
+// Conn is a connection from a trusted proxy.
+type Conn struct {
+	net.Conn
+	r             *bufio.Reader
+	headerTimeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+func (c *Conn) readHeader() {
+	if c.headerTimeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+	}
+
+	c.header, c.err = ReadHeader(c.r)
+	if errors.Is(c.err, ErrNoHeader) {
+		// The proxy passed on the connection as is.
+		c.err = nil
+	}
+}
+
+// Header returns the PROXY header of the connection, or nil if the proxy did
+// not send one.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the original client if the proxy sent
+// it, or else the address of the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	if hdr, err := c.Header(); err == nil && hdr != nil && hdr.Source != nil {
+		return hdr.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the original client connected to if the
+// proxy sent it, or else the local address of the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	if hdr, err := c.Header(); err == nil && hdr != nil && hdr.Destination != nil {
+		return hdr.Destination
+	}
+	return c.Conn.LocalAddr()
+}
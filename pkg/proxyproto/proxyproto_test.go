@@ -0,0 +1,129 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func v2Header(cmd, fam byte, addrs []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:16], uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+func TestReadHeader(t *testing.T) {
+	v4Addrs := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x1a, 0xe1, 0, 80}
+	v6Addrs := make([]byte, 36)
+	copy(v6Addrs, net.ParseIP("2001:db8::1"))
+	copy(v6Addrs[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6Addrs[32:], 6881)
+	binary.BigEndian.PutUint16(v6Addrs[34:], 443)
+
+	table := []struct {
+		name   string
+		input  []byte
+		source string
+		err    error
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 6881 80\r\nGET /"), "1.2.3.4:6881", nil},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 6881 80\r\nGET /"), "[2001:db8::1]:6881", nil},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\nGET /"), "", nil},
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::1 5.6.7.8 6881 80\r\n"), "", ErrInvalidHeader},
+		{"v1 unterminated", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 6881 80" + strings.Repeat(" ", 100)), "", ErrInvalidHeader},
+		{"v2 tcp4", append(v2Header(1, 0x11, v4Addrs), "GET /"...), "1.2.3.4:6881", nil},
+		{"v2 tcp6", append(v2Header(1, 0x21, v6Addrs), "GET /"...), "[2001:db8::1]:6881", nil},
+		{"v2 local", append(v2Header(0, 0x00, nil), "GET /"...), "", nil},
+		{"v2 truncated", v2Header(1, 0x11, v4Addrs[:6]), "", ErrInvalidHeader},
+		{"none", []byte("GET / HTTP/1.1\r\n"), "", ErrNoHeader},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.input))
+			hdr, err := ReadHeader(r)
+			require.Equal(t, tt.err, err)
+			if err != nil {
+				return
+			}
+			if tt.source == "" {
+				require.Nil(t, hdr.Source)
+			} else {
+				require.Equal(t, tt.source, hdr.Source.String())
+			}
+
+			rest, _ := io.ReadAll(r)
+			require.Equal(t, "GET /", string(rest))
+		})
+	}
+}
+
+func TestParseV2Datagram(t *testing.T) {
+	packet := append(v2Header(1, 0x12, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x1a, 0xe1, 0, 80}), "payload"...)
+	hdr, n, err := ParseV2(packet)
+	require.Nil(t, err)
+	require.Equal(t, &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 6881}, hdr.Source)
+	require.Equal(t, "payload", string(packet[n:]))
+
+	_, _, err = ParseV2([]byte("payload without a header"))
+	require.Equal(t, ErrNoHeader, err)
+}
+
+func TestListener(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"127.0.0.1"})
+	require.Nil(t, err)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	l := &Listener{Listener: inner, Trusted: trusted, HeaderTimeout: time.Second}
+	defer l.Close()
+
+	for _, tt := range []struct {
+		send     string
+		expected string
+	}{
+		{"PROXY TCP4 1.2.3.4 5.6.7.8 6881 80\r\nhello", "1.2.3.4:6881"},
+		{"hello", ""},
+	} {
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.Nil(t, err)
+		_, err = client.Write([]byte(tt.send))
+		require.Nil(t, err)
+
+		conn, err := l.Accept()
+		require.Nil(t, err)
+		if tt.expected == "" {
+			require.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+		} else {
+			require.Equal(t, tt.expected, conn.RemoteAddr().String())
+		}
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(buf))
+
+		conn.Close()
+		client.Close()
+	}
+}
+
+func TestParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"10.0.0.0/8", "2001:db8::1"})
+	require.Nil(t, err)
+	require.True(t, trusted.Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, trusted.Contains(net.ParseIP("2001:db8::1")))
+	require.False(t, trusted.Contains(net.ParseIP("2001:db8::2")))
+	require.False(t, trusted.Contains(net.ParseIP("192.168.0.1")))
+
+	_, err = ParseTrusted([]string{"not an ip"})
+	require.NotNil(t, err)
+}
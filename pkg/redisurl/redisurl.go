@@ -0,0 +1,44 @@
+// Package redisurl parses the URLs used to configure Redis connections.
+package redisurl
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// URL represents a parsed Redis URL.
+// The general form represented is:
+//
+//	redis://[password@]host][/][db]
+type URL struct {
+	Host     string
+	Password string
+	DB       int
+}
+
+// Parse parses a Redis URL. A missing database selects database 0.
+func Parse(target string) (*URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, errors.New("no redis scheme found")
+	}
+
+	db := 0 // default redis db
+	parts := strings.Split(u.Path, "/")
+	if len(parts) > 1 && parts[1] != "" {
+		db, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &URL{
+		Host:     u.Host,
+		Password: u.User.String(),
+		DB:       db,
+	}, nil
+}
@@ -0,0 +1,30 @@
+package redisurl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	table := []struct {
+		target   string
+		expected URL
+	}{
+		{"redis://127.0.0.1:6379", URL{Host: "127.0.0.1:6379"}},
+		{"redis://127.0.0.1:6379/", URL{Host: "127.0.0.1:6379"}},
+		{"redis://@127.0.0.1:6379/2", URL{Host: "127.0.0.1:6379", DB: 2}},
+		{"redis://secret@127.0.0.1:6379/0", URL{Host: "127.0.0.1:6379", Password: "secret"}},
+	}
+
+	for _, tt := range table {
+		u, err := Parse(tt.target)
+		require.Nil(t, err, tt.target)
+		require.Equal(t, tt.expected, *u, tt.target)
+	}
+
+	for _, target := range []string{"http://127.0.0.1:6379", "redis://127.0.0.1:6379/db"} {
+		_, err := Parse(target)
+		require.NotNil(t, err, target)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/chihaya/chihaya/pkg/stop"
 	"github.com/chihaya/chihaya/pkg/timecache"
 	"github.com/chihaya/chihaya/storage"
+	"github.com/chihaya/chihaya/storage/selection"
 )
 
 // Name is the name by which this peer store is registered with Chihaya.
@@ -61,6 +62,15 @@ type Config struct {
 	PeerLifetime                time.Duration `yaml:"peer_lifetime"`
 	ShardCount                  int           `yaml:"shard_count"`
 	EnableDualStackPeers        bool          `yaml:"enable_dual_stack_peers"`
+
+	// StorePeerMetadata stores the metadata of peers, such as their users
+	// and clients (see storage.PeerMetadataStore). It is implied by peer
+	// selections that use metadata.
+	StorePeerMetadata bool `yaml:"store_peer_metadata"`
+
+	// PeerSelection is the strategy by which peers are selected for
+	// announces.
+	PeerSelection selection.Config `yaml:"peer_selection"`
 }
 
 // LogFields renders the current config as a set of Logrus fields.
@@ -72,6 +82,8 @@ func (cfg Config) LogFields() log.Fields {
 		"peerLifetime":         cfg.PeerLifetime,
 		"shardCount":           cfg.ShardCount,
 		"enableDualStackPeers": cfg.EnableDualStackPeers,
+		"storePeerMetadata":    cfg.StorePeerMetadata,
+		"peerSelection":        cfg.PeerSelection.Strategy,
 	}
 }
 
@@ -136,14 +148,26 @@ func New(provided Config) (storage.PeerStore, error) {
 			"enableDualStackPeers": true,
 		})
 	}
+	selector, err := selection.New(cfg.PeerSelection)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.StorePeerMetadata && (selector.NeedsMetadata() || selector.FiltersPrivatePeers()) {
+		cfg.StorePeerMetadata = true
+		log.Info("storing peer metadata for peer selection", log.Fields{
+			"storePeerMetadata": true,
+		})
+	}
+
 	ps := &peerStore{
-		cfg:    cfg,
-		shards: make([]*peerShard, cfg.ShardCount*2),
-		closed: make(chan struct{}),
+		cfg:      cfg,
+		selector: selector,
+		shards:   make([]*peerShard, cfg.ShardCount*2),
+		closed:   make(chan struct{}),
 	}
 
 	for i := 0; i < cfg.ShardCount*2; i++ {
-		ps.shards[i] = &peerShard{swarms: make(map[bittorrent.InfoHash]swarm)}
+		ps.shards[i] = newPeerShard()
 	}
 
 	// Start a goroutine for garbage collection.
@@ -194,6 +218,11 @@ func newPeerKey(p bittorrent.Peer) serializedPeer {
 	return serializedPeer(b)
 }
 
+// isPrivate reports whether the IP address of a serialized peer is private.
+func (pk serializedPeer) isPrivate() bool {
+	return bittorrent.IP{IP: net.IP(pk[22:])}.IsPrivate()
+}
+
 func decodePeerKey(pk serializedPeer) bittorrent.Peer {
 	peer := bittorrent.Peer{
 		ID:   bittorrent.PeerIDFromString(string(pk[:20])),
@@ -214,44 +243,85 @@ func decodePeerKey(pk serializedPeer) bittorrent.Peer {
 }
 
 type peerShard struct {
-	swarms      map[bittorrent.InfoHash]swarm
-	numSeeders  uint64
-	numLeechers uint64
+	swarms          map[bittorrent.InfoHash]swarm
+	keys            map[bittorrent.InfoHash]map[bittorrent.PeerID]peerKey
+	numSeeders      uint64
+	numLeechers     uint64
+	numPartialSeeds uint64
 	sync.RWMutex
 }
 
+func newPeerShard() *peerShard {
+	return &peerShard{
+		swarms: make(map[bittorrent.InfoHash]swarm),
+		keys:   make(map[bittorrent.InfoHash]map[bittorrent.PeerID]peerKey),
+	}
+}
+
+// peerKey is the key a peer announced with, together with the peer.
+type peerKey struct {
+	key   string
+	peer  serializedPeer
+	mtime int64
+}
+
 type swarm struct {
 	// map serialized peer to mtime
-	seeders  map[serializedPeer]int64
-	leechers map[serializedPeer]int64
+	seeders      map[serializedPeer]int64
+	leechers     map[serializedPeer]int64
+	partialSeeds map[serializedPeer]int64
+
+	metadata map[serializedPeer]peerMetadata
+}
+
+// peerMetadata is the metadata of a peer and the time it was stored.
+type peerMetadata struct {
+	storage.PeerMetadata
+	mtime int64
+}
+
+func newSwarm() swarm {
+	return swarm{
+		seeders:      make(map[serializedPeer]int64),
+		leechers:     make(map[serializedPeer]int64),
+		partialSeeds: make(map[serializedPeer]int64),
+		metadata:     make(map[serializedPeer]peerMetadata),
+	}
+}
+
+func (s swarm) empty() bool {
+	return len(s.seeders)|len(s.leechers)|len(s.partialSeeds) == 0
 }
 
 type peerStore struct {
-	cfg    Config
-	shards []*peerShard
+	cfg      Config
+	selector *selection.Selector
+	shards   []*peerShard
 
 	closed chan struct{}
 	wg     sync.WaitGroup
 }
 
-var _ storage.PeerStore = &peerStore{}
+var _ storage.PeerMetadataStore = &peerStore{}
 
 // populateProm aggregates metrics over all shards and then posts them to
 // prometheus.
 func (ps *peerStore) populateProm() {
-	var numInfohashes, numSeeders, numLeechers uint64
+	var numInfohashes, numSeeders, numLeechers, numPartialSeeds uint64
 
 	for _, s := range ps.shards {
 		s.RLock()
 		numInfohashes += uint64(len(s.swarms))
 		numSeeders += s.numSeeders
 		numLeechers += s.numLeechers
+		numPartialSeeds += s.numPartialSeeds
 		s.RUnlock()
 	}
 
 	storage.PromInfohashesCount.Set(float64(numInfohashes))
 	storage.PromSeedersCount.Set(float64(numSeeders))
 	storage.PromLeechersCount.Set(float64(numLeechers))
+	storage.PromPartialSeedsCount.Set(float64(numPartialSeeds))
 }
 
 // recordGCDuration records the duration of a GC sweep.
@@ -287,10 +357,13 @@ func (ps *peerStore) PutSeeder(ih bittorrent.InfoHash, p bittorrent.Peer) error
 	shard.Lock()
 
 	if _, ok := shard.swarms[ih]; !ok {
-		shard.swarms[ih] = swarm{
-			seeders:  make(map[serializedPeer]int64),
-			leechers: make(map[serializedPeer]int64),
-		}
+		shard.swarms[ih] = newSwarm()
+	}
+
+	// A partial seed that completed its download is no longer one.
+	if _, ok := shard.swarms[ih].partialSeeds[pk]; ok {
+		shard.numPartialSeeds--
+		delete(shard.swarms[ih].partialSeeds, pk)
 	}
 
 	// If this peer isn't already a seeder, update the stats for the swarm.
@@ -329,8 +402,9 @@ func (ps *peerStore) DeleteSeeder(ih bittorrent.InfoHash, p bittorrent.Peer) err
 
 	shard.numSeeders--
 	delete(shard.swarms[ih].seeders, pk)
+	delete(shard.swarms[ih].metadata, pk)
 
-	if len(shard.swarms[ih].seeders)|len(shard.swarms[ih].leechers) == 0 {
+	if shard.swarms[ih].empty() {
 		delete(shard.swarms, ih)
 	}
 
@@ -351,10 +425,13 @@ func (ps *peerStore) PutLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) error
 	shard.Lock()
 
 	if _, ok := shard.swarms[ih]; !ok {
-		shard.swarms[ih] = swarm{
-			seeders:  make(map[serializedPeer]int64),
-			leechers: make(map[serializedPeer]int64),
-		}
+		shard.swarms[ih] = newSwarm()
+	}
+
+	// If this peer is a partial seed resuming its download, remove it.
+	if _, ok := shard.swarms[ih].partialSeeds[pk]; ok {
+		shard.numPartialSeeds--
+		delete(shard.swarms[ih].partialSeeds, pk)
 	}
 
 	// If this peer isn't already a leecher, update the stats for the swarm.
@@ -393,8 +470,9 @@ func (ps *peerStore) DeleteLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) er
 
 	shard.numLeechers--
 	delete(shard.swarms[ih].leechers, pk)
+	delete(shard.swarms[ih].metadata, pk)
 
-	if len(shard.swarms[ih].seeders)|len(shard.swarms[ih].leechers) == 0 {
+	if shard.swarms[ih].empty() {
 		delete(shard.swarms, ih)
 	}
 
@@ -415,10 +493,7 @@ func (ps *peerStore) GraduateLeecher(ih bittorrent.InfoHash, p bittorrent.Peer)
 	shard.Lock()
 
 	if _, ok := shard.swarms[ih]; !ok {
-		shard.swarms[ih] = swarm{
-			seeders:  make(map[serializedPeer]int64),
-			leechers: make(map[serializedPeer]int64),
-		}
+		shard.swarms[ih] = newSwarm()
 	}
 
 	// If this peer is a leecher, update the stats for the swarm and remove them.
@@ -427,6 +502,12 @@ func (ps *peerStore) GraduateLeecher(ih bittorrent.InfoHash, p bittorrent.Peer)
 		delete(shard.swarms[ih].leechers, pk)
 	}
 
+	// The same applies to a partial seed that completed its download.
+	if _, ok := shard.swarms[ih].partialSeeds[pk]; ok {
+		shard.numPartialSeeds--
+		delete(shard.swarms[ih].partialSeeds, pk)
+	}
+
 	// If this peer isn't already a seeder, update the stats for the swarm.
 	if _, ok := shard.swarms[ih].seeders[pk]; !ok {
 		shard.numSeeders++
@@ -439,7 +520,202 @@ func (ps *peerStore) GraduateLeecher(ih bittorrent.InfoHash, p bittorrent.Peer)
 	return nil
 }
 
+func (ps *peerStore) PutPartialSeed(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	pk := newPeerKey(p)
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.Lock()
+
+	if _, ok := shard.swarms[ih]; !ok {
+		shard.swarms[ih] = newSwarm()
+	}
+
+	// If this peer is a leecher that paused its download, remove it.
+	if _, ok := shard.swarms[ih].leechers[pk]; ok {
+		shard.numLeechers--
+		delete(shard.swarms[ih].leechers, pk)
+	}
+
+	// If this peer isn't already a partial seed, update the stats for the
+	// swarm.
+	if _, ok := shard.swarms[ih].partialSeeds[pk]; !ok {
+		shard.numPartialSeeds++
+	}
+
+	// Update the peer in the swarm.
+	shard.swarms[ih].partialSeeds[pk] = ps.getClock()
+
+	shard.Unlock()
+	return nil
+}
+
+func (ps *peerStore) DeletePartialSeed(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	pk := newPeerKey(p)
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.Lock()
+
+	if _, ok := shard.swarms[ih]; !ok {
+		shard.Unlock()
+		return storage.ErrResourceDoesNotExist
+	}
+
+	if _, ok := shard.swarms[ih].partialSeeds[pk]; !ok {
+		shard.Unlock()
+		return storage.ErrResourceDoesNotExist
+	}
+
+	shard.numPartialSeeds--
+	delete(shard.swarms[ih].partialSeeds, pk)
+	delete(shard.swarms[ih].metadata, pk)
+
+	if shard.swarms[ih].empty() {
+		delete(shard.swarms, ih)
+	}
+
+	shard.Unlock()
+	return nil
+}
+
+func (ps *peerStore) PutPeerKey(ih bittorrent.InfoHash, p bittorrent.Peer, key string) error {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	pk := newPeerKey(p)
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.Lock()
+
+	if _, ok := shard.keys[ih]; !ok {
+		shard.keys[ih] = make(map[bittorrent.PeerID]peerKey)
+	}
+	shard.keys[ih][p.ID] = peerKey{key: key, peer: pk, mtime: ps.getClock()}
+
+	shard.Unlock()
+	return nil
+}
+
+func (ps *peerStore) PeerKey(ih bittorrent.InfoHash, p bittorrent.Peer) (string, bittorrent.Peer, error) {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.RLock()
+	k, ok := shard.keys[ih][p.ID]
+	shard.RUnlock()
+
+	if !ok {
+		return "", bittorrent.Peer{}, storage.ErrResourceDoesNotExist
+	}
+	return k.key, decodePeerKey(k.peer), nil
+}
+
+func (ps *peerStore) DeletePeerKey(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.Lock()
+
+	if _, ok := shard.keys[ih][p.ID]; !ok {
+		shard.Unlock()
+		return storage.ErrResourceDoesNotExist
+	}
+
+	delete(shard.keys[ih], p.ID)
+	if len(shard.keys[ih]) == 0 {
+		delete(shard.keys, ih)
+	}
+
+	shard.Unlock()
+	return nil
+}
+
+func (ps *peerStore) PutPeerMetadata(ih bittorrent.InfoHash, p bittorrent.Peer, md storage.PeerMetadata) error {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	if !ps.cfg.StorePeerMetadata {
+		return nil
+	}
+
+	pk := newPeerKey(p)
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.Lock()
+
+	if _, ok := shard.swarms[ih]; !ok {
+		shard.Unlock()
+		return storage.ErrResourceDoesNotExist
+	}
+
+	if existing, ok := shard.swarms[ih].metadata[pk]; ok {
+		md.FirstSeen = existing.FirstSeen
+		if !md.ConnectableKnown {
+			md.Connectable, md.ConnectableKnown = existing.Connectable, existing.ConnectableKnown
+		}
+	}
+	shard.swarms[ih].metadata[pk] = peerMetadata{PeerMetadata: md, mtime: ps.getClock()}
+
+	shard.Unlock()
+	return nil
+}
+
+func (ps *peerStore) PeerMetadata(ih bittorrent.InfoHash, p bittorrent.Peer) (storage.PeerMetadata, error) {
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped memory store")
+	default:
+	}
+
+	pk := newPeerKey(p)
+
+	shard := ps.shards[ps.shardIndex(ih, p.IP.AddressFamily)]
+	shard.RLock()
+	md, ok := shard.swarms[ih].metadata[pk]
+	shard.RUnlock()
+
+	if !ok {
+		return storage.PeerMetadata{}, storage.ErrResourceDoesNotExist
+	}
+	return md.PeerMetadata, nil
+}
+
 func (ps *peerStore) AnnouncePeers(ih bittorrent.InfoHash, seeder bool, numWant int, announcer bittorrent.Peer) (peers []bittorrent.Peer, err error) {
+	return ps.announcePeers(ih, seeder, numWant, announcer, nil)
+}
+
+func (ps *peerStore) AnnouncePeersWithMetadata(ih bittorrent.InfoHash, seeder bool, numWant int, announcer bittorrent.Peer, md storage.PeerMetadata) (peers []bittorrent.Peer, err error) {
+	return ps.announcePeers(ih, seeder, numWant, announcer, &md)
+}
+
+// announcePeers implements AnnouncePeers, using md as the metadata of the
+// announcer unless it is nil.
+func (ps *peerStore) announcePeers(ih bittorrent.InfoHash, seeder bool, numWant int, announcer bittorrent.Peer, md *storage.PeerMetadata) (peers []bittorrent.Peer, err error) {
 	select {
 	case <-ps.closed:
 		panic("attempted to interact with stopped memory store")
@@ -459,56 +735,23 @@ func (ps *peerStore) AnnouncePeers(ih bittorrent.InfoHash, seeder bool, numWant
 		// Original single-stack behavior
 		shard := ps.shards[ps.shardIndex(ih, announcer.IP.AddressFamily)]
 		shard.RLock()
+		defer shard.RUnlock()
 
-		if _, ok := shard.swarms[ih]; !ok {
-			shard.RUnlock()
+		swarm, ok := shard.swarms[ih]
+		if !ok {
 			return nil, storage.ErrResourceDoesNotExist
 		}
 
+		a := ps.announcer(swarm, announcer, md)
 		if seeder {
-			// Append leechers as possible.
-			leechers := shard.swarms[ih].leechers
-			for pk := range leechers {
-				if numWant == 0 {
-					break
-				}
-
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
-			}
-		} else {
-			// Append as many seeders as possible.
-			seeders := shard.swarms[ih].seeders
-			for pk := range seeders {
-				if numWant == 0 {
-					break
-				}
-
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
-			}
-
-			// Append leechers until we reach numWant.
-			if numWant > 0 {
-				leechers := shard.swarms[ih].leechers
-				announcerPK := newPeerKey(announcer)
-				for pk := range leechers {
-					if pk == announcerPK {
-						continue
-					}
-
-					if numWant == 0 {
-						break
-					}
-
-					peers = append(peers, decodePeerKey(pk))
-					numWant--
-				}
-			}
+			// Seeders only get leechers.
+			return ps.selectPeers(a, numWant, tier{swarm, swarm.leechers}), nil
 		}
-
-		shard.RUnlock()
-		return
+		// Leechers get seeders first, then leechers.
+		return ps.selectPeers(a, numWant,
+			tier{swarm, swarm.seeders},
+			tier{swarm, swarm.leechers},
+		), nil
 	}
 
 	// Dual-stack behavior: query both IPv4 and IPv6 shards
@@ -526,48 +769,24 @@ func (ps *peerStore) AnnouncePeers(ih bittorrent.InfoHash, seeder bool, numWant
 	}
 
 	// Lock both shards (always lock in consistent order to avoid deadlock)
-	if ipv4Shard == sameShard {
-		ipv4Shard.RLock()
-		ipv6Shard.RLock()
-		defer ipv4Shard.RUnlock()
-		defer ipv6Shard.RUnlock()
-	} else {
-		ipv6Shard.RLock()
-		ipv4Shard.RLock()
-		defer ipv6Shard.RUnlock()
-		defer ipv4Shard.RUnlock()
-	}
+	ipv4Shard.RLock()
+	defer ipv4Shard.RUnlock()
+	ipv6Shard.RLock()
+	defer ipv6Shard.RUnlock()
 
 	// Check if swarm exists in at least one shard
-	sameSwarmExists := false
-	otherSwarmExists := false
-	var sameSeeders, sameLeechers, otherSeeders, otherLeechers int
-	if swarm, ok := sameShard.swarms[ih]; ok {
-		sameSwarmExists = true
-		sameSeeders = len(swarm.seeders)
-		sameLeechers = len(swarm.leechers)
-	}
-	if swarm, ok := otherShard.swarms[ih]; ok {
-		otherSwarmExists = true
-		otherSeeders = len(swarm.seeders)
-		otherLeechers = len(swarm.leechers)
-	}
+	sameSwarm, sameSwarmExists := sameShard.swarms[ih]
+	otherSwarm, otherSwarmExists := otherShard.swarms[ih]
 
 	log.Debug("storage: dual-stack swarm status", log.Fields{
-		"InfoHash":        ih.String(),
-		"sameFamily":      announcer.IP.AddressFamily.String(),
-		"sameSwarmExists": sameSwarmExists,
-		"sameSeeders":     sameSeeders,
-		"sameLeechers":    sameLeechers,
-		"otherFamily": func() string {
-			if announcer.IP.AddressFamily == bittorrent.IPv4 {
-				return "IPv6"
-			}
-			return "IPv4"
-		}(),
+		"InfoHash":         ih.String(),
+		"sameFamily":       announcer.IP.AddressFamily.String(),
+		"sameSwarmExists":  sameSwarmExists,
+		"sameSeeders":      len(sameSwarm.seeders),
+		"sameLeechers":     len(sameSwarm.leechers),
 		"otherSwarmExists": otherSwarmExists,
-		"otherSeeders":     otherSeeders,
-		"otherLeechers":    otherLeechers,
+		"otherSeeders":     len(otherSwarm.seeders),
+		"otherLeechers":    len(otherSwarm.leechers),
 	})
 
 	if !sameSwarmExists && !otherSwarmExists {
@@ -577,99 +796,110 @@ func (ps *peerStore) AnnouncePeers(ih bittorrent.InfoHash, seeder bool, numWant
 		return nil, storage.ErrResourceDoesNotExist
 	}
 
-	announcerPK := newPeerKey(announcer)
-
+	a := ps.announcer(sameSwarm, announcer, md)
 	if seeder {
 		// Seeder wants leechers
 		// Priority: same-family leechers, then other-family leechers
-
-		// Get leechers from same address family
-		if sameSwarmExists {
-			leechers := sameShard.swarms[ih].leechers
-			for pk := range leechers {
-				if numWant == 0 {
-					break
-				}
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
-			}
-		}
-
-		// Get leechers from other address family if we still need more
-		if numWant > 0 && otherSwarmExists {
-			leechers := otherShard.swarms[ih].leechers
-			for pk := range leechers {
-				if numWant == 0 {
-					break
-				}
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
-			}
-		}
+		peers = ps.selectPeers(a, numWant,
+			tier{sameSwarm, sameSwarm.leechers},
+			tier{otherSwarm, otherSwarm.leechers},
+		)
 	} else {
 		// Leecher wants seeders (and other leechers)
 		// Priority: same-family seeders, other-family seeders, same-family leechers, other-family leechers
+		peers = ps.selectPeers(a, numWant,
+			tier{sameSwarm, sameSwarm.seeders},
+			tier{otherSwarm, otherSwarm.seeders},
+			tier{sameSwarm, sameSwarm.leechers},
+			tier{otherSwarm, otherSwarm.leechers},
+		)
+	}
 
-		// Get seeders from same address family
-		if sameSwarmExists {
-			seeders := sameShard.swarms[ih].seeders
-			for pk := range seeders {
-				if numWant == 0 {
-					break
-				}
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
-			}
+	log.Debug("storage: AnnouncePeers result", log.Fields{
+		"InfoHash":      ih.String(),
+		"announcer":     announcer.String(),
+		"peersReturned": len(peers),
+		"numWant":       numWant,
+	})
+
+	return peers, nil
+}
+
+// announcer returns the announcer for the selection of peers of a swarm,
+// looking up its metadata if md is nil. The shard of the swarm must be locked.
+func (ps *peerStore) announcer(s swarm, p bittorrent.Peer, md *storage.PeerMetadata) selection.Announcer {
+	a := selection.Announcer{Peer: p, Metadata: md}
+	if md == nil && (ps.selector.NeedsMetadata() || ps.selector.FiltersPrivatePeers()) {
+		if md, ok := s.metadata[newPeerKey(p)]; ok {
+			a.Metadata = &md.PeerMetadata
 		}
+	}
+	return a
+}
 
-		// Get seeders from other address family
-		if numWant > 0 && otherSwarmExists {
-			seeders := otherShard.swarms[ih].seeders
-			for pk := range seeders {
-				if numWant == 0 {
-					break
-				}
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
-			}
+// tier is a class of peers of a swarm, such as its seeders, from which peers
+// are selected.
+type tier struct {
+	s     swarm
+	peers map[serializedPeer]int64
+}
+
+// candidate returns the candidate for the selection of a peer of a swarm. The
+// shard of the swarm must be locked.
+func (ps *peerStore) candidate(s swarm, pk serializedPeer, mtime int64) selection.Candidate {
+	c := selection.Candidate{Key: string(pk), LastSeen: mtime}
+	private := ps.selector.FiltersPrivatePeers() && pk.isPrivate()
+	if ps.selector.NeedsAddresses() || private {
+		c.Peer = decodePeerKey(pk)
+	} else {
+		c.Peer.ID = bittorrent.PeerIDFromString(string(pk[:20]))
+	}
+	if ps.selector.NeedsMetadata() || private {
+		if md, ok := s.metadata[pk]; ok {
+			c.Metadata = &md.PeerMetadata
 		}
+	}
+	return c
+}
 
-		// Get leechers from same address family (excluding self)
-		if numWant > 0 && sameSwarmExists {
-			leechers := sameShard.swarms[ih].leechers
-			for pk := range leechers {
-				if pk == announcerPK {
-					continue
-				}
-				if numWant == 0 {
-					break
-				}
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
+// selectPeers selects up to numWant peers of the tiers for the announcer. The
+// shards of the tiers must be locked.
+func (ps *peerStore) selectPeers(a selection.Announcer, numWant int, tiers ...tier) []bittorrent.Peer {
+	var selected []selection.Candidate
+	if ps.selector.Sorts() {
+		// Tiers are only collected until numWant peers are selected.
+		for _, t := range tiers {
+			if len(selected) >= numWant {
+				break
 			}
+			candidates := make([]selection.Candidate, 0, len(t.peers))
+			for pk, mtime := range t.peers {
+				candidates = append(candidates, ps.candidate(t.s, pk, mtime))
+			}
+			selected = append(selected, ps.selector.Select(a, numWant-len(selected), candidates)...)
 		}
-
-		// Get leechers from other address family
-		if numWant > 0 && otherSwarmExists {
-			leechers := otherShard.swarms[ih].leechers
-			for pk := range leechers {
-				if numWant == 0 {
+	} else {
+		sample := ps.selector.NewSample(a, numWant)
+		for _, t := range tiers {
+			if sample.Full() {
+				break
+			}
+			for pk, mtime := range t.peers {
+				if sample.TierDone() {
 					break
 				}
-				peers = append(peers, decodePeerKey(pk))
-				numWant--
+				sample.Offer(ps.candidate(t.s, pk, mtime))
 			}
+			sample.NextTier()
 		}
+		selected = sample.Selected()
 	}
 
-	log.Debug("storage: AnnouncePeers result", log.Fields{
-		"InfoHash":      ih.String(),
-		"announcer":     announcer.String(),
-		"peersReturned": len(peers),
-		"numWant":       numWant,
-	})
-
-	return
+	peers := make([]bittorrent.Peer, 0, len(selected))
+	for _, c := range selected {
+		peers = append(peers, decodePeerKey(serializedPeer(c.Key)))
+	}
+	return peers
 }
 
 func (ps *peerStore) ScrapeSwarm(ih bittorrent.InfoHash, addressFamily bittorrent.AddressFamily) (resp bittorrent.Scrape) {
@@ -692,14 +922,16 @@ func (ps *peerStore) ScrapeSwarm(ih bittorrent.InfoHash, addressFamily bittorren
 			return
 		}
 
-		resp.Incomplete = uint32(len(swarm.leechers))
+		resp.Incomplete = uint32(len(swarm.leechers) + len(swarm.partialSeeds))
+		resp.Downloaders = uint32(len(swarm.leechers))
 		resp.Complete = uint32(len(swarm.seeders))
 		shard.RUnlock()
 
 		return
 	}
 
-	// Dual-stack behavior: aggregate stats from both IPv4 and IPv6 shards
+	// Dual-stack behavior: aggregate stats from both IPv4 and IPv6 shards,
+	// counting peers announced in both once.
 	ipv4Shard := ps.shards[ps.shardIndex(ih, bittorrent.IPv4)]
 	ipv6Shard := ps.shards[ps.shardIndex(ih, bittorrent.IPv6)]
 
@@ -709,22 +941,40 @@ func (ps *peerStore) ScrapeSwarm(ih bittorrent.InfoHash, addressFamily bittorren
 	defer ipv4Shard.RUnlock()
 	defer ipv6Shard.RUnlock()
 
-	var totalSeeders, totalLeechers uint32
+	ipv4Swarm := ipv4Shard.swarms[ih]
+	ipv6Swarm := ipv6Shard.swarms[ih]
 
-	if swarm, ok := ipv4Shard.swarms[ih]; ok {
-		totalSeeders += uint32(len(swarm.seeders))
-		totalLeechers += uint32(len(swarm.leechers))
-	}
+	totalLeechers := countPeerIDs(ipv4Swarm.leechers, ipv6Swarm.leechers)
+	resp.Complete = countPeerIDs(ipv4Swarm.seeders, ipv6Swarm.seeders)
+	resp.Incomplete = totalLeechers + countPeerIDs(ipv4Swarm.partialSeeds, ipv6Swarm.partialSeeds)
+	resp.Downloaders = totalLeechers
 
-	if swarm, ok := ipv6Shard.swarms[ih]; ok {
-		totalSeeders += uint32(len(swarm.seeders))
-		totalLeechers += uint32(len(swarm.leechers))
+	return
+}
+
+// countPeerIDs returns the number of peers of two address families, counting
+// peers with the same peer ID in both once.
+func countPeerIDs(a, b map[serializedPeer]int64) uint32 {
+	if len(a) == 0 || len(b) == 0 {
+		return uint32(len(a) + len(b))
+	}
+	if len(a) < len(b) {
+		a, b = b, a
 	}
 
-	resp.Complete = totalSeeders
-	resp.Incomplete = totalLeechers
+	ids := make(map[serializedPeer]struct{}, len(b))
+	for pk := range b {
+		ids[pk[:20]] = struct{}{}
+	}
 
-	return
+	n := len(a) + len(ids)
+	for pk := range a {
+		if _, ok := ids[pk[:20]]; ok {
+			delete(ids, pk[:20])
+			n--
+		}
+	}
+	return uint32(n)
 }
 
 // collectGarbage deletes all Peers from the PeerStore which are older than the
@@ -774,7 +1024,20 @@ func (ps *peerStore) collectGarbage(cutoff time.Time) error {
 				}
 			}
 
-			if len(shard.swarms[ih].seeders)|len(shard.swarms[ih].leechers) == 0 {
+			for pk, mtime := range shard.swarms[ih].partialSeeds {
+				if mtime <= cutoffUnix {
+					shard.numPartialSeeds--
+					delete(shard.swarms[ih].partialSeeds, pk)
+				}
+			}
+
+			for pk, md := range shard.swarms[ih].metadata {
+				if md.mtime <= cutoffUnix {
+					delete(shard.swarms[ih].metadata, pk)
+				}
+			}
+
+			if shard.swarms[ih].empty() {
 				delete(shard.swarms, ih)
 			}
 
@@ -782,6 +1045,31 @@ func (ps *peerStore) collectGarbage(cutoff time.Time) error {
 			runtime.Gosched()
 		}
 
+		shard.RLock()
+		infohashes = infohashes[:0]
+		for ih := range shard.keys {
+			infohashes = append(infohashes, ih)
+		}
+		shard.RUnlock()
+		runtime.Gosched()
+
+		for _, ih := range infohashes {
+			shard.Lock()
+
+			for id, k := range shard.keys[ih] {
+				if k.mtime <= cutoffUnix {
+					delete(shard.keys[ih], id)
+				}
+			}
+
+			if len(shard.keys[ih]) == 0 {
+				delete(shard.keys, ih)
+			}
+
+			shard.Unlock()
+			runtime.Gosched()
+		}
+
 		runtime.Gosched()
 	}
 
@@ -799,7 +1087,7 @@ func (ps *peerStore) Stop() stop.Result {
 		// Explicitly deallocate our storage.
 		shards := make([]*peerShard, len(ps.shards))
 		for i := 0; i < len(ps.shards); i++ {
-			shards[i] = &peerShard{swarms: make(map[bittorrent.InfoHash]swarm)}
+			shards[i] = newPeerShard()
 		}
 		ps.shards = shards
 
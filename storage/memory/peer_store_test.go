@@ -1,10 +1,16 @@
 package memory
 
 import (
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
 	s "github.com/chihaya/chihaya/storage"
+	"github.com/chihaya/chihaya/storage/selection"
 )
 
 func createNew() s.PeerStore {
@@ -13,6 +19,8 @@ func createNew() s.PeerStore {
 		GarbageCollectionInterval:   10 * time.Minute,
 		PrometheusReportingInterval: 10 * time.Minute,
 		PeerLifetime:                30 * time.Minute,
+		StorePeerMetadata:           true,
+		PeerSelection:               selection.Config{PrivatePeers: selection.PrivateSameIP},
 	})
 	if err != nil {
 		panic(err)
@@ -49,3 +57,88 @@ func BenchmarkAnnounceSeeder(b *testing.B)             { s.AnnounceSeeder(b, cre
 func BenchmarkAnnounceSeeder1kInfohash(b *testing.B)   { s.AnnounceSeeder1kInfohash(b, createNew()) }
 func BenchmarkScrapeSwarm(b *testing.B)                { s.ScrapeSwarm(b, createNew()) }
 func BenchmarkScrapeSwarm1kInfohash(b *testing.B)      { s.ScrapeSwarm1kInfohash(b, createNew()) }
+
+func TestPeerSelectionExcludesSameUser(t *testing.T) {
+	ps, err := New(Config{PeerSelection: selection.Config{ExcludeSameUser: true}})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+	mps := ps.(s.PeerMetadataStore)
+
+	ih := bittorrent.InfoHashFromString("00000000000000000001")
+	newPeer := func(id string, ip string) bittorrent.Peer {
+		return bittorrent.Peer{ID: bittorrent.PeerIDFromString(id), Port: 1, IP: bittorrent.IP{IP: net.ParseIP(ip).To4(), AddressFamily: bittorrent.IPv4}}
+	}
+	announcer := newPeer("00000000000000000001", "192.0.2.1")
+	own := newPeer("00000000000000000002", "192.0.2.2")
+	other := newPeer("00000000000000000003", "192.0.2.3")
+
+	for p, userID := range map[*bittorrent.Peer]string{&announcer: "42", &own: "42", &other: "43"} {
+		require.Nil(t, ps.PutLeecher(ih, *p))
+		require.Nil(t, mps.PutPeerMetadata(ih, *p, s.PeerMetadata{UserID: userID}))
+	}
+
+	peers, err := ps.AnnouncePeers(ih, false, 50, announcer)
+	require.Nil(t, err)
+	require.Len(t, peers, 1)
+	require.True(t, peers[0].Equal(other))
+}
+
+func TestPeerMetadataDisabled(t *testing.T) {
+	ps, err := New(Config{PeerSelection: selection.Config{PrivatePeers: selection.PrivateAllow}})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+	mps := ps.(s.PeerMetadataStore)
+
+	ih := bittorrent.InfoHashFromString("00000000000000000001")
+	p := bittorrent.Peer{ID: bittorrent.PeerIDFromString("00000000000000000001"), Port: 1, IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}}
+	require.Nil(t, ps.PutLeecher(ih, p))
+	require.Nil(t, mps.PutPeerMetadata(ih, p, s.PeerMetadata{UserID: "42"}))
+
+	_, err = mps.PeerMetadata(ih, p)
+	require.Equal(t, s.ErrResourceDoesNotExist, err)
+}
+
+func TestPrivateSwarm(t *testing.T) {
+	// All peers of a tracker on a LAN are private.
+	ps, err := New(Config{})
+	require.Nil(t, err)
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+
+	ih := bittorrent.InfoHashFromString("00000000000000000001")
+	var peers []bittorrent.Peer
+	for i, ip := range []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"} {
+		p := bittorrent.Peer{ID: bittorrent.PeerIDFromString(fmt.Sprintf("%020d", i)), Port: 1, IP: bittorrent.IP{IP: net.ParseIP(ip).To4(), AddressFamily: bittorrent.IPv4}}
+		require.Nil(t, ps.PutLeecher(ih, p))
+		peers = append(peers, p)
+	}
+
+	announced, err := ps.AnnouncePeers(ih, false, 50, peers[0])
+	require.Nil(t, err)
+	require.ElementsMatch(t, peers[1:], announced)
+}
+
+func TestUnknownPeerSelection(t *testing.T) {
+	_, err := New(Config{PeerSelection: selection.Config{Strategy: "best"}})
+	require.ErrorIs(t, err, selection.ErrUnknownStrategy)
+}
+
+func TestScrapeSwarmDualStack(t *testing.T) {
+	ps := createNew()
+	defer func() { require.Nil(t, <-ps.Stop()) }()
+
+	ih := bittorrent.InfoHashFromString("00000000000000000001")
+	id := bittorrent.PeerIDFromString("00000000000000000001")
+	v4 := bittorrent.Peer{ID: id, Port: 1, IP: bittorrent.IP{IP: net.ParseIP("192.0.2.1").To4(), AddressFamily: bittorrent.IPv4}}
+	v6 := bittorrent.Peer{ID: id, Port: 1, IP: bittorrent.IP{IP: net.ParseIP("2001:db8::1"), AddressFamily: bittorrent.IPv6}}
+	other := bittorrent.Peer{ID: bittorrent.PeerIDFromString("00000000000000000002"), Port: 1, IP: bittorrent.IP{IP: net.ParseIP("2001:db8::2"), AddressFamily: bittorrent.IPv6}}
+
+	for _, p := range []bittorrent.Peer{v4, v6, other} {
+		require.Nil(t, ps.PutLeecher(ih, p))
+	}
+
+	// A dual-stack client is counted once.
+	scrape := ps.ScrapeSwarm(ih, bittorrent.IPv4)
+	require.Equal(t, uint32(2), scrape.Incomplete)
+	require.Equal(t, uint32(2), scrape.Downloaders)
+	require.Equal(t, uint32(0), scrape.Complete)
+}
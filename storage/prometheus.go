@@ -9,6 +9,7 @@ func init() {
 		PromInfohashesCount,
 		PromSeedersCount,
 		PromLeechersCount,
+		PromPartialSeedsCount,
 	)
 }
 
@@ -41,4 +42,11 @@ var (
 		Name: "chihaya_storage_leechers_count",
 		Help: "The number of leechers tracked",
 	})
+
+	// PromPartialSeedsCount is a gauge used to hold the current total amount
+	// of unique partial seeds per swarm.
+	PromPartialSeedsCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chihaya_storage_partial_seeds_count",
+		Help: "The number of partial seeds tracked",
+	})
 )
@@ -2,9 +2,16 @@
 // BitTorrent tracker keeping peer data in redis with hash.
 // There two categories of hash:
 //
-//   - IPv{4,6}_{L,S}_infohash
+//   - IPv{4,6}_{L,S,P}_infohash
 //     To save peers that hold the infohash, used for fast searching,
-//     deleting, and timeout handling
+//     deleting, and timeout handling. P holds partial seeds (BEP 21).
+//
+//   - IPv{4,6}_K_infohash
+//     To save the keys (BEP 3) of peers by peer ID, along with the peer
+//     and the time of the announce.
+//
+//   - IPv{4,6}_M_infohash
+//     To save the metadata of peers, along with the time it was stored.
 //
 //   - IPv{4,6}
 //     To save all the infohashes, used for garbage collection,
@@ -21,13 +28,20 @@
 //
 //   - IPv{4,6}_L_count
 //     To record the number of leechers.
+//
+//   - IPv{4,6}_P_count
+//     To record the number of partial seeds.
 package redis
 
 import (
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,9 +50,11 @@ import (
 
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/pkg/redisurl"
 	"github.com/chihaya/chihaya/pkg/stop"
 	"github.com/chihaya/chihaya/pkg/timecache"
 	"github.com/chihaya/chihaya/storage"
+	"github.com/chihaya/chihaya/storage/selection"
 )
 
 // Name is the name by which this peer store is registered with Chihaya.
@@ -89,6 +105,15 @@ type Config struct {
 	RedisWriteTimeout           time.Duration `yaml:"redis_write_timeout"`
 	RedisConnectTimeout         time.Duration `yaml:"redis_connect_timeout"`
 	EnableDualStackPeers        bool          `yaml:"enable_dual_stack_peers"`
+
+	// StorePeerMetadata stores the metadata of peers, such as their users
+	// and clients (see storage.PeerMetadataStore). It is implied by peer
+	// selections that use metadata.
+	StorePeerMetadata bool `yaml:"store_peer_metadata"`
+
+	// PeerSelection is the strategy by which peers are selected for
+	// announces.
+	PeerSelection selection.Config `yaml:"peer_selection"`
 }
 
 // LogFields renders the current config as a set of Logrus fields.
@@ -103,6 +128,8 @@ func (cfg Config) LogFields() log.Fields {
 		"redisWriteTimeout":    cfg.RedisWriteTimeout,
 		"redisConnectTimeout":  cfg.RedisConnectTimeout,
 		"enableDualStackPeers": cfg.EnableDualStackPeers,
+		"storePeerMetadata":    cfg.StorePeerMetadata,
+		"peerSelection":        cfg.PeerSelection.Strategy,
 	}
 }
 
@@ -193,15 +220,27 @@ func New(provided Config) (storage.PeerStore, error) {
 		})
 	}
 
-	u, err := parseRedisURL(cfg.RedisBroker)
+	u, err := redisurl.Parse(cfg.RedisBroker)
 	if err != nil {
 		return nil, err
 	}
 
+	selector, err := selection.New(cfg.PeerSelection)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.StorePeerMetadata && (selector.NeedsMetadata() || selector.FiltersPrivatePeers()) {
+		cfg.StorePeerMetadata = true
+		log.Info("storing peer metadata for peer selection", log.Fields{
+			"storePeerMetadata": true,
+		})
+	}
+
 	ps := &peerStore{
-		cfg:    cfg,
-		rb:     newRedisBackend(&provided, u, ""),
-		closed: make(chan struct{}),
+		cfg:      cfg,
+		rb:       newRedisBackend(&provided, u, ""),
+		selector: selector,
+		closed:   make(chan struct{}),
 	}
 
 	// Start a goroutine for garbage collection.
@@ -254,6 +293,22 @@ func newPeerKey(p bittorrent.Peer) serializedPeer {
 	return serializedPeer(b)
 }
 
+// isPrivate reports whether the IP address of a serialized peer is private.
+func (pk serializedPeer) isPrivate() bool {
+	return bittorrent.IP{IP: net.IP(pk[22:])}.IsPrivate()
+}
+
+// hasPrivatePeer reports whether a hash of peers has a peer with a private
+// IP address.
+func hasPrivatePeer(peers []string) bool {
+	for _, pk := range peers {
+		if serializedPeer(pk).isPrivate() {
+			return true
+		}
+	}
+	return false
+}
+
 func decodePeerKey(pk serializedPeer) bittorrent.Peer {
 	peer := bittorrent.Peer{
 		ID:   bittorrent.PeerIDFromString(string(pk[:20])),
@@ -274,13 +329,16 @@ func decodePeerKey(pk serializedPeer) bittorrent.Peer {
 }
 
 type peerStore struct {
-	cfg Config
-	rb  *redisBackend
+	cfg      Config
+	rb       *redisBackend
+	selector *selection.Selector
 
 	closed chan struct{}
 	wg     sync.WaitGroup
 }
 
+var _ storage.PeerMetadataStore = &peerStore{}
+
 func (ps *peerStore) groups() []string {
 	return []string{bittorrent.IPv4.String(), bittorrent.IPv6.String()}
 }
@@ -293,6 +351,18 @@ func (ps *peerStore) seederInfohashKey(af, ih string) string {
 	return af + "_S_" + ih
 }
 
+func (ps *peerStore) partialSeedInfohashKey(af, ih string) string {
+	return af + "_P_" + ih
+}
+
+func (ps *peerStore) peerKeyInfohashKey(af, ih string) string {
+	return af + "_K_" + ih
+}
+
+func (ps *peerStore) metadataInfohashKey(af, ih string) string {
+	return af + "_M_" + ih
+}
+
 func (ps *peerStore) infohashCountKey(af string) string {
 	return af + "_infohash_count"
 }
@@ -305,10 +375,14 @@ func (ps *peerStore) leecherCountKey(af string) string {
 	return af + "_L_count"
 }
 
+func (ps *peerStore) partialSeedCountKey(af string) string {
+	return af + "_P_count"
+}
+
 // populateProm aggregates metrics over all groups and then posts them to
 // prometheus.
 func (ps *peerStore) populateProm() {
-	var numInfohashes, numSeeders, numLeechers int64
+	var numInfohashes, numSeeders, numLeechers, numPartialSeeds int64
 
 	conn := ps.rb.open()
 	defer conn.Close()
@@ -338,11 +412,20 @@ func (ps *peerStore) populateProm() {
 		} else {
 			numLeechers += n
 		}
+		if n, err := redis.Int64(conn.Do("GET", ps.partialSeedCountKey(group))); err != nil && !errors.Is(err, redis.ErrNil) {
+			log.Error("storage: GET counter failure", log.Fields{
+				"key":   ps.partialSeedCountKey(group),
+				"error": err,
+			})
+		} else {
+			numPartialSeeds += n
+		}
 	}
 
 	storage.PromInfohashesCount.Set(float64(numInfohashes))
 	storage.PromSeedersCount.Set(float64(numSeeders))
 	storage.PromLeechersCount.Set(float64(numLeechers))
+	storage.PromPartialSeedsCount.Set(float64(numPartialSeeds))
 }
 
 func (ps *peerStore) getClock() int64 {
@@ -364,7 +447,8 @@ func (ps *peerStore) PutSeeder(ih bittorrent.InfoHash, p bittorrent.Peer) error
 
 	pk := newPeerKey(p)
 
-	encodedSeederInfoHash := ps.seederInfohashKey(addressFamily, ih.String())
+	encodedInfoHash := ih.String()
+	encodedSeederInfoHash := ps.seederInfohashKey(addressFamily, encodedInfoHash)
 	ct := ps.getClock()
 
 	conn := ps.rb.open()
@@ -373,6 +457,8 @@ func (ps *peerStore) PutSeeder(ih bittorrent.InfoHash, p bittorrent.Peer) error
 	_ = conn.Send("MULTI")
 	_ = conn.Send("HSET", encodedSeederInfoHash, pk, ct)
 	_ = conn.Send("HSET", addressFamily, encodedSeederInfoHash, ct)
+	// A partial seed that completed its download is no longer one.
+	_ = conn.Send("HDEL", ps.partialSeedInfohashKey(addressFamily, encodedInfoHash), pk)
 	reply, err := redis.Int64s(conn.Do("EXEC"))
 	if err != nil {
 		return err
@@ -392,6 +478,12 @@ func (ps *peerStore) PutSeeder(ih bittorrent.InfoHash, p bittorrent.Peer) error
 			return err
 		}
 	}
+	if reply[2] == 1 {
+		_, err = conn.Do("DECR", ps.partialSeedCountKey(addressFamily))
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -426,6 +518,9 @@ func (ps *peerStore) DeleteSeeder(ih bittorrent.InfoHash, p bittorrent.Peer) err
 	if _, err := conn.Do("DECR", ps.seederCountKey(addressFamily)); err != nil {
 		return err
 	}
+	if _, err := conn.Do("HDEL", ps.metadataInfohashKey(addressFamily, ih.String()), pk); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -443,8 +538,9 @@ func (ps *peerStore) PutLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) error
 	default:
 	}
 
-	// Update the peer in the swarm.
+	// Update the peer in the swarm, a partial seed may resume downloading.
 	encodedLeecherInfoHash := ps.leecherInfohashKey(addressFamily, ih.String())
+	encodedPartialSeedInfoHash := ps.partialSeedInfohashKey(addressFamily, ih.String())
 	pk := newPeerKey(p)
 	ct := ps.getClock()
 
@@ -454,6 +550,7 @@ func (ps *peerStore) PutLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) error
 	_ = conn.Send("MULTI")
 	_ = conn.Send("HSET", encodedLeecherInfoHash, pk, ct)
 	_ = conn.Send("HSET", addressFamily, encodedLeecherInfoHash, ct)
+	_ = conn.Send("HDEL", encodedPartialSeedInfoHash, pk)
 	reply, err := redis.Int64s(conn.Do("EXEC"))
 	if err != nil {
 		return err
@@ -465,6 +562,12 @@ func (ps *peerStore) PutLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) error
 			return err
 		}
 	}
+	if reply[2] == 1 {
+		_, err = conn.Do("DECR", ps.partialSeedCountKey(addressFamily))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -497,6 +600,9 @@ func (ps *peerStore) DeleteLeecher(ih bittorrent.InfoHash, p bittorrent.Peer) er
 	if _, err := conn.Do("DECR", ps.leecherCountKey(addressFamily)); err != nil {
 		return err
 	}
+	if _, err := conn.Do("HDEL", ps.metadataInfohashKey(addressFamily, ih.String()), pk); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -527,6 +633,7 @@ func (ps *peerStore) GraduateLeecher(ih bittorrent.InfoHash, p bittorrent.Peer)
 	_ = conn.Send("HDEL", encodedLeecherInfoHash, pk)
 	_ = conn.Send("HSET", encodedSeederInfoHash, pk, ct)
 	_ = conn.Send("HSET", addressFamily, encodedSeederInfoHash, ct)
+	_ = conn.Send("HDEL", ps.partialSeedInfohashKey(addressFamily, encodedInfoHash), pk)
 	reply, err := redis.Int64s(conn.Do("EXEC"))
 	if err != nil {
 		return err
@@ -549,11 +656,368 @@ func (ps *peerStore) GraduateLeecher(ih bittorrent.InfoHash, p bittorrent.Peer)
 			return err
 		}
 	}
+	if reply[3] == 1 {
+		_, err = conn.Do("DECR", ps.partialSeedCountKey(addressFamily))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ps *peerStore) PutPartialSeed(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: PutPartialSeed", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	// Update the peer in the swarm, a leecher may have paused downloading.
+	encodedPartialSeedInfoHash := ps.partialSeedInfohashKey(addressFamily, ih.String())
+	encodedLeecherInfoHash := ps.leecherInfohashKey(addressFamily, ih.String())
+	pk := newPeerKey(p)
+	ct := ps.getClock()
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HSET", encodedPartialSeedInfoHash, pk, ct)
+	_ = conn.Send("HSET", addressFamily, encodedPartialSeedInfoHash, ct)
+	_ = conn.Send("HDEL", encodedLeecherInfoHash, pk)
+	reply, err := redis.Int64s(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	// pk is a new field.
+	if reply[0] == 1 {
+		_, err = conn.Do("INCR", ps.partialSeedCountKey(addressFamily))
+		if err != nil {
+			return err
+		}
+	}
+	if reply[2] == 1 {
+		_, err = conn.Do("DECR", ps.leecherCountKey(addressFamily))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *peerStore) DeletePartialSeed(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: DeletePartialSeed", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	pk := newPeerKey(p)
+	encodedPartialSeedInfoHash := ps.partialSeedInfohashKey(addressFamily, ih.String())
+
+	delNum, err := redis.Int64(conn.Do("HDEL", encodedPartialSeedInfoHash, pk))
+	if err != nil {
+		return err
+	}
+	if delNum == 0 {
+		return storage.ErrResourceDoesNotExist
+	}
+	if _, err := conn.Do("DECR", ps.partialSeedCountKey(addressFamily)); err != nil {
+		return err
+	}
+	if _, err := conn.Do("HDEL", ps.metadataInfohashKey(addressFamily, ih.String()), pk); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// encodePeerKey encodes the value of a field of a IPv{4,6}_K_infohash hash.
+// The mtime comes first, so that it can be parsed like the values of the
+// other hashes of a group.
+func encodePeerKey(mtime int64, pk serializedPeer, key string) string {
+	return strconv.FormatInt(mtime, 10) + " " + hex.EncodeToString([]byte(pk)) + " " + key
+}
+
+// parseMtime parses the mtime of the value of a field of any hash of a group.
+func parseMtime(v string) (int64, error) {
+	if i := strings.IndexByte(v, ' '); i >= 0 {
+		v = v[:i]
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func (ps *peerStore) PutPeerKey(ih bittorrent.InfoHash, p bittorrent.Peer, key string) error {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: PutPeerKey", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	encodedPeerKeyInfoHash := ps.peerKeyInfohashKey(addressFamily, ih.String())
+	ct := ps.getClock()
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HSET", encodedPeerKeyInfoHash, p.ID[:], encodePeerKey(ct, newPeerKey(p), key))
+	_ = conn.Send("HSET", addressFamily, encodedPeerKeyInfoHash, ct)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func (ps *peerStore) PeerKey(ih bittorrent.InfoHash, p bittorrent.Peer) (string, bittorrent.Peer, error) {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: PeerKey", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	v, err := redis.String(conn.Do("HGET", ps.peerKeyInfohashKey(addressFamily, ih.String()), p.ID[:]))
+	if errors.Is(err, redis.ErrNil) {
+		return "", bittorrent.Peer{}, storage.ErrResourceDoesNotExist
+	} else if err != nil {
+		return "", bittorrent.Peer{}, err
+	}
+
+	fields := strings.SplitN(v, " ", 3)
+	if len(fields) != 3 {
+		return "", bittorrent.Peer{}, errors.New("storage: malformed peer key")
+	}
+	pk, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return "", bittorrent.Peer{}, err
+	}
+
+	return fields[2], decodePeerKey(serializedPeer(pk)), nil
+}
+
+func (ps *peerStore) DeletePeerKey(ih bittorrent.InfoHash, p bittorrent.Peer) error {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: DeletePeerKey", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	delNum, err := redis.Int64(conn.Do("HDEL", ps.peerKeyInfohashKey(addressFamily, ih.String()), p.ID[:]))
+	if err != nil {
+		return err
+	}
+	if delNum == 0 {
+		return storage.ErrResourceDoesNotExist
+	}
 
 	return nil
 }
 
+// metadataRecord is the encoding of storage.PeerMetadata in a
+// IPv{4,6}_M_infohash hash.
+type metadataRecord struct {
+	ClientID         string `json:"client_id"`
+	UserID           string `json:"user_id,omitempty"`
+	Left             uint64 `json:"left"`
+	Uploaded         uint64 `json:"uploaded"`
+	Downloaded       uint64 `json:"downloaded"`
+	Connectable      bool   `json:"connectable"`
+	ConnectableKnown bool   `json:"connectable_known,omitempty"`
+	SourceIP         string `json:"source_ip,omitempty"`
+	FirstSeen        int64  `json:"first_seen"`
+}
+
+// encodeMetadata encodes the value of a field of a IPv{4,6}_M_infohash hash.
+// Like encodePeerKey, the mtime comes first.
+func encodeMetadata(mtime int64, md storage.PeerMetadata) (string, error) {
+	r := metadataRecord{
+		ClientID:         string(md.ClientID[:]),
+		UserID:           md.UserID,
+		Left:             md.Left,
+		Uploaded:         md.Uploaded,
+		Downloaded:       md.Downloaded,
+		Connectable:      md.Connectable,
+		ConnectableKnown: md.ConnectableKnown,
+		FirstSeen:        md.FirstSeen.UnixNano(),
+	}
+	if md.SourceIP != nil {
+		r.SourceIP = md.SourceIP.String()
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(mtime, 10) + " " + string(b), nil
+}
+
+// decodeMetadata decodes the value of a field of a IPv{4,6}_M_infohash hash.
+func decodeMetadata(v string) (md storage.PeerMetadata, err error) {
+	i := strings.IndexByte(v, ' ')
+	if i < 0 {
+		return md, errors.New("storage: malformed peer metadata")
+	}
+
+	var r metadataRecord
+	if err = json.Unmarshal([]byte(v[i+1:]), &r); err != nil {
+		return md, err
+	}
+
+	copy(md.ClientID[:], r.ClientID)
+	md.UserID = r.UserID
+	md.Left = r.Left
+	md.Uploaded = r.Uploaded
+	md.Downloaded = r.Downloaded
+	md.Connectable = r.Connectable
+	md.ConnectableKnown = r.ConnectableKnown
+	if r.SourceIP != "" {
+		md.SourceIP = net.ParseIP(r.SourceIP)
+	}
+	md.FirstSeen = time.Unix(0, r.FirstSeen)
+	return md, nil
+}
+
+func (ps *peerStore) PutPeerMetadata(ih bittorrent.InfoHash, p bittorrent.Peer, md storage.PeerMetadata) error {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: PutPeerMetadata", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	if !ps.cfg.StorePeerMetadata {
+		return nil
+	}
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	encodedMetadataInfoHash := ps.metadataInfohashKey(addressFamily, ih.String())
+	pk := newPeerKey(p)
+
+	// The swarm and the existing metadata are looked up in one round trip.
+	_ = conn.Send("EXISTS",
+		ps.seederInfohashKey(addressFamily, ih.String()),
+		ps.leecherInfohashKey(addressFamily, ih.String()),
+		ps.partialSeedInfohashKey(addressFamily, ih.String()),
+	)
+	_ = conn.Send("HGET", encodedMetadataInfoHash, pk)
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	exists, err := redis.Int64(conn.Receive())
+	if err != nil {
+		return err
+	}
+	v, err := redis.String(conn.Receive())
+	if err != nil && !errors.Is(err, redis.ErrNil) {
+		return err
+	}
+	if exists == 0 {
+		return storage.ErrResourceDoesNotExist
+	}
+	if err == nil {
+		if existing, err := decodeMetadata(v); err == nil {
+			md.FirstSeen = existing.FirstSeen
+			if !md.ConnectableKnown {
+				md.Connectable, md.ConnectableKnown = existing.Connectable, existing.ConnectableKnown
+			}
+		}
+	}
+
+	ct := ps.getClock()
+	if v, err = encodeMetadata(ct, md); err != nil {
+		return err
+	}
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HSET", encodedMetadataInfoHash, pk, v)
+	_ = conn.Send("HSET", addressFamily, encodedMetadataInfoHash, ct)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (ps *peerStore) PeerMetadata(ih bittorrent.InfoHash, p bittorrent.Peer) (storage.PeerMetadata, error) {
+	addressFamily := p.IP.AddressFamily.String()
+	log.Debug("storage: PeerMetadata", log.Fields{
+		"InfoHash": ih.String(),
+		"Peer":     p,
+	})
+
+	select {
+	case <-ps.closed:
+		panic("attempted to interact with stopped redis store")
+	default:
+	}
+
+	conn := ps.rb.open()
+	defer conn.Close()
+
+	v, err := redis.String(conn.Do("HGET", ps.metadataInfohashKey(addressFamily, ih.String()), newPeerKey(p)))
+	if errors.Is(err, redis.ErrNil) {
+		return storage.PeerMetadata{}, storage.ErrResourceDoesNotExist
+	} else if err != nil {
+		return storage.PeerMetadata{}, err
+	}
+
+	return decodeMetadata(v)
+}
+
 func (ps *peerStore) AnnouncePeers(ih bittorrent.InfoHash, seeder bool, numWant int, announcer bittorrent.Peer) (peers []bittorrent.Peer, err error) {
+	return ps.announcePeers(ih, seeder, numWant, announcer, nil)
+}
+
+func (ps *peerStore) AnnouncePeersWithMetadata(ih bittorrent.InfoHash, seeder bool, numWant int, announcer bittorrent.Peer, md storage.PeerMetadata) (peers []bittorrent.Peer, err error) {
+	return ps.announcePeers(ih, seeder, numWant, announcer, &md)
+}
+
+// announcePeers implements AnnouncePeers, using md as the metadata of the
+// announcer unless it is nil.
+func (ps *peerStore) announcePeers(ih bittorrent.InfoHash, seeder bool, numWant int, announcer bittorrent.Peer, md *storage.PeerMetadata) (peers []bittorrent.Peer, err error) {
 	addressFamily := announcer.IP.AddressFamily.String()
 	log.Debug("storage: AnnouncePeers", log.Fields{
 		"InfoHash": ih.String(),
@@ -575,62 +1039,137 @@ func (ps *peerStore) AnnouncePeers(ih bittorrent.InfoHash, seeder bool, numWant
 	conn := ps.rb.open()
 	defer conn.Close()
 
-	leechers, err := conn.Do("HKEYS", encodedLeecherInfoHash)
+	leechers, err := ps.peerList(conn, encodedLeecherInfoHash)
 	if err != nil {
 		return nil, err
 	}
-	conLeechers := leechers.([]interface{})
 
-	seeders, err := conn.Do("HKEYS", encodedSeederInfoHash)
+	seeders, err := ps.peerList(conn, encodedSeederInfoHash)
 	if err != nil {
 		return nil, err
 	}
-	conSeeders := seeders.([]interface{})
 
-	if len(conLeechers) == 0 && len(conSeeders) == 0 {
+	if len(leechers.keys) == 0 && len(seeders.keys) == 0 {
 		return nil, storage.ErrResourceDoesNotExist
 	}
 
+	// Private peers are filtered by their metadata, only fetch it if needed.
+	var metadata map[string]string
+	if ps.selector.NeedsMetadata() || ps.selector.FiltersPrivatePeers() && (hasPrivatePeer(leechers.keys) || hasPrivatePeer(seeders.keys)) {
+		metadata, err = redis.StringMap(conn.Do("HGETALL", ps.metadataInfohashKey(addressFamily, encodedInfoHash)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := selection.Announcer{Peer: announcer, Metadata: md}
+	if v, ok := metadata[string(newPeerKey(announcer))]; ok && md == nil {
+		if md, err := decodeMetadata(v); err == nil {
+			a.Metadata = &md
+		}
+	}
+
 	if seeder {
-		// Append leechers as possible.
-		for _, pk := range conLeechers {
-			if numWant == 0 {
+		// Seeders only get leechers.
+		return ps.selectPeers(a, numWant, metadata, leechers), nil
+	}
+	// Leechers get seeders first, then leechers.
+	return ps.selectPeers(a, numWant, metadata, seeders, leechers), nil
+}
+
+// peerList is the list of peers of a hash of peers, with their mtimes if the
+// peer selection uses them.
+type peerList struct {
+	keys   []string
+	mtimes []int64
+}
+
+// peerList returns the peers of a hash of peers.
+func (ps *peerStore) peerList(conn redis.Conn, key string) (peerList, error) {
+	if !ps.selector.NeedsLastSeen() {
+		keys, err := redis.Strings(conn.Do("HKEYS", key))
+		return peerList{keys: keys}, err
+	}
+
+	fields, err := redis.Strings(conn.Do("HGETALL", key))
+	if err != nil {
+		return peerList{}, err
+	}
+	l := peerList{
+		keys:   make([]string, 0, len(fields)/2),
+		mtimes: make([]int64, 0, len(fields)/2),
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		mtime, _ := parseMtime(fields[i+1])
+		l.keys = append(l.keys, fields[i])
+		l.mtimes = append(l.mtimes, mtime)
+	}
+	return l, nil
+}
+
+// candidate returns the candidate for the selection of the i-th peer of a
+// list, given the metadata hash of its swarm.
+func (ps *peerStore) candidate(l peerList, i int, metadata map[string]string) selection.Candidate {
+	pk := l.keys[i]
+	c := selection.Candidate{Key: pk}
+	private := ps.selector.FiltersPrivatePeers() && serializedPeer(pk).isPrivate()
+	if ps.selector.NeedsAddresses() || private {
+		c.Peer = decodePeerKey(serializedPeer(pk))
+	} else {
+		c.Peer.ID = bittorrent.PeerIDFromString(pk[:20])
+	}
+	if l.mtimes != nil {
+		c.LastSeen = l.mtimes[i]
+	}
+	if v, ok := metadata[pk]; ok && (ps.selector.NeedsMetadata() || private) {
+		if md, err := decodeMetadata(v); err == nil {
+			c.Metadata = &md
+		}
+	}
+	return c
+}
+
+// selectPeers selects up to numWant peers of the tiers of lists of peers for
+// the announcer, given the metadata hash of their swarm.
+func (ps *peerStore) selectPeers(a selection.Announcer, numWant int, metadata map[string]string, tiers ...peerList) []bittorrent.Peer {
+	var selected []selection.Candidate
+	if ps.selector.Sorts() {
+		// Tiers are only collected until numWant peers are selected.
+		for _, l := range tiers {
+			if len(selected) >= numWant {
 				break
 			}
-
-			peers = append(peers, decodePeerKey(serializedPeer(pk.([]byte))))
-			numWant--
+			candidates := make([]selection.Candidate, len(l.keys))
+			for i := range l.keys {
+				candidates[i] = ps.candidate(l, i, metadata)
+			}
+			selected = append(selected, ps.selector.Select(a, numWant-len(selected), candidates)...)
 		}
 	} else {
-		// Append as many seeders as possible.
-		for _, pk := range conSeeders {
-			if numWant == 0 {
+		sample := ps.selector.NewSample(a, numWant)
+		for _, l := range tiers {
+			if sample.Full() {
 				break
 			}
-
-			peers = append(peers, decodePeerKey(serializedPeer(pk.([]byte))))
-			numWant--
-		}
-
-		// Append leechers until we reach numWant.
-		if numWant > 0 {
-			announcerPK := newPeerKey(announcer)
-			for _, pk := range conLeechers {
-				if pk == announcerPK {
-					continue
-				}
-
-				if numWant == 0 {
-					break
-				}
-
-				peers = append(peers, decodePeerKey(serializedPeer(pk.([]byte))))
-				numWant--
+			// Hashes are listed in a stable order, so the peers are offered
+			// from a random offset.
+			offset := 0
+			if len(l.keys) > 0 {
+				offset = rand.Intn(len(l.keys))
 			}
+			for n := 0; n < len(l.keys) && !sample.TierDone(); n++ {
+				sample.Offer(ps.candidate(l, (offset+n)%len(l.keys), metadata))
+			}
+			sample.NextTier()
 		}
+		selected = sample.Selected()
 	}
 
-	return
+	peers := make([]bittorrent.Peer, 0, len(selected))
+	for _, c := range selected {
+		peers = append(peers, decodePeerKey(serializedPeer(c.Key)))
+	}
+	return peers
 }
 
 func (ps *peerStore) ScrapeSwarm(ih bittorrent.InfoHash, af bittorrent.AddressFamily) (resp bittorrent.Scrape) {
@@ -667,7 +1206,18 @@ func (ps *peerStore) ScrapeSwarm(ih bittorrent.InfoHash, af bittorrent.AddressFa
 		return
 	}
 
-	resp.Incomplete = uint32(leechersLen)
+	encodedPartialSeedInfoHash := ps.partialSeedInfohashKey(addressFamily, encodedInfoHash)
+	partialSeedsLen, err := redis.Int64(conn.Do("HLEN", encodedPartialSeedInfoHash))
+	if err != nil {
+		log.Error("storage: Redis HLEN failure", log.Fields{
+			"Hkey":  encodedPartialSeedInfoHash,
+			"error": err,
+		})
+		return
+	}
+
+	resp.Incomplete = uint32(leechersLen + partialSeedsLen)
+	resp.Downloaders = uint32(leechersLen)
 	resp.Complete = uint32(seedersLen)
 
 	return
@@ -679,10 +1229,10 @@ func (ps *peerStore) ScrapeSwarm(ih bittorrent.InfoHash, af bittorrent.AddressFa
 // This function must be able to execute while other methods on this interface
 // are being executed in parallel.
 //
-//   - The Delete(Seeder|Leecher) and GraduateLeecher methods never delete an
+//   - The Delete(Seeder|Leecher|PartialSeed) and GraduateLeecher methods never delete an
 //     infohash key from an addressFamily hash. They also never decrement the
 //     infohash counter.
-//   - The Put(Seeder|Leecher) and GraduateLeecher methods only ever add infohash
+//   - The Put(Seeder|Leecher|PartialSeed) and GraduateLeecher methods only ever add infohash
 //     keys to addressFamily hashes and increment the infohash counter.
 //   - The only method that deletes from the addressFamily hashes is
 //     collectGarbage, which also decrements the counters. That means that,
@@ -740,6 +1290,9 @@ func (ps *peerStore) collectGarbage(cutoff time.Time) error {
 
 		for _, ihStr := range infohashesList {
 			isSeeder := len(ihStr) > 5 && ihStr[5:6] == "S"
+			isPartialSeed := len(ihStr) > 5 && ihStr[5:6] == "P"
+			// Keys and metadata are not peers of their own.
+			isAuxiliary := len(ihStr) > 5 && (ihStr[5:6] == "K" || ihStr[5:6] == "M")
 
 			// list all (peer, timeout) pairs for the ih
 			ihList, err := redis.Strings(conn.Do("HGETALL", ihStr))
@@ -751,14 +1304,16 @@ func (ps *peerStore) collectGarbage(cutoff time.Time) error {
 			var removedPeerCount int64
 			for index, ihField := range ihList {
 				if index%2 == 1 { // value
-					mtime, err := strconv.ParseInt(ihField, 10, 64)
+					mtime, err := parseMtime(ihField)
 					if err != nil {
 						return err
 					}
 					if mtime <= cutoffUnix {
-						log.Debug("storage: deleting peer", log.Fields{
-							"Peer": decodePeerKey(pk).String(),
-						})
+						if !isAuxiliary {
+							log.Debug("storage: deleting peer", log.Fields{
+								"Peer": decodePeerKey(pk).String(),
+							})
+						}
 						ret, err := redis.Int64(conn.Do("HDEL", ihStr, pk))
 						if err != nil {
 							return err
@@ -774,8 +1329,10 @@ func (ps *peerStore) collectGarbage(cutoff time.Time) error {
 			decrCounter := ps.leecherCountKey(group)
 			if isSeeder {
 				decrCounter = ps.seederCountKey(group)
+			} else if isPartialSeed {
+				decrCounter = ps.partialSeedCountKey(group)
 			}
-			if removedPeerCount > 0 {
+			if removedPeerCount > 0 && !isAuxiliary {
 				if _, err := conn.Do("DECRBY", decrCounter, removedPeerCount); err != nil {
 					return err
 				}
@@ -8,6 +8,7 @@ import (
 	"github.com/alicebob/miniredis"
 
 	s "github.com/chihaya/chihaya/storage"
+	"github.com/chihaya/chihaya/storage/selection"
 )
 
 func createNew() s.PeerStore {
@@ -20,6 +21,8 @@ func createNew() s.PeerStore {
 		GarbageCollectionInterval:   10 * time.Minute,
 		PrometheusReportingInterval: 10 * time.Minute,
 		PeerLifetime:                30 * time.Minute,
+		StorePeerMetadata:           true,
+		PeerSelection:               selection.Config{PrivatePeers: selection.PrivateSameIP},
 		RedisBroker:                 redisURL,
 		RedisReadTimeout:            10 * time.Second,
 		RedisWriteTimeout:           10 * time.Second,
@@ -1,15 +1,13 @@
 package redis
 
 import (
-	"errors"
-	"net/url"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/redigo"
 	redigolib "github.com/gomodule/redigo/redis"
+
+	"github.com/chihaya/chihaya/pkg/redisurl"
 )
 
 // redisBackend represents a redis handler.
@@ -19,7 +17,7 @@ type redisBackend struct {
 }
 
 // newRedisBackend creates a redisBackend instance.
-func newRedisBackend(cfg *Config, u *redisURL, socketPath string) *redisBackend {
+func newRedisBackend(cfg *Config, u *redisurl.URL, socketPath string) *redisBackend {
 	rc := &redisConnector{
 		URL:            u,
 		SocketPath:     socketPath,
@@ -41,7 +39,7 @@ func (rb *redisBackend) open() redigolib.Conn {
 }
 
 type redisConnector struct {
-	URL            *redisURL
+	URL            *redisurl.URL
 	SocketPath     string
 	ReadTimeout    time.Duration
 	WriteTimeout   time.Duration
@@ -98,39 +96,3 @@ func (rc *redisConnector) open() (redigolib.Conn, error) {
 
 	return redigolib.Dial("tcp", rc.URL.Host, opts...)
 }
-
-// A redisURL represents a parsed redisURL
-// The general form represented is:
-//
-//	redis://[password@]host][/][db]
-type redisURL struct {
-	Host     string
-	Password string
-	DB       int
-}
-
-// parseRedisURL parse rawurl into redisURL
-func parseRedisURL(target string) (*redisURL, error) {
-	var u *url.URL
-	u, err := url.Parse(target)
-	if err != nil {
-		return nil, err
-	}
-	if u.Scheme != "redis" {
-		return nil, errors.New("no redis scheme found")
-	}
-
-	db := 0 // default redis db
-	parts := strings.Split(u.Path, "/")
-	if len(parts) != 1 {
-		db, err = strconv.Atoi(parts[1])
-		if err != nil {
-			return nil, err
-		}
-	}
-	return &redisURL{
-		Host:     u.Host,
-		Password: u.User.String(),
-		DB:       db,
-	}, nil
-}
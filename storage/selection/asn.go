@@ -0,0 +1,118 @@
+package selection
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// asnTable maps IP prefixes to the number of the autonomous system they are
+// announced by.
+type asnTable struct {
+	ipv4, ipv6 prefixTable
+}
+
+// prefixTable maps the masked addresses of prefixes of one address family
+// to AS numbers, by prefix length.
+type prefixTable struct {
+	// lengths are the prefix lengths in the table, longest first.
+	lengths  []int
+	prefixes map[int]map[string]uint32
+}
+
+// loadASNTable reads a table in the pfx2as format of CAIDA. Prefixes
+// announced by multiple ASes ("13335_209242" or "13335,209242") use the first.
+func loadASNTable(path string) (*asnTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &asnTable{}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected prefix, length and AS number", line)
+		}
+		ip := net.ParseIP(fields[0])
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		length, err := strconv.Atoi(fields[1])
+		if ip == nil || err != nil || length < 0 || length > len(ip)*8 {
+			return nil, fmt.Errorf("line %d: invalid prefix %s/%s", line, fields[0], fields[1])
+		}
+		asns := strings.FieldsFunc(fields[2], func(r rune) bool { return r == '_' || r == ',' })
+		if len(asns) == 0 {
+			return nil, fmt.Errorf("line %d: invalid AS number %s", line, fields[2])
+		}
+		asn, err := strconv.ParseUint(asns[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number %s", line, fields[2])
+		}
+
+		t.table(ip).add(ip, length, uint32(asn))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, pt := range []*prefixTable{&t.ipv4, &t.ipv6} {
+		sort.Sort(sort.Reverse(sort.IntSlice(pt.lengths)))
+	}
+	return t, nil
+}
+
+// table returns the prefixTable of the family of ip, which must be 4 bytes
+// long for IPv4.
+func (t *asnTable) table(ip net.IP) *prefixTable {
+	if len(ip) == net.IPv4len {
+		return &t.ipv4
+	}
+	return &t.ipv6
+}
+
+// lookup returns the AS number of the longest prefix containing ip.
+func (t *asnTable) lookup(ip net.IP) (uint32, bool) {
+	if t == nil {
+		return 0, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return t.table(ip).lookup(ip)
+}
+
+func (pt *prefixTable) add(ip net.IP, length int, asn uint32) {
+	if pt.prefixes == nil {
+		pt.prefixes = make(map[int]map[string]uint32)
+	}
+
+	m, ok := pt.prefixes[length]
+	if !ok {
+		m = make(map[string]uint32)
+		pt.prefixes[length] = m
+		pt.lengths = append(pt.lengths, length)
+	}
+	m[string(ip.Mask(net.CIDRMask(length, len(ip)*8)))] = asn
+}
+
+func (pt *prefixTable) lookup(ip net.IP) (uint32, bool) {
+	for _, length := range pt.lengths {
+		if asn, ok := pt.prefixes[length][string(ip.Mask(net.CIDRMask(length, len(ip)*8)))]; ok {
+			return asn, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,560 @@
+// Package selection implements the strategies by which peer stores select
+// the peers returned to an announcing peer.
+//
+// Stores pass the peers of a swarm as tiers of candidates, such as the
+// seeders followed by the leechers. Tiers are filled in order, the strategy
+// decides which candidates of a tier are returned first.
+package selection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/pkg/log"
+	"github.com/chihaya/chihaya/storage"
+)
+
+// The names of the strategies.
+const (
+	// Random samples candidates uniformly at random.
+	Random = "random"
+
+	// BEP40 prefers candidates by their canonical peer priority (BEP 40)
+	// towards the announcer.
+	BEP40 = "bep40"
+
+	// Locality prefers candidates in the subnet of the announcer, then those
+	// in its autonomous system.
+	Locality = "locality"
+
+	// Recent prefers the candidates that announced most recently.
+	Recent = "recent"
+
+	// Connectable prefers candidates known to accept incoming connections.
+	Connectable = "connectable"
+)
+
+// The policies for peers with a private IP address, such as clients on a
+// LAN that announce their private address. See bittorrent.IP.IsPrivate.
+const (
+	// PrivateSameIP returns private peers only to announcers observed from
+	// the same IP address, that is behind the same NAT.
+	PrivateSameIP = "same_ip"
+
+	// PrivateSameNetwork returns private peers only to announcers observed
+	// from the same subnet (see Config.IPv4SubnetBits).
+	PrivateSameNetwork = "same_network"
+
+	// PrivateDeny never returns private peers.
+	PrivateDeny = "deny"
+
+	// PrivateAllow returns private peers like all others.
+	PrivateAllow = "allow"
+)
+
+// Default config constants.
+const (
+	defaultStrategy       = Random
+	defaultPrivatePeers   = PrivateAllow
+	defaultIPv4SubnetBits = 24
+	defaultIPv6SubnetBits = 48
+)
+
+// ErrUnknownStrategy is returned for a config with an unknown strategy.
+var ErrUnknownStrategy = errors.New("unknown peer selection strategy")
+
+// ErrUnknownPrivatePeersPolicy is returned for a config with an unknown policy
+// for private peers.
+var ErrUnknownPrivatePeersPolicy = errors.New("unknown private peers policy")
+
+// Config represents the peer selection of a store.
+type Config struct {
+	// Strategy is the name of the strategy, Random by default.
+	Strategy string `yaml:"strategy"`
+
+	// ExcludeSameUser never returns peers of the user of the announcer, as
+	// far as the store knows the users of peers (see
+	// storage.PeerMetadataStore).
+	ExcludeSameUser bool `yaml:"exclude_same_user"`
+
+	// PrivatePeers is the policy for peers with a private IP address,
+	// PrivateAllow by default, as all peers of trackers on a LAN are private. The network of a private peer is the source
+	// IP of its last announce, as far as the store knows it (see
+	// storage.PeerMetadataStore); private peers of unknown networks are only
+	// returned with PrivateAllow.
+	PrivatePeers string `yaml:"private_peers"`
+
+	// IPv4SubnetBits and IPv6SubnetBits are the prefix lengths of the subnets
+	// preferred by the Locality strategy and of the networks of the
+	// PrivateSameNetwork policy.
+	IPv4SubnetBits int `yaml:"ipv4_subnet_bits"`
+	IPv6SubnetBits int `yaml:"ipv6_subnet_bits"`
+
+	// ASNFile is the path of a prefix to AS number table in the pfx2as
+	// format of CAIDA, one "<prefix> <length> <AS number>" per line, used by
+	// the Locality strategy.
+	ASNFile string `yaml:"asn_file"`
+}
+
+// LogFields renders the current config as a set of Logrus fields.
+func (cfg Config) LogFields() log.Fields {
+	return log.Fields{
+		"strategy":        cfg.Strategy,
+		"excludeSameUser": cfg.ExcludeSameUser,
+		"privatePeers":    cfg.PrivatePeers,
+		"ipv4SubnetBits":  cfg.IPv4SubnetBits,
+		"ipv6SubnetBits":  cfg.IPv6SubnetBits,
+		"asnFile":         cfg.ASNFile,
+	}
+}
+
+// Candidate is a peer that may be returned to an announcer.
+type Candidate struct {
+	// Peer is the peer. Unless the Selector needs the addresses of
+	// candidates or filters private peers, stores may only set its ID and
+	// decode the selected candidates by their Key, as decoding all peers of a
+	// swarm is costly.
+	Peer bittorrent.Peer
+
+	// Key identifies the peer within its store.
+	Key string
+
+	// LastSeen is the time of the last announce of the peer in Unix
+	// nanoseconds.
+	LastSeen int64
+
+	// Metadata is the metadata of the peer, nil if it is not known.
+	Metadata *storage.PeerMetadata
+}
+
+// Announcer is the peer that peers are selected for.
+type Announcer struct {
+	Peer bittorrent.Peer
+
+	// Metadata is the metadata of the announcer, nil if it is not known.
+	Metadata *storage.PeerMetadata
+}
+
+// sourceIP returns the IP address the announcer was last observed from,
+// falling back to its own.
+func (a Announcer) sourceIP() net.IP {
+	if a.Metadata != nil && a.Metadata.SourceIP != nil {
+		return a.Metadata.SourceIP
+	}
+	return a.Peer.IP.IP
+}
+
+// Selector selects peers by the strategy of a Config.
+type Selector struct {
+	cfg  Config
+	asns *asnTable
+}
+
+// New creates a Selector for a Config.
+func New(cfg Config) (*Selector, error) {
+	if cfg.Strategy == "" {
+		cfg.Strategy = defaultStrategy
+	}
+	switch cfg.Strategy {
+	case Random, BEP40, Locality, Recent, Connectable:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, cfg.Strategy)
+	}
+	if cfg.PrivatePeers == "" {
+		cfg.PrivatePeers = defaultPrivatePeers
+	}
+	switch cfg.PrivatePeers {
+	case PrivateSameIP, PrivateSameNetwork, PrivateDeny, PrivateAllow:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPrivatePeersPolicy, cfg.PrivatePeers)
+	}
+	if cfg.IPv4SubnetBits <= 0 || cfg.IPv4SubnetBits > 32 {
+		cfg.IPv4SubnetBits = defaultIPv4SubnetBits
+	}
+	if cfg.IPv6SubnetBits <= 0 || cfg.IPv6SubnetBits > 128 {
+		cfg.IPv6SubnetBits = defaultIPv6SubnetBits
+	}
+
+	s := &Selector{cfg: cfg}
+	if cfg.ASNFile != "" {
+		asns, err := loadASNTable(cfg.ASNFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ASN file: %w", err)
+		}
+		s.asns = asns
+	}
+	return s, nil
+}
+
+// Config returns the Config of the Selector, with defaults applied.
+func (s *Selector) Config() Config {
+	return s.cfg
+}
+
+// NeedsAddresses reports whether the Selector uses the addresses of all
+// candidates.
+func (s *Selector) NeedsAddresses() bool {
+	return s.cfg.Strategy == BEP40 || s.cfg.Strategy == Locality
+}
+
+// NeedsMetadata reports whether the Selector uses the metadata of all
+// candidates and announcers. Stores only need to look it up if it does.
+func (s *Selector) NeedsMetadata() bool {
+	return s.cfg.ExcludeSameUser || s.cfg.Strategy == Connectable
+}
+
+// FiltersPrivatePeers reports whether the Selector uses the addresses and
+// metadata of private candidates and the metadata of announcers, regardless
+// of NeedsAddresses and NeedsMetadata.
+func (s *Selector) FiltersPrivatePeers() bool {
+	return s.cfg.PrivatePeers != PrivateAllow
+}
+
+// NeedsLastSeen reports whether the Selector uses the LastSeen of candidates.
+func (s *Selector) NeedsLastSeen() bool {
+	return s.cfg.Strategy == Recent
+}
+
+// Sorts reports whether the Selector orders all candidates of a tier, so that
+// stores must collect them for Select. Stores should offer the candidates of
+// other strategies to a Sample instead, which does not keep all of them.
+func (s *Selector) Sorts() bool {
+	return s.cfg.Strategy != Random
+}
+
+// Select returns up to numWant candidates of the tiers of candidates for the
+// announcer, filling the tiers in order.
+//
+// Candidates with the peer ID of the announcer are never returned.
+// The tiers may be reordered.
+func (s *Selector) Select(a Announcer, numWant int, tiers ...[]Candidate) []Candidate {
+	f := s.newFilter(a)
+
+	var selected []Candidate
+	for _, tier := range tiers {
+		if numWant <= 0 {
+			break
+		}
+
+		tier = f.apply(tier)
+		s.order(a, tier, numWant)
+		if len(tier) > numWant {
+			tier = tier[:numWant]
+		}
+		selected = append(selected, tier...)
+		numWant -= len(tier)
+	}
+	return selected
+}
+
+// filter decides which candidates may be returned to an announcer.
+type filter struct {
+	peerID  bittorrent.PeerID
+	userID  string
+	private bool
+	network *net.IPNet
+}
+
+func (s *Selector) newFilter(a Announcer) filter {
+	f := filter{
+		peerID:  a.Peer.ID,
+		private: s.cfg.PrivatePeers != PrivateAllow,
+		network: s.network(a.sourceIP()),
+	}
+	if s.cfg.ExcludeSameUser && a.Metadata != nil {
+		f.userID = a.Metadata.UserID
+	}
+	return f
+}
+
+// keep reports whether a candidate may be returned.
+func (f *filter) keep(c *Candidate) bool {
+	if c.Peer.ID == f.peerID {
+		return false
+	}
+	if f.userID != "" && c.Metadata != nil && c.Metadata.UserID == f.userID {
+		return false
+	}
+	if f.private && c.Peer.IP.IsPrivate() &&
+		(f.network == nil || c.Metadata == nil || !f.network.Contains(c.Metadata.SourceIP)) {
+		return false
+	}
+	return true
+}
+
+// apply removes the candidates that must not be returned from tier in place.
+func (f *filter) apply(tier []Candidate) []Candidate {
+	filtered := tier[:0]
+	for i := range tier {
+		if f.keep(&tier[i]) {
+			filtered = append(filtered, tier[i])
+		}
+	}
+	return filtered
+}
+
+// sampleWindow is the number of candidates of a tier a Sample considers per
+// candidate it selects from the tier.
+const sampleWindow = 4
+
+// Sample selects up to numWant candidates at random from candidates offered
+// one by one, like Select with the Random strategy, while only keeping the
+// selected ones.
+//
+// The candidates of each tier are offered in turn, followed by a call to
+// NextTier. A Sample considers up to sampleWindow times as many candidates of
+// a tier as it selects from it by reservoir sampling, so that the cost of an
+// announce does not grow with its swarm. Stores stop offering the candidates
+// of a tier once it is TierDone, and should offer them in a random order,
+// such as the one of ranging over a map. Later tiers need not be offered once
+// the Sample is Full.
+type Sample struct {
+	filter   filter
+	numWant  int
+	selected []Candidate
+
+	// tier is the index in selected at which the current tier starts.
+	tier int
+
+	// seen is the number of candidates of the current tier that passed the
+	// filter.
+	seen int
+}
+
+// NewSample creates a Sample of up to numWant candidates for the announcer.
+// It ignores the strategy of the Selector.
+func (s *Selector) NewSample(a Announcer, numWant int) *Sample {
+	sm := &Sample{filter: s.newFilter(a), numWant: numWant}
+	if numWant > 0 {
+		sm.selected = make([]Candidate, 0, numWant)
+	}
+	return sm
+}
+
+// Offer offers a candidate of the current tier.
+func (sm *Sample) Offer(c Candidate) {
+	if sm.TierDone() || !sm.filter.keep(&c) {
+		return
+	}
+	size := sm.numWant - sm.tier
+
+	// Reservoir sampling: the n-th candidate replaces a random one of the
+	// reservoir with probability size/n.
+	sm.seen++
+	if sm.seen <= size {
+		sm.selected = append(sm.selected, c)
+	} else if i := rand.Intn(sm.seen); i < size {
+		sm.selected[sm.tier+i] = c
+	}
+}
+
+// NextTier completes the current tier.
+func (sm *Sample) NextTier() {
+	// Candidates that were never replaced are in the order they were
+	// offered in.
+	tier := sm.selected[sm.tier:]
+	shuffle(tier, len(tier))
+
+	sm.tier = len(sm.selected)
+	sm.seen = 0
+}
+
+// TierDone reports whether the Sample considers no more candidates of the
+// current tier.
+func (sm *Sample) TierDone() bool {
+	size := sm.numWant - sm.tier
+	return size <= 0 || sm.seen >= sampleWindow*size
+}
+
+// Full reports whether numWant candidates were selected.
+func (sm *Sample) Full() bool {
+	return len(sm.selected) >= sm.numWant
+}
+
+// Selected returns the selected candidates.
+func (sm *Sample) Selected() []Candidate {
+	return sm.selected
+}
+
+// network returns the network that private candidates must have been observed
+// from to be returned to an announcer observed from ip, nil if there is none.
+func (s *Selector) network(ip net.IP) *net.IPNet {
+	var bits int
+	switch s.cfg.PrivatePeers {
+	case PrivateSameIP:
+		bits = 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+	case PrivateSameNetwork:
+		bits = s.cfg.IPv6SubnetBits
+		if ip.To4() != nil {
+			bits = s.cfg.IPv4SubnetBits
+		}
+	default:
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	mask := net.CIDRMask(bits, len(ip)*8)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// order orders tier so that its first numWant candidates are the ones to
+// return.
+func (s *Selector) order(a Announcer, tier []Candidate, numWant int) {
+	switch s.cfg.Strategy {
+	case Random:
+		shuffle(tier, numWant)
+	case BEP40:
+		sortBy(tier, func(c Candidate) uint64 { return uint64(^priority(a.Peer, c.Peer)) })
+	case Locality:
+		shuffle(tier, len(tier))
+		sortBy(tier, s.distance(a.Peer))
+	case Recent:
+		sortBy(tier, func(c Candidate) uint64 { return uint64(math.MaxInt64 - c.LastSeen) })
+	case Connectable:
+		shuffle(tier, len(tier))
+		sortBy(tier, func(c Candidate) uint64 {
+			if c.Metadata != nil && c.Metadata.Connectable {
+				return 0
+			}
+			return 1
+		})
+	}
+}
+
+// shuffle moves n candidates chosen uniformly at random to the front of tier.
+func shuffle(tier []Candidate, n int) {
+	for i := 0; i < n && i < len(tier)-1; i++ {
+		j := i + rand.Intn(len(tier)-i)
+		tier[i], tier[j] = tier[j], tier[i]
+	}
+}
+
+// sortBy stably sorts tier by ascending keys.
+func sortBy(tier []Candidate, key func(Candidate) uint64) {
+	keys := make([]uint64, len(tier))
+	for i, c := range tier {
+		keys[i] = key(c)
+	}
+	sort.Stable(byKey{tier, keys})
+}
+
+type byKey struct {
+	tier []Candidate
+	keys []uint64
+}
+
+func (b byKey) Len() int           { return len(b.tier) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.tier[i], b.tier[j] = b.tier[j], b.tier[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+
+// distance returns the key of the Locality strategy: 0 for candidates in the
+// subnet of the announcer, 1 for those in its autonomous system and 2 for all
+// others.
+func (s *Selector) distance(announcer bittorrent.Peer) func(Candidate) uint64 {
+	bits := s.cfg.IPv4SubnetBits
+	if announcer.IP.To4() == nil {
+		bits = s.cfg.IPv6SubnetBits
+	}
+	subnet := &net.IPNet{IP: announcer.IP.IP, Mask: net.CIDRMask(bits, len(announcer.IP.IP)*8)}
+	subnet.IP = subnet.IP.Mask(subnet.Mask)
+
+	asn, hasASN := s.asns.lookup(announcer.IP.IP)
+	return func(c Candidate) uint64 {
+		switch {
+		case subnet.Contains(c.Peer.IP.IP):
+			return 0
+		case hasASN:
+			if other, ok := s.asns.lookup(c.Peer.IP.IP); ok && other == asn {
+				return 1
+			}
+		}
+		return 2
+	}
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// The masks of BEP 40 for addresses in different, the same /16 (/40 for IPv6)
+// and the same /24 (/48) networks.
+var (
+	ipv4Masks = [3][4]byte{
+		{0xff, 0xff, 0x55, 0x55},
+		{0xff, 0xff, 0xff, 0x55},
+		{0xff, 0xff, 0xff, 0xff},
+	}
+	ipv6Masks = [3][8]byte{
+		{0xff, 0xff, 0xff, 0xff, 0x55, 0x55, 0x55, 0x55},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0x55, 0x55, 0x55},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+)
+
+// priority returns the canonical peer priority (BEP 40) of two peers.
+// Peers of different address families have the lowest priority.
+func priority(a, b bittorrent.Peer) uint32 {
+	ipA, ipB := a.IP.To4(), b.IP.To4()
+	if (ipA == nil) != (ipB == nil) {
+		return 0
+	}
+
+	if a.IP.Equal(b.IP.IP) {
+		var buf [4]byte
+		portA, portB := a.Port, b.Port
+		if portA > portB {
+			portA, portB = portB, portA
+		}
+		binary.BigEndian.PutUint16(buf[:2], portA)
+		binary.BigEndian.PutUint16(buf[2:], portB)
+		return crc32.Checksum(buf[:], castagnoli)
+	}
+
+	var maskedA, maskedB []byte
+	if ipA != nil {
+		mask := ipv4Masks[commonPrefix(ipA, ipB, 2, 3)]
+		maskedA, maskedB = applyMask(ipA, mask[:]), applyMask(ipB, mask[:])
+	} else {
+		ipA, ipB = a.IP.To16()[:8], b.IP.To16()[:8]
+		mask := ipv6Masks[commonPrefix(ipA, ipB, 5, 6)]
+		maskedA, maskedB = applyMask(ipA, mask[:]), applyMask(ipB, mask[:])
+	}
+
+	if string(maskedA) > string(maskedB) {
+		maskedA, maskedB = maskedB, maskedA
+	}
+	return crc32.Checksum(append(maskedA, maskedB...), castagnoli)
+}
+
+// commonPrefix returns the index of the BEP 40 mask for two addresses: 2 if
+// their first long bytes match, 1 if their first short bytes match, else 0.
+func commonPrefix(a, b []byte, short, long int) int {
+	switch {
+	case string(a[:long]) == string(b[:long]):
+		return 2
+	case string(a[:short]) == string(b[:short]):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func applyMask(ip, mask []byte) []byte {
+	masked := make([]byte, len(mask))
+	for i := range mask {
+		masked[i] = ip[i] & mask[i]
+	}
+	return masked
+}
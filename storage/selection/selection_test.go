@@ -0,0 +1,282 @@
+package selection
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/storage"
+)
+
+func peer(id byte, ip string, port uint16) bittorrent.Peer {
+	p := bittorrent.Peer{IP: bittorrent.IP{IP: net.ParseIP(ip), AddressFamily: bittorrent.IPv6}, Port: port}
+	if ip4 := p.IP.To4(); ip4 != nil {
+		p.IP = bittorrent.IP{IP: ip4, AddressFamily: bittorrent.IPv4}
+	}
+	p.ID[0] = id
+	return p
+}
+
+func candidates(peers ...bittorrent.Peer) []Candidate {
+	cs := make([]Candidate, len(peers))
+	for i, p := range peers {
+		cs[i] = Candidate{Peer: p}
+	}
+	return cs
+}
+
+func peersOf(cs []Candidate) []bittorrent.Peer {
+	var peers []bittorrent.Peer
+	for _, c := range cs {
+		peers = append(peers, c.Peer)
+	}
+	return peers
+}
+
+func TestPriority(t *testing.T) {
+	// Test vectors of BEP 40.
+	table := []struct {
+		a, b     bittorrent.Peer
+		expected uint32
+	}{
+		{peer(0, "123.213.32.10", 0), peer(0, "98.76.54.32", 0), 0xec2d7224},
+		{peer(0, "123.213.32.10", 0), peer(0, "123.213.32.234", 0), 0x99568189},
+	}
+
+	for _, tt := range table {
+		t.Run(fmt.Sprintf("%s %s", tt.a.IP, tt.b.IP), func(t *testing.T) {
+			require.Equal(t, tt.expected, priority(tt.a, tt.b))
+			require.Equal(t, tt.expected, priority(tt.b, tt.a))
+		})
+	}
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	_, err := New(Config{Strategy: "best"})
+	require.ErrorIs(t, err, ErrUnknownStrategy)
+}
+
+func TestSelectTiers(t *testing.T) {
+	s, err := New(Config{})
+	require.Nil(t, err)
+
+	announcer := peer(1, "192.0.2.1", 6881)
+	seeders := candidates(peer(2, "192.0.2.2", 6881), peer(3, "192.0.2.3", 6881))
+	leechers := candidates(announcer, peer(4, "192.0.2.4", 6881), peer(5, "192.0.2.5", 6881))
+
+	peers := peersOf(s.Select(Announcer{Peer: announcer}, 3, seeders, leechers))
+	require.Len(t, peers, 3)
+	require.ElementsMatch(t, []bittorrent.Peer{seeders[0].Peer, seeders[1].Peer}, peers[:2])
+	require.NotEqual(t, announcer.ID, peers[2].ID)
+}
+
+func TestSampleTiers(t *testing.T) {
+	s, err := New(Config{})
+	require.Nil(t, err)
+	require.False(t, s.Sorts())
+
+	announcer := peer(1, "192.0.2.1", 6881)
+	seeders := candidates(peer(2, "192.0.2.2", 6881), peer(3, "192.0.2.3", 6881))
+	leechers := candidates(announcer, peer(4, "192.0.2.4", 6881), peer(5, "192.0.2.5", 6881))
+
+	sample := s.NewSample(Announcer{Peer: announcer}, 3)
+	for _, tier := range [][]Candidate{seeders, leechers} {
+		require.False(t, sample.Full())
+		for _, c := range tier {
+			sample.Offer(c)
+		}
+		sample.NextTier()
+	}
+	require.True(t, sample.Full())
+
+	peers := peersOf(sample.Selected())
+	require.Len(t, peers, 3)
+	require.ElementsMatch(t, []bittorrent.Peer{seeders[0].Peer, seeders[1].Peer}, peers[:2])
+	require.NotEqual(t, announcer.ID, peers[2].ID)
+}
+
+func TestSampleWindow(t *testing.T) {
+	s, err := New(Config{})
+	require.Nil(t, err)
+
+	var tier []Candidate
+	for i := 0; i < 4*sampleWindow; i++ {
+		tier = append(tier, Candidate{Peer: peer(byte(i+2), "192.0.2.2", uint16(i))})
+	}
+
+	// Only the candidates of the window are considered, each of them is
+	// selected at times.
+	counts := make(map[bittorrent.PeerID]int)
+	for i := 0; i < 1000; i++ {
+		sample := s.NewSample(Announcer{Peer: peer(1, "192.0.2.1", 6881)}, 2)
+		offered := 0
+		for _, c := range tier {
+			if sample.TierDone() {
+				break
+			}
+			sample.Offer(c)
+			offered++
+		}
+		require.Equal(t, 2*sampleWindow, offered)
+		require.True(t, sample.TierDone())
+		sample.NextTier()
+
+		for _, c := range sample.Selected() {
+			counts[c.Peer.ID]++
+		}
+	}
+	require.Len(t, counts, 2*sampleWindow)
+}
+
+func TestExcludeSameUser(t *testing.T) {
+	s, err := New(Config{ExcludeSameUser: true})
+	require.Nil(t, err)
+	require.True(t, s.NeedsMetadata())
+
+	own := Candidate{Peer: peer(2, "192.0.2.2", 6881), Metadata: &storage.PeerMetadata{UserID: "42"}}
+	other := Candidate{Peer: peer(3, "192.0.2.3", 6881), Metadata: &storage.PeerMetadata{UserID: "43"}}
+	unknown := Candidate{Peer: peer(4, "192.0.2.4", 6881)}
+
+	a := Announcer{Peer: peer(1, "192.0.2.1", 6881), Metadata: &storage.PeerMetadata{UserID: "42"}}
+	peers := peersOf(s.Select(a, 10, []Candidate{own, other, unknown}))
+	require.ElementsMatch(t, []bittorrent.Peer{other.Peer, unknown.Peer}, peers)
+}
+
+func TestPrivatePeers(t *testing.T) {
+	lan := Candidate{Peer: peer(2, "192.168.1.10", 6881), Metadata: &storage.PeerMetadata{SourceIP: net.ParseIP("198.51.100.1")}}
+	unknown := Candidate{Peer: peer(3, "10.0.0.2", 6881)}
+	public := Candidate{Peer: peer(4, "192.0.2.4", 6881)}
+
+	table := []struct {
+		policy   string
+		sourceIP string
+		expected []bittorrent.Peer
+	}{
+		{PrivateSameIP, "198.51.100.1", []bittorrent.Peer{lan.Peer, public.Peer}},
+		{PrivateSameIP, "198.51.100.2", []bittorrent.Peer{public.Peer}},
+		{PrivateSameNetwork, "198.51.100.2", []bittorrent.Peer{lan.Peer, public.Peer}},
+		{PrivateSameNetwork, "203.0.113.1", []bittorrent.Peer{public.Peer}},
+		{PrivateDeny, "198.51.100.1", []bittorrent.Peer{public.Peer}},
+		{PrivateAllow, "203.0.113.1", []bittorrent.Peer{lan.Peer, unknown.Peer, public.Peer}},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.policy+" "+tt.sourceIP, func(t *testing.T) {
+			s, err := New(Config{PrivatePeers: tt.policy})
+			require.Nil(t, err)
+
+			a := Announcer{Peer: peer(1, "192.168.1.11", 6881), Metadata: &storage.PeerMetadata{SourceIP: net.ParseIP(tt.sourceIP)}}
+			peers := peersOf(s.Select(a, 10, []Candidate{lan, unknown, public}))
+			require.ElementsMatch(t, tt.expected, peers)
+		})
+	}
+
+	_, err := New(Config{PrivatePeers: "sometimes"})
+	require.ErrorIs(t, err, ErrUnknownPrivatePeersPolicy)
+}
+
+func TestPrivateSwarm(t *testing.T) {
+	// All peers of a tracker on a LAN are private.
+	s, err := New(Config{})
+	require.Nil(t, err)
+	require.False(t, s.FiltersPrivatePeers())
+
+	a := Announcer{Peer: peer(1, "192.168.1.10", 6881), Metadata: &storage.PeerMetadata{SourceIP: net.ParseIP("192.168.1.10")}}
+	tier := candidates(peer(2, "192.168.1.11", 6881), peer(3, "192.168.1.12", 6881))
+	require.ElementsMatch(t, peersOf(tier), peersOf(s.Select(a, 10, tier)))
+}
+
+func TestStrategies(t *testing.T) {
+	dir := t.TempDir()
+	asnFile := filepath.Join(dir, "pfx2as")
+	require.Nil(t, os.WriteFile(asnFile, []byte("198.51.100.0\t24\t64500\n203.0.113.0\t24\t64500\n2001:db8::\t32\t64501_64502\n"), 0o600))
+
+	announcer := peer(1, "198.51.100.1", 6881)
+	table := []struct {
+		cfg      Config
+		tier     []Candidate
+		expected bittorrent.Peer
+	}{
+		{
+			cfg: Config{Strategy: Locality},
+			tier: candidates(
+				peer(2, "192.0.2.1", 6881),
+				peer(3, "198.51.100.2", 6881),
+				peer(4, "203.0.113.1", 6881),
+			),
+			expected: peer(3, "198.51.100.2", 6881),
+		},
+		{
+			cfg: Config{Strategy: Locality, ASNFile: asnFile},
+			tier: candidates(
+				peer(2, "192.0.2.1", 6881),
+				peer(4, "203.0.113.1", 6881),
+			),
+			expected: peer(4, "203.0.113.1", 6881),
+		},
+		{
+			cfg: Config{Strategy: Recent},
+			tier: []Candidate{
+				{Peer: peer(2, "192.0.2.1", 6881), LastSeen: 1},
+				{Peer: peer(3, "192.0.2.2", 6881), LastSeen: 3},
+				{Peer: peer(4, "192.0.2.3", 6881), LastSeen: 2},
+			},
+			expected: peer(3, "192.0.2.2", 6881),
+		},
+		{
+			cfg: Config{Strategy: Connectable},
+			tier: []Candidate{
+				{Peer: peer(2, "192.0.2.1", 6881)},
+				{Peer: peer(3, "192.0.2.2", 6881), Metadata: &storage.PeerMetadata{Connectable: true}},
+				{Peer: peer(4, "192.0.2.3", 6881), Metadata: &storage.PeerMetadata{}},
+			},
+			expected: peer(3, "192.0.2.2", 6881),
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.cfg.Strategy, func(t *testing.T) {
+			s, err := New(tt.cfg)
+			require.Nil(t, err)
+
+			peers := peersOf(s.Select(Announcer{Peer: announcer}, 1, tt.tier))
+			require.Equal(t, []bittorrent.Peer{tt.expected}, peers)
+		})
+	}
+}
+
+func TestBEP40Order(t *testing.T) {
+	s, err := New(Config{Strategy: BEP40})
+	require.Nil(t, err)
+
+	announcer := peer(1, "123.213.32.10", 6881)
+	low, high := peer(2, "123.213.32.234", 6881), peer(3, "98.76.54.32", 6881)
+
+	peers := peersOf(s.Select(Announcer{Peer: announcer}, 2, candidates(low, high)))
+	require.Equal(t, []bittorrent.Peer{high, low}, peers)
+}
+
+func TestASNLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pfx2as")
+	require.Nil(t, os.WriteFile(path, []byte("# comment\n10.0.0.0 8 1\n10.1.0.0 16 2\n2001:db8:: 32 3,4\n"), 0o600))
+
+	asns, err := loadASNTable(path)
+	require.Nil(t, err)
+
+	for ip, expected := range map[string]uint32{"10.2.3.4": 1, "10.1.2.3": 2, "2001:db8::1": 3} {
+		asn, ok := asns.lookup(net.ParseIP(ip))
+		require.True(t, ok, ip)
+		require.Equal(t, expected, asn, ip)
+	}
+	_, ok := asns.lookup(net.ParseIP("192.0.2.1"))
+	require.False(t, ok)
+
+	require.Nil(t, os.WriteFile(path, []byte("10.0.0.0 33 1\n"), 0o600))
+	_, err = loadASNTable(path)
+	require.NotNil(t, err)
+}
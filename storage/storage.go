@@ -3,7 +3,9 @@ package storage
 
 import (
 	"errors"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/pkg/log"
@@ -50,6 +52,8 @@ var ErrDriverDoesNotExist = errors.New("peer store driver with that name does no
 type PeerStore interface {
 	// PutSeeder adds a Seeder to the Swarm identified by the provided
 	// InfoHash.
+	//
+	// If the Peer is present as a partial seed, it is removed as such.
 	PutSeeder(infoHash bittorrent.InfoHash, p bittorrent.Peer) error
 
 	// DeleteSeeder removes a Seeder from the Swarm identified by the
@@ -62,6 +66,7 @@ type PeerStore interface {
 	// PutLeecher adds a Leecher to the Swarm identified by the provided
 	// InfoHash.
 	// If the Swarm does not exist already, it is created.
+	// If the Peer is present as a partial seed, it is removed as such.
 	PutLeecher(infoHash bittorrent.InfoHash, p bittorrent.Peer) error
 
 	// DeleteLeecher removes a Leecher from the Swarm identified by the
@@ -76,8 +81,24 @@ type PeerStore interface {
 	//
 	// If the given Peer is not present as a Leecher or the swarm does not exist
 	// already, the Peer is added as a Seeder and no error is returned.
+	// If the Peer is present as a partial seed, it is removed as such.
 	GraduateLeecher(infoHash bittorrent.InfoHash, p bittorrent.Peer) error
 
+	// PutPartialSeed adds a partial seed (BEP 21), a Peer that does not
+	// download any more, to the Swarm identified by the provided InfoHash.
+	// If the Swarm does not exist already, it is created.
+	// If the Peer is present as a Leecher, it is removed as such.
+	//
+	// Partial seeds are never returned to Leechers by AnnouncePeers.
+	PutPartialSeed(infoHash bittorrent.InfoHash, p bittorrent.Peer) error
+
+	// DeletePartialSeed removes a partial seed from the Swarm identified by
+	// the provided InfoHash.
+	//
+	// If the Swarm or Peer does not exist, this function returns
+	// ErrResourceDoesNotExist.
+	DeletePartialSeed(infoHash bittorrent.InfoHash, p bittorrent.Peer) error
+
 	// AnnouncePeers is a best effort attempt to return Peers from the Swarm
 	// identified by the provided InfoHash.
 	// The numWant parameter indicates the number of peers requested by the
@@ -99,8 +120,9 @@ type PeerStore interface {
 	// about a Swarm identified by the given InfoHash.
 	// The AddressFamily indicates whether or not the IPv6 swarm should be
 	// scraped.
-	// The Complete and Incomplete fields of the Scrape must be filled,
-	// filling the Snatches field is optional.
+	// The Complete, Incomplete and Downloaders fields of the Scrape must be
+	// filled, filling the Snatches field is optional. Partial seeds count as
+	// Incomplete, but not as Downloaders.
 	//
 	// If the Swarm does not exist, an empty Scrape and no error is returned.
 	ScrapeSwarm(infoHash bittorrent.InfoHash, addressFamily bittorrent.AddressFamily) bittorrent.Scrape
@@ -115,6 +137,92 @@ type PeerStore interface {
 	log.Fielder
 }
 
+// PeerMetadata is information about a Peer beyond its endpoint.
+type PeerMetadata struct {
+	ClientID bittorrent.ClientID
+
+	// UserID is the ID of the user the Peer belongs to, if known.
+	UserID string
+
+	// Left, Uploaded and Downloaded are the values of the last announce.
+	Left       uint64
+	Uploaded   uint64
+	Downloaded uint64
+
+	// Connectable reports whether the Peer accepts incoming connections.
+	Connectable bool
+
+	// ConnectableKnown reports whether Connectable is known.
+	ConnectableKnown bool
+
+	// SourceIP is the IP address the last announce was observed from, nil
+	// if unknown. For Peers with a private IP address, it identifies their
+	// network.
+	SourceIP net.IP
+
+	// FirstSeen is the time the metadata was first stored for the Peer.
+	FirstSeen time.Time
+}
+
+// PeerMetadataStore is an optional extension of PeerStore that stores
+// PeerMetadata for Peers.
+//
+// Metadata is removed together with its Peer, when the Peer is deleted or
+// garbage-collected.
+type PeerMetadataStore interface {
+	PeerStore
+
+	// PutPeerMetadata stores metadata for a Peer of the Swarm identified by
+	// the provided InfoHash.
+	// If metadata is stored already, it is replaced, but its FirstSeen is
+	// kept, as is its Connectable if md does not know it. Stores configured
+	// not to store metadata do nothing.
+	//
+	// If the Swarm does not exist, this function returns
+	// ErrResourceDoesNotExist.
+	PutPeerMetadata(infoHash bittorrent.InfoHash, p bittorrent.Peer, md PeerMetadata) error
+
+	// PeerMetadata returns the metadata of a Peer of the Swarm identified by
+	// the provided InfoHash.
+	//
+	// If no metadata is stored, this function returns
+	// ErrResourceDoesNotExist.
+	PeerMetadata(infoHash bittorrent.InfoHash, p bittorrent.Peer) (PeerMetadata, error)
+
+	// AnnouncePeersWithMetadata is like AnnouncePeers, but selects the Peers
+	// for an announcing Peer with the provided metadata instead of the stored
+	// one, which is missing on its first announce.
+	AnnouncePeersWithMetadata(infoHash bittorrent.InfoHash, seeder bool, numWant int, p bittorrent.Peer, md PeerMetadata) (peers []bittorrent.Peer, err error)
+}
+
+// PeerKeyStore is an optional extension of PeerStore that stores the keys
+// (BEP 3) Peers announced with, which are required to enforce them.
+type PeerKeyStore interface {
+	PeerStore
+
+	// PutPeerKey stores the key (BEP 3) a Peer announced with to the Swarm
+	// identified by the provided InfoHash, together with the Peer.
+	// A key and Peer stored for the same PeerID and AddressFamily are
+	// replaced. The key is empty for Peers that announced without one.
+	//
+	// Keys expire like the Peers they belong to.
+	PutPeerKey(infoHash bittorrent.InfoHash, p bittorrent.Peer, key string) error
+
+	// PeerKey returns the key and the Peer stored for the PeerID and
+	// AddressFamily of the provided Peer in the Swarm identified by the
+	// provided InfoHash.
+	//
+	// If no key is stored, this function returns ErrResourceDoesNotExist.
+	PeerKey(infoHash bittorrent.InfoHash, p bittorrent.Peer) (key string, stored bittorrent.Peer, err error)
+
+	// DeletePeerKey removes the key stored for the PeerID and AddressFamily
+	// of the provided Peer from the Swarm identified by the provided
+	// InfoHash.
+	//
+	// If no key is stored, this function returns ErrResourceDoesNotExist.
+	DeletePeerKey(infoHash bittorrent.InfoHash, p bittorrent.Peer) error
+}
+
 // RegisterDriver makes a Driver available by the provided name.
 //
 // If called twice with the same name, the name is blank, or if the provided
@@ -3,6 +3,7 @@ package storage
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -133,6 +134,172 @@ func TestPeerStore(t *testing.T, p PeerStore) {
 		require.Nil(t, err)
 		require.True(t, containsPeer(peers, c.peer))
 
+		// Test PutLeecher -> PutPartialSeed -> Announce -> Scrape -> PutLeecher
+
+		err = p.DeleteSeeder(c.ih, c.peer)
+		require.Nil(t, err)
+
+		err = p.PutLeecher(c.ih, c.peer)
+		require.Nil(t, err)
+
+		err = p.PutPartialSeed(c.ih, c.peer)
+		require.Nil(t, err)
+
+		// Leechers never get partial seeds.
+		peers, err = p.AnnouncePeers(c.ih, false, 50, peer)
+		require.Nil(t, err)
+		require.False(t, containsPeer(peers, c.peer))
+
+		// Partial seeds are incomplete, but not downloading.
+		scrape = p.ScrapeSwarm(c.ih, c.peer.IP.AddressFamily)
+		require.Equal(t, uint32(2), scrape.Incomplete)
+		require.Equal(t, uint32(1), scrape.Downloaders)
+		require.Equal(t, uint32(0), scrape.Complete)
+
+		// Resuming the download makes it a leecher again.
+		err = p.PutLeecher(c.ih, c.peer)
+		require.Nil(t, err)
+
+		err = p.DeletePartialSeed(c.ih, c.peer)
+		require.Equal(t, ErrResourceDoesNotExist, err)
+
+		scrape = p.ScrapeSwarm(c.ih, c.peer.IP.AddressFamily)
+		require.Equal(t, uint32(2), scrape.Incomplete)
+		require.Equal(t, uint32(2), scrape.Downloaders)
+
+		err = p.PutPartialSeed(c.ih, c.peer)
+		require.Nil(t, err)
+
+		// Completing the download makes it a seeder.
+		err = p.PutSeeder(c.ih, c.peer)
+		require.Nil(t, err)
+
+		err = p.DeletePartialSeed(c.ih, c.peer)
+		require.Equal(t, ErrResourceDoesNotExist, err)
+
+		// Test PutPeerKey -> PeerKey -> PutPeerKey -> DeletePeerKey
+
+		if kp, ok := p.(PeerKeyStore); ok {
+			_, _, err = kp.PeerKey(c.ih, c.peer)
+			require.Equal(t, ErrResourceDoesNotExist, err)
+
+			err = kp.PutPeerKey(c.ih, c.peer, "key one")
+			require.Nil(t, err)
+
+			key, stored, err := kp.PeerKey(c.ih, c.peer)
+			require.Nil(t, err)
+			require.Equal(t, "key one", key)
+			require.True(t, PeerEqualityFunc(c.peer, stored))
+
+			// Keys are stored by peer ID, a new endpoint replaces the old one.
+			moved := c.peer
+			moved.Port++
+			err = kp.PutPeerKey(c.ih, moved, "key two")
+			require.Nil(t, err)
+
+			key, stored, err = kp.PeerKey(c.ih, c.peer)
+			require.Nil(t, err)
+			require.Equal(t, "key two", key)
+			require.True(t, PeerEqualityFunc(moved, stored))
+
+			// Other peers have no key.
+			_, _, err = kp.PeerKey(c.ih, peer)
+			require.Equal(t, ErrResourceDoesNotExist, err)
+
+			err = kp.DeletePeerKey(c.ih, c.peer)
+			require.Nil(t, err)
+
+			err = kp.DeletePeerKey(c.ih, c.peer)
+			require.Equal(t, ErrResourceDoesNotExist, err)
+		}
+
+		// Test PutPeerMetadata -> PeerMetadata -> PutPeerMetadata -> DeleteSeeder
+
+		if mp, ok := p.(PeerMetadataStore); ok {
+			_, err = mp.PeerMetadata(c.ih, c.peer)
+			require.Equal(t, ErrResourceDoesNotExist, err)
+
+			err = mp.PutPeerMetadata(bittorrent.InfoHashFromString("99999999999999999999"), c.peer, PeerMetadata{})
+			require.Equal(t, ErrResourceDoesNotExist, err)
+
+			firstSeen := time.Unix(1600000000, 0)
+			md := PeerMetadata{
+				ClientID:         bittorrent.NewClientID(c.peer.ID),
+				UserID:           "42",
+				Left:             1,
+				Uploaded:         2,
+				Downloaded:       3,
+				Connectable:      true,
+				ConnectableKnown: true,
+				SourceIP:         net.ParseIP("203.0.113.7"),
+				FirstSeen:        firstSeen,
+			}
+			err = mp.PutPeerMetadata(c.ih, c.peer, md)
+			require.Nil(t, err)
+
+			stored, err := mp.PeerMetadata(c.ih, c.peer)
+			require.Nil(t, err)
+			require.True(t, stored.FirstSeen.Equal(firstSeen))
+			stored.FirstSeen = firstSeen
+			require.Equal(t, md, stored)
+
+			// The first time a peer was seen is kept, as is whether it is
+			// connectable unless it is known.
+			md.Left = 0
+			md.FirstSeen = firstSeen.Add(time.Hour)
+			md.Connectable, md.ConnectableKnown = false, false
+			err = mp.PutPeerMetadata(c.ih, c.peer, md)
+			require.Nil(t, err)
+
+			stored, err = mp.PeerMetadata(c.ih, c.peer)
+			require.Nil(t, err)
+			require.Equal(t, uint64(0), stored.Left)
+			require.True(t, stored.FirstSeen.Equal(firstSeen))
+			require.True(t, stored.Connectable)
+
+			md.ConnectableKnown = true
+			err = mp.PutPeerMetadata(c.ih, c.peer, md)
+			require.Nil(t, err)
+
+			stored, err = mp.PeerMetadata(c.ih, c.peer)
+			require.Nil(t, err)
+			require.False(t, stored.Connectable)
+
+			// Metadata is deleted with its peer.
+			err = p.DeleteSeeder(c.ih, c.peer)
+			require.Nil(t, err)
+
+			_, err = mp.PeerMetadata(c.ih, c.peer)
+			require.Equal(t, ErrResourceDoesNotExist, err)
+
+			err = p.PutSeeder(c.ih, c.peer)
+			require.Nil(t, err)
+
+			// Stores under test filter private peers by the same_ip policy:
+			// they are only returned to announcers observed from the same IP
+			// address.
+			lan := bittorrent.Peer{ID: bittorrent.PeerIDFromString("99999999999999999998"), IP: bittorrent.IP{IP: net.ParseIP("192.168.1.10").To4(), AddressFamily: bittorrent.IPv4}, Port: 9998}
+			if c.peer.IP.AddressFamily == bittorrent.IPv6 {
+				lan.IP = bittorrent.IP{IP: net.ParseIP("fd00::10"), AddressFamily: bittorrent.IPv6}
+			}
+			err = p.PutLeecher(c.ih, lan)
+			require.Nil(t, err)
+
+			err = mp.PutPeerMetadata(c.ih, lan, PeerMetadata{SourceIP: net.ParseIP("203.0.113.7")})
+			require.Nil(t, err)
+
+			peers, err = mp.AnnouncePeersWithMetadata(c.ih, true, 50, c.peer, PeerMetadata{SourceIP: net.ParseIP("198.51.100.1")})
+			require.Nil(t, err)
+			require.False(t, containsPeer(peers, lan))
+
+			peers, err = mp.AnnouncePeersWithMetadata(c.ih, true, 50, c.peer, PeerMetadata{SourceIP: net.ParseIP("203.0.113.7")})
+			require.Nil(t, err)
+			require.True(t, containsPeer(peers, lan))
+
+			err = p.DeleteLeecher(c.ih, lan)
+			require.Nil(t, err)
+		}
+
 		// Clean up
 
 		err = p.DeleteLeecher(c.ih, peer)